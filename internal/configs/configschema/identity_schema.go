@@ -0,0 +1,202 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package configschema
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+
+	"github.com/hashicorp/terraform/internal/lang/marks"
+)
+
+// ImportDecoderSpec returns an hcldec.Spec for decoding the attributes of
+// this identity schema as written in an import configuration block.
+//
+// For identity schemas the Required and Optional flags on each attribute
+// represent "required for import" and "optional for import" respectively, so
+// the returned spec demands exactly the attributes that an import
+// configuration must set, unlike the spec used when communicating with the
+// provider where every attribute is decoded permissively.
+func (o *Object) ImportDecoderSpec() hcldec.Spec {
+	ret := hcldec.ObjectSpec{}
+	for name, attr := range o.Attributes {
+		ret[name] = &hcldec.AttrSpec{
+			Name:     name,
+			Type:     attr.Type,
+			Required: attr.Required,
+		}
+	}
+	return ret
+}
+
+// MarkIdentitySensitive returns the given identity value with any attributes
+// that this schema declares as sensitive carrying the sensitive mark.
+//
+// Identity attributes reuse the Sensitive flag from the ordinary attribute
+// schema, but hcldec specs have no way to express marks, so values decoded
+// with [Object.ImportDecoderSpec] arrive unmarked and callers must pass them
+// through here before displaying or storing them.
+func (o *Object) MarkIdentitySensitive(val cty.Value) cty.Value {
+	return marks.MarkPaths(val, marks.Sensitive, o.SensitivePaths(val, nil))
+}
+
+// ValidateIdentityAttributes checks that no attribute of this identity
+// schema uses a map, set, or object type at any level of nesting.
+//
+// Identity values must be flat enough to compare and encode predictably, so
+// only primitive types and lists or tuples thereof are allowed. This walks
+// each attribute's type fully, rather than just the top level, so a provider
+// cannot smuggle a disallowed shape inside a list or tuple. The error names
+// the full path to the offending attribute so provider authors can find it.
+func (o *Object) ValidateIdentityAttributes() error {
+	for name, attr := range o.Attributes {
+		if err := validateIdentityAttrType(attr.Type, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateIdentityAttrType(ty cty.Type, path string) error {
+	switch {
+	case ty.IsMapType():
+		return fmt.Errorf("attribute %q is a map, which is not allowed in identity schemas", path)
+	case ty.IsSetType():
+		return fmt.Errorf("attribute %q is a set, which is not allowed in identity schemas", path)
+	case ty.IsObjectType():
+		return fmt.Errorf("attribute %q is an object, which is not allowed in identity schemas", path)
+	case ty.IsListType():
+		return validateIdentityAttrType(ty.ElementType(), path+"[*]")
+	case ty.IsTupleType():
+		for i, ety := range ty.TupleElementTypes() {
+			if err := validateIdentityAttrType(ety, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateImportAttributes checks that every attribute of this identity
+// schema is marked as exactly one of required or optional for import.
+//
+// An attribute that is neither can never be decoded from an import
+// configuration and an attribute that is both is ambiguous, so either
+// combination is meaningless and indicates a bug in the provider.
+func (o *Object) ValidateImportAttributes() error {
+	for name, attr := range o.Attributes {
+		if !attr.Required && !attr.Optional {
+			return fmt.Errorf("identity attribute %q is neither required nor optional for import, which is a bug in the provider", name)
+		}
+		if attr.Required && attr.Optional {
+			return fmt.Errorf("identity attribute %q is both required and optional for import, which is a bug in the provider", name)
+		}
+	}
+	return nil
+}
+
+// IdentityAttributesEqual reports whether this identity schema declares
+// exactly the same attributes as the other one, comparing each attribute's
+// type, description, import flags, and sensitivity.
+//
+// This is the comparison to use when deciding whether a stored identity
+// schema still matches what the provider currently declares, rather than
+// comparing decoded identity values. Two nil schemas are equal, and a nil
+// schema is never equal to a non-nil one.
+func (o *Object) IdentityAttributesEqual(other *Object) bool {
+	if o == nil || other == nil {
+		return o == other
+	}
+	if len(o.Attributes) != len(other.Attributes) {
+		return false
+	}
+	for name, attr := range o.Attributes {
+		otherAttr, ok := other.Attributes[name]
+		if !ok {
+			return false
+		}
+		if !attr.Type.Equals(otherAttr.Type) {
+			return false
+		}
+		if attr.Description != otherAttr.Description {
+			return false
+		}
+		if attr.Required != otherAttr.Required || attr.Optional != otherAttr.Optional {
+			return false
+		}
+		if attr.Sensitive != otherAttr.Sensitive {
+			return false
+		}
+	}
+	return true
+}
+
+// identityAttributeJSON is the wire representation of a single identity
+// attribute used by [Object.MarshalIdentityJSON] and
+// [UnmarshalIdentityJSON].
+type identityAttributeJSON struct {
+	Type              json.RawMessage `json:"type"`
+	Description       string          `json:"description,omitempty"`
+	RequiredForImport bool            `json:"required_for_import,omitempty"`
+	OptionalForImport bool            `json:"optional_for_import,omitempty"`
+	Sensitive         bool            `json:"sensitive,omitempty"`
+}
+
+// MarshalIdentityJSON encodes the attributes of this identity schema as a
+// JSON object mapping each attribute name to its type, description, and
+// import flags, with types encoded using the standard cty JSON type encoding.
+//
+// The result can be decoded back into an equivalent schema with
+// [UnmarshalIdentityJSON], so external tooling can both read and write
+// identity schemas. This is the identity-schema counterpart of the block
+// schema serialization in the jsonprovider package, but unlike that one it is
+// designed to round-trip.
+func (o *Object) MarshalIdentityJSON() ([]byte, error) {
+	attrs := make(map[string]*identityAttributeJSON, len(o.Attributes))
+	for name, attr := range o.Attributes {
+		ty, err := ctyjson.MarshalType(attr.Type)
+		if err != nil {
+			return nil, fmt.Errorf("encoding type of identity attribute %q: %w", name, err)
+		}
+		attrs[name] = &identityAttributeJSON{
+			Type:              json.RawMessage(ty),
+			Description:       attr.Description,
+			RequiredForImport: attr.Required,
+			OptionalForImport: attr.Optional,
+			Sensitive:         attr.Sensitive,
+		}
+	}
+	return json.Marshal(attrs)
+}
+
+// UnmarshalIdentityJSON decodes a JSON object previously produced by
+// [Object.MarshalIdentityJSON] back into an identity schema.
+func UnmarshalIdentityJSON(data []byte) (*Object, error) {
+	var attrs map[string]*identityAttributeJSON
+	if err := json.Unmarshal(data, &attrs); err != nil {
+		return nil, err
+	}
+	ret := &Object{
+		Nesting:    NestingSingle,
+		Attributes: make(map[string]*Attribute, len(attrs)),
+	}
+	for name, attr := range attrs {
+		ty, err := ctyjson.UnmarshalType([]byte(attr.Type))
+		if err != nil {
+			return nil, fmt.Errorf("decoding type of identity attribute %q: %w", name, err)
+		}
+		ret.Attributes[name] = &Attribute{
+			Type:        ty,
+			Description: attr.Description,
+			Required:    attr.RequiredForImport,
+			Optional:    attr.OptionalForImport,
+			Sensitive:   attr.Sensitive,
+		}
+	}
+	return ret, nil
+}
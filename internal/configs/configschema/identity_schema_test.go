@@ -0,0 +1,304 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package configschema
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hashicorp/terraform/internal/lang/marks"
+)
+
+func TestObjectImportDecoderSpec(t *testing.T) {
+	obj := &Object{
+		Nesting: NestingSingle,
+		Attributes: map[string]*Attribute{
+			"id": {
+				Type:     cty.String,
+				Required: true,
+			},
+			"region": {
+				Type:     cty.String,
+				Optional: true,
+			},
+		},
+	}
+
+	spec, ok := obj.ImportDecoderSpec().(hcldec.ObjectSpec)
+	if !ok {
+		t.Fatalf("expected hcldec.ObjectSpec, got %T", obj.ImportDecoderSpec())
+	}
+
+	id, ok := spec["id"].(*hcldec.AttrSpec)
+	if !ok || !id.Required {
+		t.Errorf("attribute %q should be required for import", "id")
+	}
+	region, ok := spec["region"].(*hcldec.AttrSpec)
+	if !ok || region.Required {
+		t.Errorf("attribute %q should be optional for import", "region")
+	}
+}
+
+func TestObjectMarkIdentitySensitive(t *testing.T) {
+	obj := &Object{
+		Nesting: NestingSingle,
+		Attributes: map[string]*Attribute{
+			"id": {
+				Type:     cty.String,
+				Required: true,
+			},
+			"token": {
+				Type:      cty.String,
+				Required:  true,
+				Sensitive: true,
+			},
+		},
+	}
+
+	src := "id = \"i-123\"\ntoken = \"secret\"\n"
+	f, diags := hclsyntax.ParseConfig([]byte(src), "test.tf", hcl.InitialPos)
+	if diags.HasErrors() {
+		t.Fatalf("unexpected parse errors: %s", diags)
+	}
+	val, decDiags := hcldec.Decode(f.Body, obj.ImportDecoderSpec(), nil)
+	if decDiags.HasErrors() {
+		t.Fatalf("unexpected decode errors: %s", decDiags)
+	}
+
+	got := obj.MarkIdentitySensitive(val)
+	if !got.GetAttr("token").HasMark(marks.Sensitive) {
+		t.Errorf("attribute %q should be marked as sensitive after decode", "token")
+	}
+	if got.GetAttr("id").IsMarked() {
+		t.Errorf("attribute %q should not carry any marks", "id")
+	}
+}
+
+func TestObjectValidateIdentityAttributes(t *testing.T) {
+	tests := map[string]struct {
+		ty      cty.Type
+		wantErr string
+	}{
+		"primitive": {
+			ty: cty.String,
+		},
+		"list of primitives": {
+			ty: cty.List(cty.Number),
+		},
+		"map": {
+			ty:      cty.Map(cty.String),
+			wantErr: `attribute "attr" is a map, which is not allowed in identity schemas`,
+		},
+		"set": {
+			ty:      cty.Set(cty.String),
+			wantErr: `attribute "attr" is a set, which is not allowed in identity schemas`,
+		},
+		"object": {
+			ty:      cty.EmptyObject,
+			wantErr: `attribute "attr" is an object, which is not allowed in identity schemas`,
+		},
+		"list of objects": {
+			ty:      cty.List(cty.Object(map[string]cty.Type{"id": cty.String})),
+			wantErr: `attribute "attr[*]" is an object, which is not allowed in identity schemas`,
+		},
+		"tuple smuggling a map": {
+			ty:      cty.Tuple([]cty.Type{cty.String, cty.Map(cty.String)}),
+			wantErr: `attribute "attr[1]" is a map, which is not allowed in identity schemas`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			obj := &Object{
+				Nesting: NestingSingle,
+				Attributes: map[string]*Attribute{
+					"attr": {Type: test.ty, Required: true},
+				},
+			}
+			err := obj.ValidateIdentityAttributes()
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got none")
+			}
+			if err.Error() != test.wantErr {
+				t.Errorf("wrong error\ngot:  %s\nwant: %s", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestObjectValidateImportAttributes(t *testing.T) {
+	tests := map[string]struct {
+		attr    *Attribute
+		wantErr bool
+	}{
+		"required": {
+			attr: &Attribute{Type: cty.String, Required: true},
+		},
+		"optional": {
+			attr: &Attribute{Type: cty.String, Optional: true},
+		},
+		"neither": {
+			attr:    &Attribute{Type: cty.String},
+			wantErr: true,
+		},
+		"both": {
+			attr:    &Attribute{Type: cty.String, Required: true, Optional: true},
+			wantErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			obj := &Object{
+				Nesting: NestingSingle,
+				Attributes: map[string]*Attribute{
+					"attr": test.attr,
+				},
+			}
+			err := obj.ValidateImportAttributes()
+			if test.wantErr && err == nil {
+				t.Error("expected error, got none")
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestObjectIdentityAttributesEqual(t *testing.T) {
+	base := func() *Object {
+		return &Object{
+			Nesting: NestingSingle,
+			Attributes: map[string]*Attribute{
+				"id": {
+					Type:        cty.String,
+					Description: "The unique identifier.",
+					Required:    true,
+				},
+				"region": {
+					Type:     cty.String,
+					Optional: true,
+				},
+			},
+		}
+	}
+
+	tests := map[string]struct {
+		mutate func(o *Object)
+		want   bool
+	}{
+		"equal": {
+			mutate: func(o *Object) {},
+			want:   true,
+		},
+		"different type": {
+			mutate: func(o *Object) {
+				o.Attributes["region"].Type = cty.Number
+			},
+			want: false,
+		},
+		"different import flags": {
+			mutate: func(o *Object) {
+				o.Attributes["region"].Optional = false
+				o.Attributes["region"].Required = true
+			},
+			want: false,
+		},
+		"different sensitivity": {
+			mutate: func(o *Object) {
+				o.Attributes["id"].Sensitive = true
+			},
+			want: false,
+		},
+		"extra attribute": {
+			mutate: func(o *Object) {
+				o.Attributes["zone"] = &Attribute{Type: cty.String, Optional: true}
+			},
+			want: false,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			other := base()
+			test.mutate(other)
+			if got := base().IdentityAttributesEqual(other); got != test.want {
+				t.Errorf("wrong result: got %t, want %t", got, test.want)
+			}
+		})
+	}
+
+	var nilObj *Object
+	if !nilObj.IdentityAttributesEqual(nil) {
+		t.Errorf("two nil schemas should be equal")
+	}
+	if nilObj.IdentityAttributesEqual(base()) || base().IdentityAttributesEqual(nil) {
+		t.Errorf("a nil schema should not equal a non-nil one")
+	}
+}
+
+func TestObjectIdentityJSONRoundTrip(t *testing.T) {
+	obj := &Object{
+		Nesting: NestingSingle,
+		Attributes: map[string]*Attribute{
+			"id": {
+				Type:        cty.String,
+				Description: "The unique identifier.",
+				Required:    true,
+			},
+			"zones": {
+				Type:     cty.List(cty.String),
+				Optional: true,
+			},
+			"token": {
+				Type:      cty.String,
+				Optional:  true,
+				Sensitive: true,
+			},
+		},
+	}
+
+	data, err := obj.MarshalIdentityJSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, err := UnmarshalIdentityJSON(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !got.ImpliedType().Equals(obj.ImpliedType()) {
+		t.Errorf("implied type changed after round trip\ngot:  %#v\nwant: %#v", got.ImpliedType(), obj.ImpliedType())
+	}
+	for name, want := range obj.Attributes {
+		gotAttr, ok := got.Attributes[name]
+		if !ok {
+			t.Fatalf("attribute %q missing after round trip", name)
+		}
+		if !gotAttr.Type.Equals(want.Type) {
+			t.Errorf("attribute %q has wrong type after round trip", name)
+		}
+		if gotAttr.Description != want.Description {
+			t.Errorf("attribute %q has wrong description after round trip", name)
+		}
+		if gotAttr.Required != want.Required || gotAttr.Optional != want.Optional {
+			t.Errorf("attribute %q has wrong import flags after round trip", name)
+		}
+		if gotAttr.Sensitive != want.Sensitive {
+			t.Errorf("attribute %q has wrong sensitivity after round trip", name)
+		}
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/hashicorp/terraform/internal/terraform"
 	"github.com/hashicorp/terraform/internal/tfdiags"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
 )
 
 // GetVariables builds the terraform.InputValues required for the provided run
@@ -51,14 +52,45 @@ func (n *NodeTestRun) GetVariables(ctx *EvalContext, includeWarnings bool) (terr
 	// We'll put the parsed values into this map.
 	values := make(terraform.InputValues)
 
-	// First, let's step through the expressions within the run block and work
-	// them out.
-	for name, expr := range run.Config.Variables {
+	// Variables defined within the run block may reference each other, and a
+	// reference to a variable that is defined both in the run block and at
+	// the file (or global) level must resolve to the run-scoped value. We
+	// evaluate the run block's expressions on demand, memoizing the results,
+	// so that evaluation follows the references rather than map order.
+	runValues := make(map[string]*terraform.InputValue)
+	evaluating := make(map[string]bool)
+
+	var evalRunVariable func(name string, expr hcl.Expression) *terraform.InputValue
+	evalRunVariable = func(name string, expr hcl.Expression) *terraform.InputValue {
+		if value, exists := runValues[name]; exists {
+			return value
+		}
+		evaluating[name] = true
+		defer delete(evaluating, name)
+
 		requiredValues := make(map[string]cty.Value)
 
 		refs, refDiags := langrefs.ReferencesInExpr(addrs.ParseRefFromTestingScope, expr)
 		for _, ref := range refs {
 			if addr, ok := ref.Subject.(addrs.InputVariable); ok {
+				// A variable defined in the same run block shadows any
+				// file-level or global variable of the same name.
+				if otherExpr, ok := run.Config.Variables[addr.Name]; ok {
+					if evaluating[addr.Name] {
+						diags = diags.Append(&hcl.Diagnostic{
+							Severity: hcl.DiagError,
+							Summary:  "Cyclic variable reference",
+							Detail:   fmt.Sprintf("The variable %q within run block %q refers to itself, possibly through a chain of other variables.", addr.Name, run.Name),
+							Subject:  ref.SourceRange.ToHCL().Ptr(),
+						})
+						continue
+					}
+					if value := evalRunVariable(addr.Name, otherExpr); value != nil {
+						requiredValues[addr.Name] = value.Value
+					}
+					continue
+				}
+
 				cache := ctx.GetCache(run)
 
 				value, valueDiags := cache.GetFileVariable(addr.Name)
@@ -79,16 +111,29 @@ func (n *NodeTestRun) GetVariables(ctx *EvalContext, includeWarnings bool) (terr
 		}
 		diags = diags.Append(refDiags)
 
-		ctx, ctxDiags := hcltest.EvalContext(hcltest.TargetRunBlock, map[string]hcl.Expression{name: expr}, requiredValues, ctx.GetOutputs())
+		hclCtx, ctxDiags := hcltest.EvalContext(hcltest.TargetRunBlock, map[string]hcl.Expression{name: expr}, requiredValues, ctx.GetOutputs())
 		diags = diags.Append(ctxDiags)
 
 		value := cty.DynamicVal
 		if !ctxDiags.HasErrors() {
 			var valueDiags hcl.Diagnostics
-			value, valueDiags = expr.Value(ctx)
+			value, valueDiags = expr.Value(hclCtx)
 			diags = diags.Append(valueDiags)
 		}
 
+		runValues[name] = &terraform.InputValue{
+			Value:       value,
+			SourceType:  terraform.ValueFromConfig,
+			SourceRange: tfdiags.SourceRangeFromHCL(expr.Range()),
+		}
+		return runValues[name]
+	}
+
+	// First, let's step through the expressions within the run block and work
+	// them out.
+	for name, expr := range run.Config.Variables {
+		value := evalRunVariable(name, expr)
+
 		// We do this late on so we still validate whatever it was that the user
 		// wrote in the variable expression. But, we don't want to actually use
 		// it if it's not actually relevant.
@@ -105,11 +150,7 @@ func (n *NodeTestRun) GetVariables(ctx *EvalContext, includeWarnings bool) (terr
 			continue // Don't add it to our final set of variables.
 		}
 
-		values[name] = &terraform.InputValue{
-			Value:       value,
-			SourceType:  terraform.ValueFromConfig,
-			SourceRange: tfdiags.SourceRangeFromHCL(expr.Range()),
-		}
+		values[name] = value
 	}
 
 	for variable := range relevantVariables {
@@ -137,6 +178,30 @@ func (n *NodeTestRun) GetVariables(ctx *EvalContext, includeWarnings bool) (terr
 		}
 	}
 
+	// Values that correspond to a declaration in the module under test are
+	// coerced to the declared type constraint here, so that a value of the
+	// wrong type is reported against the test file as soon as the variables
+	// are gathered rather than failing deep inside the plan or apply that
+	// consumes them.
+	for name, variable := range run.ModuleConfig.Module.Variables {
+		value, exists := values[name]
+		if !exists || value.Value == cty.NilVal || variable.ConstraintType == cty.NilType {
+			continue
+		}
+
+		converted, err := convert.Convert(value.Value, variable.ConstraintType)
+		if err != nil {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid value for input variable",
+				Detail:   fmt.Sprintf("The given value is not suitable for var.%s declared at %s: %s.", name, variable.DeclRange.String(), err),
+				Subject:  value.SourceRange.ToHCL().Ptr(),
+			})
+			continue
+		}
+		value.Value = converted
+	}
+
 	// Finally, we check the configuration again. This is where we'll discover
 	// if there's any missing variables and fill in any optional variables that
 	// don't have a value already.
@@ -166,8 +231,21 @@ func (n *NodeTestRun) GetVariables(ctx *EvalContext, includeWarnings bool) (terr
 				SourceRange: tfdiags.SourceRangeFromHCL(variable.DeclRange),
 			}
 		} else {
+			// The variable is optional, so if the module declares a default
+			// we can resolve it right away. That makes the default visible
+			// to anything that evaluates these values directly, such as
+			// assertions referencing the variable, rather than leaving it
+			// unset until the main language runtime fills it in.
+			//
+			// Ephemeral variables are deliberately left unset here, because
+			// they are only assigned during the phases that are allowed to
+			// use them.
+			value := cty.NilVal
+			if !variable.Ephemeral {
+				value = variable.Default
+			}
 			values[name] = &terraform.InputValue{
-				Value:       cty.NilVal,
+				Value:       value,
 				SourceType:  terraform.ValueFromConfig,
 				SourceRange: tfdiags.SourceRangeFromHCL(variable.DeclRange),
 			}
@@ -0,0 +1,224 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package graph
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hashicorp/terraform/internal/moduletest"
+)
+
+func TestNodeTestRun_GetVariables_runScope(t *testing.T) {
+	config := testModuleInline(t, map[string]string{
+		"main.tf": `
+			variable "a" {
+				type = string
+			}
+
+			variable "b" {
+				type = string
+			}
+		`,
+		"main.tftest.hcl": `
+			variables {
+				a = "file"
+			}
+
+			run "test_case" {
+				variables {
+					a = "run"
+					b = var.a
+				}
+			}
+		`,
+	})
+
+	file := config.Module.Tests["main.tftest.hcl"]
+	run := &moduletest.Run{
+		Config:       file.Runs[0],
+		Name:         "test_case",
+		ModuleConfig: config,
+	}
+	node := &NodeTestRun{run: run, opts: &graphOptions{}}
+
+	testCtx := NewEvalContext(&EvalContextOpts{
+		CancelCtx: context.Background(),
+		StopCtx:   context.Background(),
+	})
+	testCtx.VariableCaches.FileVariables = file.Variables
+
+	values, diags := node.GetVariables(testCtx, true)
+	if diags.HasErrors() {
+		t.Fatalf("unexpected errors: %s", diags.Err())
+	}
+
+	if got, want := values["a"].Value, cty.StringVal("run"); !want.RawEquals(got) {
+		t.Errorf("wrong value for a: got %#v, want %#v", got, want)
+	}
+
+	// The reference to var.a within the run block must resolve to the
+	// run-scoped value rather than the file-level value it shadows.
+	if got, want := values["b"].Value, cty.StringVal("run"); !want.RawEquals(got) {
+		t.Errorf("wrong value for b: got %#v, want %#v", got, want)
+	}
+}
+
+func TestNodeTestRun_GetVariables_moduleDefault(t *testing.T) {
+	config := testModuleInline(t, map[string]string{
+		"main.tf": `
+			variable "a" {
+				type = string
+			}
+
+			variable "b" {
+				type    = string
+				default = "module_default"
+			}
+		`,
+		"main.tftest.hcl": `
+			variables {
+				a = "file"
+			}
+
+			run "test_case" {}
+		`,
+	})
+
+	file := config.Module.Tests["main.tftest.hcl"]
+	run := &moduletest.Run{
+		Config:       file.Runs[0],
+		Name:         "test_case",
+		ModuleConfig: config,
+	}
+	node := &NodeTestRun{run: run, opts: &graphOptions{}}
+
+	testCtx := NewEvalContext(&EvalContextOpts{
+		CancelCtx: context.Background(),
+		StopCtx:   context.Background(),
+	})
+	testCtx.VariableCaches.FileVariables = file.Variables
+
+	values, diags := node.GetVariables(testCtx, true)
+	if diags.HasErrors() {
+		t.Fatalf("unexpected errors: %s", diags.Err())
+	}
+
+	// The variable is not set in the file or the run block, but the module
+	// declares a default, so the value must resolve to that default rather
+	// than being left unset.
+	if got, want := values["b"].Value, cty.StringVal("module_default"); !want.RawEquals(got) {
+		t.Errorf("wrong value for b: got %#v, want %#v", got, want)
+	}
+}
+
+func TestNodeTestRun_GetVariables_runScopeCycle(t *testing.T) {
+	config := testModuleInline(t, map[string]string{
+		"main.tf": `
+			variable "a" {
+				type = string
+			}
+
+			variable "b" {
+				type = string
+			}
+		`,
+		"main.tftest.hcl": `
+			run "test_case" {
+				variables {
+					a = var.b
+					b = var.a
+				}
+			}
+		`,
+	})
+
+	file := config.Module.Tests["main.tftest.hcl"]
+	run := &moduletest.Run{
+		Config:       file.Runs[0],
+		Name:         "test_case",
+		ModuleConfig: config,
+	}
+	node := &NodeTestRun{run: run, opts: &graphOptions{}}
+
+	testCtx := NewEvalContext(&EvalContextOpts{
+		CancelCtx: context.Background(),
+		StopCtx:   context.Background(),
+	})
+
+	_, diags := node.GetVariables(testCtx, true)
+	if !diags.HasErrors() {
+		t.Fatal("expected errors, got none")
+	}
+
+	found := false
+	for _, diag := range diags {
+		if diag.Description().Summary == "Cyclic variable reference" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected a cyclic variable reference diagnostic, got: %s", diags.Err())
+	}
+}
+
+func TestNodeTestRun_GetVariables_typeMismatch(t *testing.T) {
+	config := testModuleInline(t, map[string]string{
+		"main.tf": `
+			variable "a" {
+				type = number
+			}
+
+			variable "b" {
+				type = number
+			}
+		`,
+		"main.tftest.hcl": `
+			run "test_case" {
+				variables {
+					a = "not-a-number"
+					b = "5"
+				}
+			}
+		`,
+	})
+
+	file := config.Module.Tests["main.tftest.hcl"]
+	run := &moduletest.Run{
+		Config:       file.Runs[0],
+		Name:         "test_case",
+		ModuleConfig: config,
+	}
+	node := &NodeTestRun{run: run, opts: &graphOptions{}}
+
+	testCtx := NewEvalContext(&EvalContextOpts{
+		CancelCtx: context.Background(),
+		StopCtx:   context.Background(),
+	})
+
+	values, diags := node.GetVariables(testCtx, true)
+	if !diags.HasErrors() {
+		t.Fatal("expected errors, got none")
+	}
+
+	// The string for var.a can never be a number, so it must be reported
+	// where the variables are gathered rather than during evaluation.
+	found := false
+	for _, diag := range diags {
+		if diag.Description().Summary == "Invalid value for input variable" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected an invalid value diagnostic, got: %s", diags.Err())
+	}
+
+	// The string for var.b converts cleanly, so it must have been coerced to
+	// the declared number type.
+	if got, want := values["b"].Value, cty.NumberIntVal(5); !want.RawEquals(got) {
+		t.Errorf("wrong value for b: got %#v, want %#v", got, want)
+	}
+}
@@ -4,6 +4,7 @@
 package hcl
 
 import (
+	"fmt"
 	"sync"
 
 	"github.com/hashicorp/hcl/v2"
@@ -54,6 +55,11 @@ type VariableCache struct {
 	globals terraform.InputValues
 	files   terraform.InputValues
 
+	// evaluating tracks the file-level variables currently being evaluated,
+	// so that a chain of references between file-level variables that loops
+	// back on itself can be reported instead of recursing forever.
+	evaluating map[string]bool
+
 	values *VariableCaches // back reference so we can access the stored values
 }
 
@@ -65,10 +71,11 @@ func (caches *VariableCaches) GetCache(name string, config *configs.Config) *Var
 	cache, exists := caches.caches[name]
 	if !exists {
 		cache = &VariableCache{
-			config:  config,
-			globals: make(terraform.InputValues),
-			files:   make(terraform.InputValues),
-			values:  caches,
+			config:     config,
+			globals:    make(terraform.InputValues),
+			files:      make(terraform.InputValues),
+			evaluating: make(map[string]bool),
+			values:     caches,
 		}
 		caches.caches[name] = cache
 	}
@@ -142,10 +149,35 @@ func (cache *VariableCache) GetFileVariable(name string) (*terraform.InputValue,
 
 	var diags tfdiags.Diagnostics
 
+	cache.evaluating[name] = true
+	defer delete(cache.evaluating, name)
+
 	availableVariables := make(map[string]cty.Value)
 	refs, refDiags := langrefs.ReferencesInExpr(addrs.ParseRefFromTestingScope, expr)
 	for _, ref := range refs {
 		if input, ok := ref.Subject.(addrs.InputVariable); ok {
+			// File-level variables can also refer to other file-level
+			// variables, so we check those first. A variable that is
+			// already being evaluated further up the stack means the
+			// references loop back on themselves.
+			if _, ok := cache.values.FileVariables[input.Name]; ok {
+				if cache.evaluating[input.Name] {
+					diags = diags.Append(&hcl.Diagnostic{
+						Severity: hcl.DiagError,
+						Summary:  "Cyclic variable reference",
+						Detail:   fmt.Sprintf("The file-level variable %q refers to itself, possibly through a chain of other variables.", input.Name),
+						Subject:  ref.SourceRange.ToHCL().Ptr(),
+					})
+					continue
+				}
+				variable, variableDiags := cache.GetFileVariable(input.Name)
+				diags = diags.Append(variableDiags)
+				if variable != nil {
+					availableVariables[input.Name] = variable.Value
+				}
+				continue
+			}
+
 			variable, variableDiags := cache.GetGlobalVariable(input.Name)
 			diags = diags.Append(variableDiags)
 			if variable != nil {
@@ -59,6 +59,25 @@ func TestFileVariables(t *testing.T) {
 				"foo": cty.StringVal("baz"),
 			},
 		},
+		"reference_to_file_variable": {
+			Values: map[string]string{
+				"foo": `"bar"`,
+				"baz": "var.foo",
+			},
+			Want: map[string]cty.Value{
+				"baz": cty.StringVal("bar"),
+			},
+		},
+		"chained_file_variables": {
+			Values: map[string]string{
+				"a": `"value"`,
+				"b": "var.a",
+				"c": "var.b",
+			},
+			Want: map[string]cty.Value{
+				"c": cty.StringVal("value"),
+			},
+		},
 	}
 
 	for name, tc := range tcs {
@@ -103,6 +122,37 @@ func TestFileVariables(t *testing.T) {
 	}
 }
 
+func TestFileVariablesCycle(t *testing.T) {
+	caches := NewVariableCaches(func(vc *VariableCaches) {
+		for name, value := range map[string]string{
+			"foo": "var.baz",
+			"baz": "var.foo",
+		} {
+			expr, diags := hclsyntax.ParseExpression([]byte(value), "test.tf", hcl.Pos{Line: 0, Column: 0, Byte: 0})
+			if len(diags) > 0 {
+				t.Fatalf("unexpected errors: %v", diags)
+			}
+			vc.FileVariables[name] = expr
+		}
+	})
+
+	cache := caches.GetCache("test", makeConfigWithVariables(nil))
+	_, diags := cache.GetFileVariable("foo")
+	if !diags.HasErrors() {
+		t.Fatal("expected errors, got none")
+	}
+
+	found := false
+	for _, diag := range diags {
+		if diag.Description().Summary == "Cyclic variable reference" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected a cyclic variable reference diagnostic, got: %s", diags.Err())
+	}
+}
+
 func TestGlobalVariables(t *testing.T) {
 
 	tcs := map[string]struct {
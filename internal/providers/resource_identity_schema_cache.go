@@ -0,0 +1,45 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package providers
+
+import (
+	"sync"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+)
+
+// ResourceIdentitySchemasCache is a global cache of resource identity schemas,
+// keyed by provider address.
+//
+// FIXME: A process-global cache is inappropriate when Terraform Core is
+// embedded in another program, because two embedded contexts could be using
+// different provider implementations registered under the same address.
+// Callers in that situation should use a per-instance cache instead; see
+// loadschemas.NewPlugins for a way to opt in to one.
+var ResourceIdentitySchemasCache = &resourceIdentitySchemaCache{
+	m: make(map[addrs.Provider]ResourceIdentitySchemas),
+}
+
+// Cache for resource identity schemas, following the same pattern as
+// schemaCache: we store the entire response so that we capture any new
+// fields that get added to it later.
+type resourceIdentitySchemaCache struct {
+	mu sync.Mutex
+	m  map[addrs.Provider]ResourceIdentitySchemas
+}
+
+func (c *resourceIdentitySchemaCache) Set(p addrs.Provider, s ResourceIdentitySchemas) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.m[p] = s
+}
+
+func (c *resourceIdentitySchemaCache) Get(p addrs.Provider) (ResourceIdentitySchemas, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.m[p]
+	return s, ok
+}
@@ -396,7 +396,7 @@ func (s *stacksServer) PlanStackChanges(req *stacks.PlanStackChanges_Request, ev
 	} else {
 		// Deprecated: The previous state is provided inline as a map.
 		// FIXME: Remove this old field once our existing clients are updated.
-		prevState, err = stackstate.LoadFromProto(req.PreviousState)
+		prevState, err = stackstate.LoadFromProto(req.PreviousState, nil)
 		if err != nil {
 			return status.Errorf(codes.InvalidArgument, "can't load previous state: %s", err)
 		}
@@ -783,7 +783,7 @@ func (s *stacksServer) OpenStackInspector(ctx context.Context, req *stacks.OpenS
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid input values: %s", err)
 	}
-	state, err := stackstate.LoadFromProto(req.State)
+	state, err := stackstate.LoadFromProto(req.State, nil)
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "can't load state snapshot: %s", err)
 	}
@@ -957,6 +957,11 @@ func (s *stacksServer) MigrateTerraformState(request *stacks.MigrateTerraformSta
 		Providers:     providerFactories,
 		PreviousState: previousState,
 		Config:        config,
+
+		// Keyed module instances have always mapped to matching keyed
+		// component instances through this RPC, so opt in to that behavior
+		// explicitly.
+		PerInstanceComponents: true,
 	}
 
 	emit := func(change stackstate.AppliedChange) {
@@ -995,7 +1000,8 @@ func (s *stacksServer) MigrateTerraformState(request *stacks.MigrateTerraformSta
 	}
 	switch mapping := mapping.(type) {
 	case *stacks.MigrateTerraformState_Request_Simple:
-		migrate.Migrate(
+		migrate.MigrateContext(
+			server.Context(),
 			mapping.Simple.ResourceAddressMap,
 			mapping.Simple.ModuleAddressMap,
 			emit, emitDiag)
@@ -0,0 +1,683 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package loadschemas
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty-debug/ctydebug"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/configs/configschema"
+	"github.com/hashicorp/terraform/internal/providers"
+	testing_provider "github.com/hashicorp/terraform/internal/providers/testing"
+)
+
+func TestPluginsProviderSchemaConcurrent(t *testing.T) {
+	addr := addrs.NewDefaultProvider("test")
+
+	var instantiations int64
+	factory := func() (providers.Interface, error) {
+		atomic.AddInt64(&instantiations, 1)
+		return &testing_provider.MockProvider{
+			GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+				Provider: providers.Schema{Body: &configschema.Block{}},
+			},
+		}, nil
+	}
+
+	// The global cache is bypassed so that this test observes only the
+	// memoization belonging to this Plugins object.
+	plugins := NewPlugins(
+		map[addrs.Provider]providers.Factory{addr: factory},
+		nil, nil, false,
+		WithoutGlobalSchemaCache(),
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := plugins.ProviderSchema(addr); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// All ten concurrent callers must have shared a single load.
+	if got := atomic.LoadInt64(&instantiations); got != 1 {
+		t.Errorf("provider was instantiated %d times, want 1", got)
+	}
+}
+
+func TestPluginsPreloadProviderSchemasConcurrent(t *testing.T) {
+	addr := addrs.NewDefaultProvider("test")
+
+	var instantiations int64
+	factory := func() (providers.Interface, error) {
+		atomic.AddInt64(&instantiations, 1)
+		return &testing_provider.MockProvider{
+			GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+				Provider: providers.Schema{Body: &configschema.Block{}},
+			},
+		}, nil
+	}
+
+	plugins := NewPlugins(
+		map[addrs.Provider]providers.Factory{addr: factory},
+		nil, nil, false,
+		WithoutGlobalSchemaCache(),
+	)
+
+	// Preloads racing direct schema lookups for the same address must all
+	// share the same single-flight load.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := plugins.PreloadProviderSchemas([]addrs.Provider{addr}); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := plugins.ProviderSchema(addr); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt64(&instantiations); got != 1 {
+		t.Errorf("provider was instantiated %d times, want 1", got)
+	}
+}
+
+func TestPluginsProviderSchemaInvalid(t *testing.T) {
+	addr := addrs.NewDefaultProvider("test")
+
+	// The nested attribute is invalid because it sets none of Optional,
+	// Required or Computed; the error must name both the resource type and
+	// the dotted path of the failing attribute.
+	factory := func() (providers.Interface, error) {
+		return &testing_provider.MockProvider{
+			GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+				Provider: providers.Schema{Body: &configschema.Block{}},
+				ResourceTypes: map[string]providers.Schema{
+					"test_thing": {
+						Body: &configschema.Block{
+							BlockTypes: map[string]*configschema.NestedBlock{
+								"nested": {
+									Nesting: configschema.NestingSingle,
+									Block: configschema.Block{
+										Attributes: map[string]*configschema.Attribute{
+											"broken": {Type: cty.String},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}, nil
+	}
+
+	plugins := NewPlugins(
+		map[addrs.Provider]providers.Factory{addr: factory},
+		nil, nil, false,
+		WithoutGlobalSchemaCache(),
+	)
+
+	_, err := plugins.ProviderSchema(addr)
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+	for _, want := range []string{
+		`managed resource type "test_thing"`,
+		"nested.broken: must set Optional, Required or Computed",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not contain %q: %s", want, err)
+		}
+	}
+}
+
+func TestPluginsAllProviderFunctionDecls(t *testing.T) {
+	withFuncs := addrs.NewDefaultProvider("funcs")
+	withoutFuncs := addrs.NewDefaultProvider("plain")
+	broken := addrs.NewDefaultProvider("broken")
+
+	plugins := NewPlugins(
+		map[addrs.Provider]providers.Factory{
+			withFuncs: func() (providers.Interface, error) {
+				return &testing_provider.MockProvider{
+					GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+						Provider: providers.Schema{Body: &configschema.Block{}},
+						Functions: map[string]providers.FunctionDecl{
+							"echo": {
+								Parameters: []providers.FunctionParam{
+									{Name: "input", Type: cty.String},
+								},
+								ReturnType: cty.String,
+							},
+						},
+					},
+				}, nil
+			},
+			withoutFuncs: func() (providers.Interface, error) {
+				return &testing_provider.MockProvider{
+					GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+						Provider: providers.Schema{Body: &configschema.Block{}},
+					},
+				}, nil
+			},
+			broken: func() (providers.Interface, error) {
+				return nil, errors.New("no plugin available")
+			},
+		},
+		nil, nil, false,
+		WithoutGlobalSchemaCache(),
+	)
+
+	got, err := plugins.AllProviderFunctionDecls()
+
+	// The broken provider is reported with attribution, but doesn't stop the
+	// other providers from being aggregated.
+	if err == nil {
+		t.Fatal("expected an error for the broken provider, got nil")
+	}
+	if !strings.Contains(err.Error(), broken.String()) {
+		t.Errorf("error does not name the failing provider: %s", err)
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("expected declarations for exactly one provider, got %d", len(got))
+	}
+	decls, ok := got[withFuncs]
+	if !ok {
+		t.Fatalf("missing declarations for %s; got: %v", withFuncs, got)
+	}
+	if _, ok := decls["echo"]; !ok {
+		t.Errorf("missing declaration for function %q", "echo")
+	}
+}
+
+func TestPluginsProviderFunctionSignatures(t *testing.T) {
+	addr := addrs.NewDefaultProvider("funcs")
+
+	plugins := NewPlugins(
+		map[addrs.Provider]providers.Factory{
+			addr: func() (providers.Interface, error) {
+				return &testing_provider.MockProvider{
+					GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+						Provider: providers.Schema{Body: &configschema.Block{}},
+						Functions: map[string]providers.FunctionDecl{
+							"parse": {
+								Parameters: []providers.FunctionParam{
+									{Name: "input", Type: cty.String},
+									{Name: "strict", Type: cty.Bool},
+								},
+								ReturnType: cty.DynamicPseudoType,
+							},
+							"join": {
+								Parameters: []providers.FunctionParam{
+									{Name: "separator", Type: cty.String},
+								},
+								VariadicParameter: &providers.FunctionParam{
+									Name: "parts", Type: cty.String,
+								},
+								ReturnType: cty.String,
+							},
+						},
+					},
+				}, nil
+			},
+		},
+		nil, nil, false,
+		WithoutGlobalSchemaCache(),
+	)
+
+	got, err := plugins.ProviderFunctionSignatures(addr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	parts := cty.String
+	want := map[string]FunctionSignature{
+		"parse": {
+			Parameters: []cty.Type{cty.String, cty.Bool},
+			ReturnType: cty.DynamicPseudoType,
+		},
+		"join": {
+			Parameters:        []cty.Type{cty.String},
+			VariadicParameter: &parts,
+			ReturnType:        cty.String,
+		},
+	}
+	if diff := cmp.Diff(want, got, ctydebug.CmpOptions); diff != "" {
+		t.Errorf("wrong signatures\n%s", diff)
+	}
+}
+
+// BenchmarkResourceTypeSchema measures the cost of repeated schema lookups
+// for the same resource type, as happens when migrating or upgrading a large
+// state full of instances of one type.
+func BenchmarkResourceTypeSchema(b *testing.B) {
+	addr := addrs.NewDefaultProvider("test")
+
+	resourceTypes := make(map[string]providers.Schema, 100)
+	for i := 0; i < 100; i++ {
+		resourceTypes[fmt.Sprintf("test_thing_%d", i)] = providers.Schema{
+			Body: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"id": {Type: cty.String, Computed: true},
+				},
+			},
+		}
+	}
+
+	plugins := NewPlugins(
+		map[addrs.Provider]providers.Factory{
+			addr: func() (providers.Interface, error) {
+				return &testing_provider.MockProvider{
+					GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+						Provider:      providers.Schema{Body: &configschema.Block{}},
+						ResourceTypes: resourceTypes,
+					},
+				}, nil
+			},
+		},
+		nil, nil, false,
+		WithoutGlobalSchemaCache(),
+	)
+
+	// Prime the provider schema memo so that the loop below measures only
+	// the per-call lookup cost.
+	if _, err := plugins.ResourceTypeSchema(addr, addrs.ManagedResourceMode, "test_thing_0"); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := plugins.ResourceTypeSchema(addr, addrs.ManagedResourceMode, "test_thing_0"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPluginsFallbackFactory(t *testing.T) {
+	addr := addrs.NewDefaultProvider("missing")
+
+	plugins := NewPlugins(nil, nil, nil, false, WithoutGlobalSchemaCache())
+
+	// Without a fallback, an unregistered provider is unavailable.
+	_, err := plugins.NewProviderInstance(addr)
+	var unavailable ProviderUnavailableError
+	if !errors.As(err, &unavailable) {
+		t.Fatalf("expected ProviderUnavailableError, got: %v", err)
+	}
+
+	var fallbackCalls int
+	plugins.SetFallbackFactory(func() (providers.Interface, error) {
+		fallbackCalls++
+		return &testing_provider.MockProvider{
+			GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+				Provider: providers.Schema{Body: &configschema.Block{}},
+			},
+		}, nil
+	})
+
+	// The fallback now stands in for the unregistered provider.
+	if _, err := plugins.NewProviderInstance(addr); err != nil {
+		t.Fatalf("unexpected error with fallback: %s", err)
+	}
+	if fallbackCalls != 1 {
+		t.Errorf("fallback factory was called %d times, want 1", fallbackCalls)
+	}
+
+	// Schema-dependent paths still refuse the unregistered provider, since a
+	// stub cannot answer schema questions usefully.
+	if _, err := plugins.ProviderSchema(addr); !errors.As(err, &unavailable) {
+		t.Errorf("expected ProviderUnavailableError from ProviderSchema, got: %v", err)
+	}
+}
+
+func TestPluginsProviderSchemaSource(t *testing.T) {
+	dynamic := addrs.NewDefaultProvider("dynamic")
+	preloaded := addrs.NewDefaultProvider("preloaded")
+	unknown := addrs.NewDefaultProvider("unknown")
+
+	plugins := NewPlugins(
+		map[addrs.Provider]providers.Factory{
+			dynamic: func() (providers.Interface, error) {
+				return &testing_provider.MockProvider{
+					GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+						Provider: providers.Schema{Body: &configschema.Block{}},
+					},
+				}, nil
+			},
+		},
+		nil,
+		map[addrs.Provider]providers.ProviderSchema{
+			preloaded: {Provider: providers.Schema{Body: &configschema.Block{}}},
+		},
+		false,
+		WithoutGlobalSchemaCache(),
+	)
+
+	if got, err := plugins.ProviderSchemaSource(preloaded); err != nil || got != SchemaSourcePreloaded {
+		t.Errorf("preloaded provider: got %s, %v; want %s, nil", got, err, SchemaSourcePreloaded)
+	}
+
+	// Before the first load the registered provider is merely uncached, and
+	// asking must not trigger a load.
+	if got, err := plugins.ProviderSchemaSource(dynamic); err != nil || got != SchemaSourceUncached {
+		t.Errorf("unloaded provider: got %s, %v; want %s, nil", got, err, SchemaSourceUncached)
+	}
+	if _, err := plugins.ProviderSchema(dynamic); err != nil {
+		t.Fatalf("unexpected error loading schema: %s", err)
+	}
+	if got, err := plugins.ProviderSchemaSource(dynamic); err != nil || got != SchemaSourceInstantiated {
+		t.Errorf("loaded provider: got %s, %v; want %s, nil", got, err, SchemaSourceInstantiated)
+	}
+
+	// A provider with no factory and no schema anywhere can never succeed.
+	var unavailable ProviderUnavailableError
+	if _, err := plugins.ProviderSchemaSource(unknown); !errors.As(err, &unavailable) {
+		t.Errorf("expected ProviderUnavailableError for unknown provider, got: %v", err)
+	}
+}
+
+func TestPluginsWarmCache(t *testing.T) {
+	good := addrs.NewDefaultProvider("good")
+	broken := addrs.NewDefaultProvider("broken")
+
+	var instantiations int64
+	goodFactory := func() (providers.Interface, error) {
+		atomic.AddInt64(&instantiations, 1)
+		return &testing_provider.MockProvider{
+			GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+				Provider: providers.Schema{Body: &configschema.Block{}},
+				ResourceTypes: map[string]providers.Schema{
+					"good_thing": {
+						Body: &configschema.Block{
+							Attributes: map[string]*configschema.Attribute{
+								"name": {Type: cty.String, Optional: true},
+							},
+						},
+					},
+				},
+			},
+		}, nil
+	}
+	brokenFactory := func() (providers.Interface, error) {
+		return nil, errors.New("no plugin for you")
+	}
+
+	// The per-object identity cache is selected so that this test doesn't
+	// write into the process-global identity schema cache.
+	plugins := NewPlugins(
+		map[addrs.Provider]providers.Factory{
+			good:   goodFactory,
+			broken: brokenFactory,
+		},
+		nil, nil, true,
+		WithoutGlobalSchemaCache(),
+	)
+
+	// Duplicate addresses in the argument must be warmed only once.
+	stats, err := plugins.WarmCache([]addrs.Provider{good, broken, good})
+	if err == nil {
+		t.Fatal("expected an error for the broken provider, got nil")
+	}
+	if !strings.Contains(err.Error(), `provider "registry.terraform.io/hashicorp/broken"`) {
+		t.Errorf("error does not name the broken provider: %s", err)
+	}
+
+	if got, want := len(stats.Providers), 2; got != want {
+		t.Fatalf("got stats for %d providers, want %d", got, want)
+	}
+	if pStats := stats.Providers[good]; pStats.Err != nil {
+		t.Errorf("unexpected error for good provider: %s", pStats.Err)
+	} else if pStats.BytesEstimate <= 0 {
+		t.Errorf("good provider has no bytes estimate")
+	}
+	if pStats := stats.Providers[broken]; pStats.Err == nil {
+		t.Errorf("no error recorded for broken provider")
+	} else if pStats.BytesEstimate != 0 {
+		t.Errorf("broken provider has bytes estimate %d, want 0", pStats.BytesEstimate)
+	}
+	if got, want := stats.TotalBytesEstimate, stats.Providers[good].BytesEstimate; got != want {
+		t.Errorf("wrong TotalBytesEstimate: got %d, want %d", got, want)
+	}
+
+	// A second warm-up must be answered entirely from memoization: the good
+	// provider was instantiated twice (once for its schema and once for its
+	// identity schemas) and must not be instantiated again.
+	before := atomic.LoadInt64(&instantiations)
+	if _, err := plugins.WarmCache([]addrs.Provider{good}); err != nil {
+		t.Fatalf("unexpected error on second warm-up: %s", err)
+	}
+	if got := atomic.LoadInt64(&instantiations); got != before {
+		t.Errorf("second warm-up instantiated the provider again (%d -> %d)", before, got)
+	}
+}
+
+func TestPluginsValidateProviderSchemas(t *testing.T) {
+	good := addrs.NewDefaultProvider("good")
+	bad := addrs.NewDefaultProvider("bad")
+	missing := addrs.NewDefaultProvider("missing")
+
+	plugins := NewPlugins(
+		map[addrs.Provider]providers.Factory{
+			good: func() (providers.Interface, error) {
+				return &testing_provider.MockProvider{
+					GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+						Provider: providers.Schema{Body: &configschema.Block{}},
+						ResourceTypes: map[string]providers.Schema{
+							"good_thing": {
+								Body: &configschema.Block{
+									Attributes: map[string]*configschema.Attribute{
+										"id": {Type: cty.String, Computed: true},
+									},
+								},
+							},
+						},
+					},
+				}, nil
+			},
+			bad: func() (providers.Interface, error) {
+				return &testing_provider.MockProvider{
+					GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+						Provider: providers.Schema{Body: &configschema.Block{}},
+						ResourceTypes: map[string]providers.Schema{
+							"bad_thing": {
+								// Trips InternalValidate: the attribute sets
+								// none of Optional, Required or Computed.
+								Body: &configschema.Block{
+									Attributes: map[string]*configschema.Attribute{
+										"broken": {Type: cty.String},
+									},
+								},
+								Version: -1, // trips the negative version check
+								Identity: &configschema.Object{
+									Nesting: configschema.NestingSingle,
+									Attributes: map[string]*configschema.Attribute{
+										// Maps are not allowed in identities.
+										"a": {Type: cty.Map(cty.String), Required: true},
+										// Neither required nor optional for import.
+										"b": {Type: cty.String},
+									},
+								},
+								IdentityVersion: -1, // trips the negative identity version check
+							},
+						},
+						DataSources: map[string]providers.Schema{
+							"bad_data": {
+								Body:    &configschema.Block{},
+								Version: -1,
+							},
+						},
+						Functions: map[string]providers.FunctionDecl{
+							"not valid": {ReturnType: cty.String},
+						},
+					},
+				}, nil
+			},
+			missing: func() (providers.Interface, error) {
+				return nil, errors.New("no plugin available")
+			},
+		},
+		nil, nil, false,
+		WithoutGlobalSchemaCache(),
+	)
+
+	diags := plugins.ValidateProviderSchemas([]addrs.Provider{good, bad, missing})
+	if !diags.HasErrors() {
+		t.Fatal("expected error diagnostics, got none")
+	}
+
+	var all strings.Builder
+	for _, diag := range diags {
+		all.WriteString(diag.Description().Detail)
+		all.WriteString("\n")
+	}
+	for _, want := range []string{
+		`invalid schema for managed resource type "bad_thing"`,
+		`invalid negative schema version for managed resource type "bad_thing"`,
+		`attribute "a" is a map`,
+		`identity attribute "b" is neither required nor optional for import`,
+		`invalid negative identity schema version for managed resource type "bad_thing"`,
+		`invalid negative schema version for data resource type "bad_data"`,
+		`declares function with invalid name "not valid"`,
+		`Failed to instantiate provider "registry.terraform.io/hashicorp/missing"`,
+	} {
+		if !strings.Contains(all.String(), want) {
+			t.Errorf("diagnostics do not mention %q:\n%s", want, all.String())
+		}
+	}
+	if strings.Contains(all.String(), "good") {
+		t.Errorf("diagnostics unexpectedly mention the valid provider:\n%s", all.String())
+	}
+}
+
+func TestPluginsProviderSchemaBytes(t *testing.T) {
+	addr := addrs.NewDefaultProvider("test")
+	broken := addrs.NewDefaultProvider("broken")
+
+	plugins := NewPlugins(
+		map[addrs.Provider]providers.Factory{
+			addr: func() (providers.Interface, error) {
+				return &testing_provider.MockProvider{
+					GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+						Provider: providers.Schema{Body: &configschema.Block{}},
+						ResourceTypes: map[string]providers.Schema{
+							"test_thing": {
+								Body: &configschema.Block{
+									Attributes: map[string]*configschema.Attribute{
+										"id":   {Type: cty.String, Computed: true},
+										"name": {Type: cty.String, Optional: true},
+									},
+								},
+							},
+						},
+					},
+				}, nil
+			},
+			broken: func() (providers.Interface, error) {
+				return nil, errors.New("no plugin available")
+			},
+		},
+		nil, nil, false,
+		WithoutGlobalSchemaCache(),
+	)
+
+	got, err := plugins.ProviderSchemaBytes(addr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got <= 0 {
+		t.Errorf("estimate should be positive, got %d", got)
+	}
+
+	// The estimate must agree with what WarmCache reports for the same
+	// provider, since both feed the same budgeting decisions.
+	stats, err := plugins.WarmCache([]addrs.Provider{addr})
+	if err != nil {
+		t.Fatalf("unexpected error warming cache: %s", err)
+	}
+	if want := stats.Providers[addr].BytesEstimate; got != want {
+		t.Errorf("estimate disagrees with WarmCache: got %d, want %d", got, want)
+	}
+
+	if _, err := plugins.ProviderSchemaBytes(broken); err == nil {
+		t.Error("expected an error for the broken provider, got nil")
+	}
+}
+
+func TestPluginsEphemeralResourceTypes(t *testing.T) {
+	addr := addrs.NewDefaultProvider("test")
+
+	factory := func() (providers.Interface, error) {
+		return &testing_provider.MockProvider{
+			GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+				Provider: providers.Schema{Body: &configschema.Block{}},
+				EphemeralResourceTypes: map[string]providers.Schema{
+					"test_token": {
+						Body: &configschema.Block{
+							Attributes: map[string]*configschema.Attribute{
+								"value": {Type: cty.String, Computed: true},
+							},
+						},
+					},
+				},
+			},
+		}, nil
+	}
+
+	plugins := NewPlugins(
+		map[addrs.Provider]providers.Factory{addr: factory},
+		nil, nil, false,
+		WithoutGlobalSchemaCache(),
+	)
+
+	if has, err := plugins.HasEphemeralResourceType(addr, "test_token"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !has {
+		t.Error("test_token not reported as supported")
+	}
+	if has, err := plugins.HasEphemeralResourceType(addr, "test_absent"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if has {
+		t.Error("test_absent reported as supported")
+	}
+
+	block, ok, err := plugins.EphemeralResourceTypeSchema(addr, "test_token")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !ok {
+		t.Fatal("test_token schema not found")
+	}
+	if _, exists := block.Attributes["value"]; !exists {
+		t.Error("test_token schema is missing the value attribute")
+	}
+
+	if _, ok, err := plugins.EphemeralResourceTypeSchema(addr, "test_absent"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if ok {
+		t.Error("test_absent schema reported as found")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package loadschemas
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/configs/configschema"
+)
+
+// SchemaDiff describes how the schema for a particular resource type differs
+// between two providers, as produced by [Plugins.DiffResourceTypeSchemas].
+//
+// Attributes and nested block types are identified by their dot-separated
+// path from the root of the resource type schema, so that differences deep
+// inside nested blocks or nested attribute types are still addressable.
+type SchemaDiff struct {
+	// AddedAttributes are the paths that exist only in the second provider's
+	// schema, and RemovedAttributes the paths that exist only in the first.
+	AddedAttributes   []string
+	RemovedAttributes []string
+
+	// ChangedAttributes are the paths that exist in both schemas but with a
+	// different type, or a different nesting mode for nested attribute types
+	// and block types. A changed path is not described any further: callers
+	// that need the details can look the path up in both schemas themselves.
+	ChangedAttributes []string
+}
+
+// HasChanges returns true if the two schemas differ at all.
+func (d *SchemaDiff) HasChanges() bool {
+	return len(d.AddedAttributes) > 0 || len(d.RemovedAttributes) > 0 || len(d.ChangedAttributes) > 0
+}
+
+// DiffResourceTypeSchemas loads the schemas of the two given providers and
+// compares their definitions of the given managed resource type, returning
+// the attributes that were added, removed, or changed between the first and
+// the second provider.
+//
+// This is intended for assessing the impact of swapping one provider for
+// another (such as a fork) that claims to implement the same resource types,
+// before attempting any state migration between them.
+func (cp *Plugins) DiffResourceTypeSchemas(a, b addrs.Provider, resourceType string) (*SchemaDiff, error) {
+	aSchema, err := cp.ResourceTypeSchema(a, addrs.ManagedResourceMode, resourceType)
+	if err != nil {
+		return nil, err
+	}
+	if aSchema.Body == nil {
+		return nil, fmt.Errorf("provider %s has no managed resource type %q", a, resourceType)
+	}
+
+	bSchema, err := cp.ResourceTypeSchema(b, addrs.ManagedResourceMode, resourceType)
+	if err != nil {
+		return nil, err
+	}
+	if bSchema.Body == nil {
+		return nil, fmt.Errorf("provider %s has no managed resource type %q", b, resourceType)
+	}
+
+	diff := &SchemaDiff{}
+	diffSchemaBlocks("", aSchema.Body, bSchema.Body, diff)
+
+	// The maps we walked have no inherent order, so sort for stable results.
+	sort.Strings(diff.AddedAttributes)
+	sort.Strings(diff.RemovedAttributes)
+	sort.Strings(diff.ChangedAttributes)
+	return diff, nil
+}
+
+// diffSchemaBlocks records the differences between two blocks at the same
+// path in their respective schemas.
+func diffSchemaBlocks(prefix string, a, b *configschema.Block, diff *SchemaDiff) {
+	for name, aAttr := range a.Attributes {
+		bAttr, ok := b.Attributes[name]
+		if !ok {
+			diff.RemovedAttributes = append(diff.RemovedAttributes, prefix+name)
+			continue
+		}
+		diffSchemaAttributes(prefix+name, aAttr, bAttr, diff)
+	}
+	for name := range b.Attributes {
+		if _, ok := a.Attributes[name]; !ok {
+			diff.AddedAttributes = append(diff.AddedAttributes, prefix+name)
+		}
+	}
+
+	for name, aBlock := range a.BlockTypes {
+		bBlock, ok := b.BlockTypes[name]
+		if !ok {
+			diff.RemovedAttributes = append(diff.RemovedAttributes, prefix+name)
+			continue
+		}
+		if aBlock.Nesting != bBlock.Nesting {
+			// A different nesting mode changes the shape of everything
+			// inside, so there's no point descending any further.
+			diff.ChangedAttributes = append(diff.ChangedAttributes, prefix+name)
+			continue
+		}
+		diffSchemaBlocks(prefix+name+".", &aBlock.Block, &bBlock.Block, diff)
+	}
+	for name := range b.BlockTypes {
+		if _, ok := a.BlockTypes[name]; !ok {
+			diff.AddedAttributes = append(diff.AddedAttributes, prefix+name)
+		}
+	}
+}
+
+// diffSchemaAttributes records the differences between two attributes at the
+// same path, descending into nested attribute types where both sides have
+// one.
+func diffSchemaAttributes(path string, a, b *configschema.Attribute, diff *SchemaDiff) {
+	switch {
+	case a.NestedType != nil && b.NestedType != nil:
+		if a.NestedType.Nesting != b.NestedType.Nesting {
+			diff.ChangedAttributes = append(diff.ChangedAttributes, path)
+			return
+		}
+		diffSchemaObjects(path+".", a.NestedType, b.NestedType, diff)
+	case a.NestedType != nil || b.NestedType != nil:
+		// One side is a nested attribute type and the other a plain type.
+		diff.ChangedAttributes = append(diff.ChangedAttributes, path)
+	default:
+		if !a.Type.Equals(b.Type) {
+			diff.ChangedAttributes = append(diff.ChangedAttributes, path)
+		}
+	}
+}
+
+// diffSchemaObjects is the nested-attribute-type equivalent of
+// diffSchemaBlocks.
+func diffSchemaObjects(prefix string, a, b *configschema.Object, diff *SchemaDiff) {
+	for name, aAttr := range a.Attributes {
+		bAttr, ok := b.Attributes[name]
+		if !ok {
+			diff.RemovedAttributes = append(diff.RemovedAttributes, prefix+name)
+			continue
+		}
+		diffSchemaAttributes(prefix+name, aAttr, bAttr, diff)
+	}
+	for name := range b.Attributes {
+		if _, ok := a.Attributes[name]; !ok {
+			diff.AddedAttributes = append(diff.AddedAttributes, prefix+name)
+		}
+	}
+}
@@ -5,11 +5,16 @@ package stackmigrate
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
+	"github.com/hashicorp/go-slug/sourceaddrs"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hcldec"
 	"github.com/hashicorp/terraform/internal/addrs"
 	"github.com/hashicorp/terraform/internal/collections"
+	"github.com/hashicorp/terraform/internal/configs"
+	"github.com/hashicorp/terraform/internal/providers"
 	"github.com/hashicorp/terraform/internal/stacks/stackaddrs"
 	"github.com/hashicorp/terraform/internal/stacks/stackconfig"
 	"github.com/hashicorp/terraform/internal/stacks/stackstate"
@@ -17,12 +22,26 @@ import (
 	"github.com/zclconf/go-cty/cty"
 )
 
-func (m *migration) migrateComponents(components collections.Map[Instance, collections.Set[*stackResource]]) {
+func (m *migration) migrateComponents(components collections.Map[Instance, collections.Set[*stackResource]], modules map[string]string) {
 	// We need to calculate the dependencies between components, so we can
 	// populate the dependencies and dependents fields in the component instances.
-	dependencies, dependents := m.calculateDependencies(components)
+	dependencies, dependents := m.calculateDependencies(components, modules)
 
 	for instance := range components.All() {
+		if m.stopped || m.checkCancelled() {
+			// An earlier error or a cancellation already halted the
+			// migration.
+			break
+		}
+
+		if m.ExistingState != nil && m.ExistingState.HasComponentInstance(instance) {
+			// The component instance was already recorded by an earlier
+			// migration run, and everything we would emit for it is derived
+			// purely from the configuration and the mappings, so there's
+			// nothing new to say about it.
+			continue
+		}
+
 		// We need to see the inputs and outputs from the component, so we can
 		// create the component instance with the correct values.
 		// ignore the diag because we already found this when loading the config.
@@ -34,11 +53,56 @@ func (m *migration) migrateComponents(components collections.Map[Instance, colle
 		for name := range config.Module.Variables {
 			inputs[addrs.InputVariable{Name: name}] = cty.DynamicVal
 		}
+		if m.ResolveInputs {
+			// Every resource in the set carries the same component
+			// configuration, so any of them can supply the inputs expression.
+			for resource := range components.Get(instance).All() {
+				m.resolveConstantInputs(resource.ComponentConfig.Inputs, inputs)
+				break
+			}
+		}
 		outputs := make(map[addrs.OutputValue]cty.Value, len(config.Module.Outputs))
 		for name := range config.Module.Outputs {
 			outputs[addrs.OutputValue{Name: name}] = cty.DynamicVal
 		}
 
+		// Where the previous state has a known value for an output that the
+		// component's module also declares, we can carry the value over
+		// instead of leaving it unknown until the next plan. Only root
+		// module output values are persisted in state, so this applies to
+		// components that took at least one resource from the root module.
+		// Output values recorded in the state that have no matching
+		// declaration are dropped, with a warning so the user knows they
+		// were left behind.
+		fromRoot := false
+		for resource := range components.Get(instance).All() {
+			if resource.ContainingModuleInstance.IsRoot() {
+				fromRoot = true
+				break
+			}
+		}
+		if fromRoot {
+			for name, os := range m.PreviousState.RootOutputValues {
+				target := name
+				if renamed, ok := m.OutputRename[name]; ok {
+					// A rename is an explicit statement of intent, so a
+					// target that the component doesn't declare is an error
+					// rather than the usual dropped-output warning.
+					target = renamed
+					if _, declared := config.Module.Outputs[target]; !declared {
+						m.emitDiag(tfdiags.Sourceless(tfdiags.Error, "Renamed output not declared", fmt.Sprintf("The output rename maps %q to %q, but the target component does not declare an output of that name.", name, target)))
+						continue
+					}
+				} else if _, declared := config.Module.Outputs[name]; !declared {
+					m.warnUnmatchedOutput(addrs.RootModuleInstance, name)
+					continue
+				}
+				if os.Value != cty.NilVal && os.Value.IsWhollyKnown() {
+					outputs[addrs.OutputValue{Name: target}] = os.Value
+				}
+			}
+		}
+
 		// We need this address to be able to look up dependencies and
 		// dependents later.
 		addr := AbsComponent{
@@ -48,10 +112,7 @@ func (m *migration) migrateComponents(components collections.Map[Instance, colle
 
 		// We emit a change a change for each component instance
 		m.emit(&stackstate.AppliedChangeComponentInstance{
-			ComponentAddr: AbsComponent{
-				Stack: stackaddrs.RootStackInstance,
-				Item:  instance.Item.Component,
-			},
+			ComponentAddr:         addr,
 			ComponentInstanceAddr: instance,
 
 			OutputValues:   outputs,
@@ -63,10 +124,198 @@ func (m *migration) migrateComponents(components collections.Map[Instance, colle
 			Dependencies: dependencies.Get(addr),
 			Dependents:   dependents.Get(addr),
 		})
+
+		m.progress.MigratedComponents++
+		m.result.Components++
+		m.reportProgress()
 	}
 }
 
-func (m *migration) calculateDependencies(components collections.Map[Instance, collections.Set[*stackResource]]) (collections.Map[AbsComponent, collections.Set[AbsComponent]], collections.Map[AbsComponent, collections.Set[AbsComponent]]) {
+// resolveConstantInputs fills in concrete values for any entries of inputs
+// whose expressions in the given component inputs object are constants,
+// leaving everything else unknown. Expressions that reference variables or
+// other components can only be evaluated by the stack runtime, so they are
+// skipped rather than guessed at.
+func (m *migration) resolveConstantInputs(expr hcl.Expression, inputs map[addrs.InputVariable]cty.Value) {
+	if expr == nil {
+		return
+	}
+	items, diags := hcl.ExprMap(expr)
+	if diags.HasErrors() {
+		// The inputs are not written as a literal object, so nothing can be
+		// resolved statically.
+		return
+	}
+	for _, item := range items {
+		key, keyDiags := item.Key.Value(nil)
+		if keyDiags.HasErrors() || key.IsNull() || !key.IsKnown() || key.Type() != cty.String {
+			continue
+		}
+		variable := addrs.InputVariable{Name: key.AsString()}
+		if _, declared := inputs[variable]; !declared {
+			// Undeclared inputs are reported when the configuration itself is
+			// validated, so we just skip them here.
+			continue
+		}
+		if len(item.Value.Variables()) > 0 {
+			continue
+		}
+		value, valueDiags := item.Value.Value(nil)
+		if valueDiags.HasErrors() || !value.IsWhollyKnown() {
+			continue
+		}
+		inputs[variable] = value
+	}
+}
+
+// warnUnmatchedOutput emits a warning for an output value recorded in the
+// previous state that has no matching declaration in the component it would
+// migrate to. The same module instance can contribute resources to more than
+// one component, so each module output only warns once per migration.
+func (m *migration) warnUnmatchedOutput(module addrs.ModuleInstance, name string) {
+	key := module.String() + ":" + name
+	if m.unmatchedOutputs[key] {
+		return
+	}
+	m.unmatchedOutputs[key] = true
+
+	moduleDesc := "the root module"
+	if !module.IsRoot() {
+		moduleDesc = module.String()
+	}
+	m.emitDiag(tfdiags.Sourceless(tfdiags.Warning, "Output value not migrated", fmt.Sprintf("The previous state records output %q for %s, but the target component does not declare a matching output, so its value was not migrated.", name, moduleDesc)))
+}
+
+// DependencyGraph runs only the dependency-inference part of a migration,
+// returning the component dependency graph that Migrate would record, as an
+// adjacency structure mapping each component to the components it depends on.
+// Components without dependencies are present with an empty adjacency list,
+// so the keys describe every component the mappings select.
+//
+// A cyclic component graph can't be applied, so cycles are detected and
+// reported as error diagnostics.
+//
+// No applied changes are produced; this is intended for validating or
+// visualizing the graph before running the real migration.
+func (m *Migration) DependencyGraph(resources map[string]string, modules map[string]string) (collections.Map[AbsComponent, []AbsComponent], tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	migration := &migration{
+		Migration: m,
+		emit:      func(stackstate.AppliedChange) {},
+		emitDiag:  func(diagnostic tfdiags.Diagnostic) { diags = append(diags, diagnostic) },
+		providers: make(map[addrs.Provider]providers.Interface),
+		parser:    configs.NewSourceBundleParser(m.Config.Sources),
+		configs:   make(map[sourceaddrs.FinalSource]*configs.Config),
+	}
+	defer migration.close()
+
+	// Walk the state the same way migrateResources does, but only far enough
+	// to learn which component each resource is assigned to.
+	components := collections.NewMap[Instance, collections.Set[*stackResource]]()
+	for _, resource := range migration.stateResources() {
+		if resource.Addr.Resource.Mode == addrs.DataResourceMode {
+			continue
+		}
+		target, moreDiags := migration.search(resource.Addr, resources, modules)
+		if moreDiags.HasErrors() {
+			migration.emitDiags(moreDiags)
+			continue
+		}
+		if target == nil {
+			// Orphaned resources don't contribute any components.
+			continue
+		}
+		if moreDiags := migration.loadConfig(target); moreDiags.HasErrors() {
+			migration.emitDiags(moreDiags)
+			continue
+		}
+		instance := target.AbsResource.Component
+		if !components.HasKey(instance) {
+			components.Put(instance, collections.NewSet[*stackResource]())
+		}
+		components.Get(instance).Add(target)
+	}
+
+	dependencies, _ := migration.calculateDependencies(components, modules)
+
+	graph := collections.NewMap[AbsComponent, []AbsComponent]()
+	for instance := range components.All() {
+		addr := AbsComponent{
+			Stack: instance.Stack,
+			Item:  instance.Item.Component,
+		}
+		neighbors := []AbsComponent{}
+		if deps, ok := dependencies.GetOk(addr); ok {
+			for dep := range deps.All() {
+				neighbors = append(neighbors, dep)
+			}
+			sort.Slice(neighbors, func(i, j int) bool {
+				return neighbors[i].String() < neighbors[j].String()
+			})
+		}
+		graph.Put(addr, neighbors)
+	}
+
+	diags = diags.Append(findComponentCycles(graph))
+	return graph, diags
+}
+
+// findComponentCycles reports an error diagnostic for each dependency cycle
+// in the given adjacency structure.
+func findComponentCycles(graph collections.Map[AbsComponent, []AbsComponent]) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	const (
+		unvisited = 0
+		visiting  = 1
+		done      = 2
+	)
+	state := collections.NewMap[AbsComponent, int]()
+
+	var path []AbsComponent
+	var visit func(addr AbsComponent)
+	visit = func(addr AbsComponent) {
+		switch state.Get(addr) {
+		case visiting:
+			// We've looped back to a component already on the current path,
+			// so everything from its first appearance onwards is a cycle.
+			cycle := []string{addr.String()}
+			for i := len(path) - 1; i >= 0 && path[i].String() != addr.String(); i-- {
+				cycle = append(cycle, path[i].String())
+			}
+			sort.Strings(cycle)
+			diags = diags.Append(tfdiags.Sourceless(tfdiags.Error, "Component dependency cycle", fmt.Sprintf("The inferred component dependency graph contains a cycle between %s. A cyclic component graph cannot be applied.", strings.Join(cycle, ", "))))
+			return
+		case done:
+			return
+		}
+		state.Put(addr, visiting)
+		path = append(path, addr)
+		if neighbors, ok := graph.GetOk(addr); ok {
+			for _, dep := range neighbors {
+				visit(dep)
+			}
+		}
+		path = path[:len(path)-1]
+		state.Put(addr, done)
+	}
+
+	// Iterate in a stable order so repeated runs report the same cycles.
+	var roots []AbsComponent
+	for addr := range graph.All() {
+		roots = append(roots, addr)
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		return roots[i].String() < roots[j].String()
+	})
+	for _, addr := range roots {
+		visit(addr)
+	}
+	return diags
+}
+
+func (m *migration) calculateDependencies(components collections.Map[Instance, collections.Set[*stackResource]], modules map[string]string) (collections.Map[AbsComponent, collections.Set[AbsComponent]], collections.Map[AbsComponent, collections.Set[AbsComponent]]) {
 	// The dependency map cares only about config components rather than instances,
 	// so we need to convert the map to use the config component address.
 	cfgComponents := collections.NewMap[AbsComponent, collections.Set[*stackResource]]()
@@ -106,6 +355,15 @@ func (m *migration) calculateDependencies(components collections.Map[Instance, c
 			m.emitDiags(inputDiags)
 			addDependencies(inputDependencies)
 
+			// An input that only references the containing stack's own
+			// variables may still be wired, through the embedded stack call
+			// in the parent stack, to components elsewhere in the
+			// configuration. That output-to-input wiring is a data
+			// dependency too, so we follow the variables up the stack calls.
+			variableDependencies, variableDiags := m.componentDependenciesFromStackVariables(component.Inputs, instance.Stack, cfgComponents)
+			m.emitDiags(variableDiags)
+			addDependencies(variableDependencies)
+
 			// Then, check the depends_on directly.
 			for _, traversal := range component.DependsOn {
 				dependsOnDependencies, dependsOnDiags := m.componentDependenciesFromTraversal(traversal, instance.Stack, cfgComponents)
@@ -131,9 +389,86 @@ func (m *migration) calculateDependencies(components collections.Map[Instance, c
 			dependencies.Put(addr, compDepSet)
 		}
 	}
+
+	// Module blocks in the source configuration can carry depends_on, and
+	// when two such modules migrate to different components that ordering
+	// must survive as a component-level edge. The calls are read from the
+	// module configurations of the migrated components, so the ordering is
+	// only visible when some component sources the module that declares the
+	// calls.
+	m.addModuleCallDependencies(modules, cfgComponents, dependencies, dependents)
+
 	return dependencies, dependents
 }
 
+// addModuleCallDependencies scans the module configuration of every migrated
+// component for module calls that the modules mapping assigns to components,
+// and adds a dependency edge wherever such a call's depends_on refers to
+// another mapped module call. Edges are only added between components that the
+// migration actually selected.
+func (m *migration) addModuleCallDependencies(modules map[string]string, cfgComponents collections.Map[AbsComponent, collections.Set[*stackResource]], dependencies, dependents collections.Map[AbsComponent, collections.Set[AbsComponent]]) {
+	componentForModule := func(name string) (AbsComponent, bool) {
+		target, ok := modules[name]
+		if !ok {
+			return AbsComponent{}, false
+		}
+		// Mapping targets parse the same way as in search, so the edges land
+		// on the same components the resources were assigned to.
+		fullTarget := target
+		if !strings.HasPrefix(fullTarget, "component.") && !strings.HasPrefix(fullTarget, "stack.") {
+			fullTarget = "component." + fullTarget
+		}
+		inst, _, diags := stackaddrs.ParseAbsComponentInstanceStrOnly(fullTarget)
+		if diags.HasErrors() {
+			// Unparseable mapping targets are reported when the resources
+			// themselves are migrated, so we just skip them here.
+			return AbsComponent{}, false
+		}
+		addr := AbsComponent{
+			Stack: inst.Stack,
+			Item:  inst.Item.Component,
+		}
+		return addr, cfgComponents.HasKey(addr)
+	}
+
+	for _, cmpnts := range cfgComponents.All() {
+		for resource := range cmpnts.All() {
+			// Every resource in the set carries the same module configuration.
+			if resource.ModuleConfig == nil {
+				break
+			}
+			for name, call := range resource.ModuleConfig.Module.ModuleCalls {
+				from, ok := componentForModule(name)
+				if !ok {
+					continue
+				}
+				for _, traversal := range call.DependsOn {
+					ref, parseDiags := addrs.ParseRef(traversal)
+					if parseDiags.HasErrors() {
+						// Invalid depends_on entries are reported when the
+						// configuration itself is validated.
+						continue
+					}
+					targetCall, ok := ref.Subject.(addrs.ModuleCall)
+					if !ok {
+						continue
+					}
+					to, ok := componentForModule(targetCall.Name)
+					if !ok || to.String() == from.String() {
+						continue
+					}
+					dependencies.Get(from).Add(to)
+					if !dependents.HasKey(to) {
+						dependents.Put(to, collections.NewSet[AbsComponent]())
+					}
+					dependents.Get(to).Add(from)
+				}
+			}
+			break
+		}
+	}
+}
+
 // componentDependenciesFromExpression returns a set of components that are
 // referenced in the given expression.
 func (m *migration) componentDependenciesFromExpression(expr hcl.Expression, current stackaddrs.StackInstance, components collections.Map[AbsComponent, collections.Set[*stackResource]]) (ds collections.Set[AbsComponent], diags tfdiags.Diagnostics) {
@@ -187,19 +522,34 @@ func (m *migration) componentDependenciesFromTraversal(traversal hcl.Traversal,
 			return deps, diags
 		}
 
-		for name := range stack.Components {
-			// If the component in the stack call is part of the mapping, then it will
-			// be present in the map, and we will add it to the dependencies.
-			// Otherwise, we will ignore it.
-			componentAddr := AbsComponent{
-				Stack: current.Child(ref.Name, addrs.NoKey),
-				Item:  stackaddrs.Component{Name: name},
-			}
+		// A stack call's result can be derived from components anywhere in
+		// the called stack's subtree, including inside further embedded
+		// stacks, so we walk the whole subtree. Components that are part of
+		// the mapping will be present in the map and become dependencies;
+		// anything else is ignored.
+		var walk func(inst stackaddrs.StackInstance, stack *stackconfig.Stack)
+		walk = func(inst stackaddrs.StackInstance, stack *stackconfig.Stack) {
+			for name := range stack.Components {
+				componentAddr := AbsComponent{
+					Stack: inst,
+					Item:  stackaddrs.Component{Name: name},
+				}
 
-			if _, ok := components.GetOk(componentAddr); ok {
-				deps.Add(componentAddr)
+				if _, ok := components.GetOk(componentAddr); ok {
+					deps.Add(componentAddr)
+				}
+			}
+			for name := range stack.EmbeddedStacks {
+				child := m.Config.Stack(append(inst.ConfigAddr(), stackaddrs.StackStep{Name: name}))
+				if child == nil {
+					// Invalid stack calls are reported when the configuration
+					// itself is validated, so we just skip them here.
+					continue
+				}
+				walk(inst.Child(name, addrs.NoKey), child)
 			}
 		}
+		walk(current.Child(ref.Name, addrs.NoKey), stack)
 		return deps, diags
 	default:
 		// This is not a component reference, and we only care about
@@ -208,6 +558,76 @@ func (m *migration) componentDependenciesFromTraversal(traversal hcl.Traversal,
 	}
 }
 
+// componentDependenciesFromStackVariables follows references to the
+// containing stack's input variables up into the embedded stack call that
+// populates them, returning any components referenced by the expressions
+// wired to those variables. A variable may be threaded through several
+// levels of embedded stacks, so the wiring is followed recursively until the
+// root stack is reached. Root stack variables are supplied by the caller
+// rather than by anything in the configuration, so they contribute nothing.
+func (m *migration) componentDependenciesFromStackVariables(expr hcl.Expression, current stackaddrs.StackInstance, components collections.Map[AbsComponent, collections.Set[*stackResource]]) (ds collections.Set[AbsComponent], diags tfdiags.Diagnostics) {
+	ds = collections.NewSet[AbsComponent]()
+	if expr == nil || current.IsRoot() {
+		return ds, diags
+	}
+
+	referenced := make(map[string]bool)
+	for _, v := range expr.Variables() {
+		parsed, _, moreDiags := stackaddrs.ParseReference(v)
+		if moreDiags.HasErrors() {
+			// Invalid references are reported when the configuration itself
+			// is validated, so we just skip them here.
+			continue
+		}
+		if variable, ok := parsed.Target.(stackaddrs.InputVariable); ok {
+			referenced[variable.Name] = true
+		}
+	}
+	if len(referenced) == 0 {
+		return ds, diags
+	}
+
+	parent := current.Parent()
+	parentStack := m.Config.Stack(parent.ConfigAddr())
+	if parentStack == nil {
+		return ds, diags
+	}
+	call := parentStack.EmbeddedStacks[current[len(current)-1].Name]
+	if call == nil || call.Inputs == nil {
+		return ds, diags
+	}
+
+	followWiring := func(wired hcl.Expression) {
+		dss, moreDiags := m.componentDependenciesFromExpression(wired, parent, components)
+		diags = diags.Append(moreDiags)
+		ds.AddAll(dss)
+		dss, moreDiags = m.componentDependenciesFromStackVariables(wired, parent, components)
+		diags = diags.Append(moreDiags)
+		ds.AddAll(dss)
+	}
+
+	items, itemDiags := hcl.ExprMap(call.Inputs)
+	if itemDiags.HasErrors() {
+		// The call's inputs are not written as a literal object, so we can't
+		// attribute individual expressions to variables. Conservatively treat
+		// every component the whole inputs expression references as wired to
+		// each referenced variable.
+		followWiring(call.Inputs)
+		return ds, diags
+	}
+	for _, item := range items {
+		key, keyDiags := item.Key.Value(nil)
+		if keyDiags.HasErrors() || key.IsNull() || !key.IsKnown() || key.Type() != cty.String {
+			continue
+		}
+		if !referenced[key.AsString()] {
+			continue
+		}
+		followWiring(item.Value)
+	}
+	return ds, diags
+}
+
 func (m *migration) providerDependencies(expr hcl.Expression, current stackaddrs.StackInstance, stack *stackconfig.Stack, components collections.Map[AbsComponent, collections.Set[*stackResource]]) (ds collections.Set[AbsComponent], diags tfdiags.Diagnostics) {
 	ds = collections.NewSet[AbsComponent]()
 	for _, v := range expr.Variables() {
@@ -0,0 +1,129 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package stackmigrate
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/lang/marks"
+	"github.com/hashicorp/terraform/internal/stacks/stackstate"
+	"github.com/hashicorp/terraform/internal/states"
+	"github.com/hashicorp/terraform/internal/tfdiags"
+)
+
+// Export is the inverse of [Migration.Migrate]: it takes a stack state and
+// the same resources and modules mappings that were used to produce it, and
+// reconstructs the module-style state that the original configuration would
+// have had.
+//
+// Resources that were migrated from the root module are placed back in the
+// root module, resources that were migrated from a child module are placed
+// back under that module with their instance keys retained, and deposed
+// objects are preserved. Root output values are reconstructed where their
+// values are known. This gives callers an escape hatch if a migration turns
+// out to be wrong before the old backend is deleted.
+func Export(stackState *stackstate.State, resources, modules map[string]string) (*states.State, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	trimComponent := func(target string) string {
+		return strings.TrimPrefix(target, "component.")
+	}
+
+	// The migration maps module names and root resource addresses to
+	// component names, so here we need the reverse direction: which module,
+	// or which set of root resources, each component was populated from.
+	moduleForComponent := make(map[string]string, len(modules))
+	for module, component := range modules {
+		moduleForComponent[trimComponent(component)] = module
+	}
+	rootResourcesForComponent := make(map[string]map[string]bool, len(resources))
+	for resource, component := range resources {
+		name := trimComponent(component)
+		if rootResourcesForComponent[name] == nil {
+			rootResourcesForComponent[name] = make(map[string]bool)
+		}
+		rootResourcesForComponent[name][resource] = true
+	}
+
+	// rootResourceMapped reports whether the resources mapping would have
+	// directed the given root resource into the named component, matching
+	// the same way search() does during migration: an exact key first, then
+	// wildcard patterns against the resource address.
+	rootResourceMapped := func(componentName string, resource addrs.AbsResource) bool {
+		entries := rootResourcesForComponent[componentName]
+		if entries[resource.Resource.String()] {
+			return true
+		}
+		for entry := range entries {
+			if !strings.Contains(entry, "*") {
+				continue
+			}
+			if ok, err := path.Match(entry, resource.String()); err == nil && ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	state := states.BuildState(func(ss *states.SyncState) {
+		for addr := range stackState.AllResourceInstanceObjects().All() {
+			src := stackState.ResourceInstanceObjectSrc(addr)
+			provider := stackState.ResourceInstanceProviderConfig(addr)
+
+			componentName := addr.Component.Item.Component.Name
+			componentKey := addr.Component.Item.Key
+			inst := addr.Item.ResourceInstance
+
+			var target addrs.AbsResourceInstance
+			switch {
+			case inst.Module.IsRoot() && rootResourceMapped(componentName, inst.ContainingResource()):
+				// The resource was migrated directly from the root module, so
+				// it goes straight back there.
+				target = inst
+			case moduleForComponent[componentName] != "":
+				// The resource was migrated from a child module, so we
+				// reinstate the module call that the component replaced. The
+				// component instance key was the original module instance key.
+				module := addrs.RootModuleInstance.Child(moduleForComponent[componentName], componentKey)
+				target = addrs.AbsResourceInstance{
+					Module:   append(module, inst.Module...),
+					Resource: inst.Resource,
+				}
+			default:
+				diags = diags.Append(tfdiags.Sourceless(
+					tfdiags.Error,
+					"Component not mapped",
+					fmt.Sprintf("Resource %s belongs to component %q which has no entry in either mapping, so it cannot be exported.", inst.String(), componentName),
+				))
+				continue
+			}
+
+			if addr.Item.IsCurrent() {
+				ss.SetResourceInstanceCurrent(target, src, provider)
+			} else {
+				ss.SetResourceInstanceDeposed(target, addr.Item.DeposedKey, src, provider)
+			}
+		}
+
+		// Reconstruct the root output values where their values are known.
+		for addr, value := range stackState.RootOutputValues() {
+			if value == cty.NilVal || !value.IsKnown() {
+				continue
+			}
+			sensitive := value.HasMark(marks.Sensitive)
+			value, _ = value.UnmarkDeep()
+			ss.SetOutputValue(addrs.AbsOutputValue{
+				Module:      addrs.RootModuleInstance,
+				OutputValue: addrs.OutputValue{Name: addr.Name},
+			}, value, sensitive)
+		}
+	})
+
+	return state, diags
+}
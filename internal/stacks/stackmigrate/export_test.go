@@ -0,0 +1,167 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package stackmigrate
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/providers"
+	"github.com/hashicorp/terraform/internal/stacks/stackaddrs"
+	stacks_testing_provider "github.com/hashicorp/terraform/internal/stacks/stackruntime/testing"
+	"github.com/hashicorp/terraform/internal/stacks/stackstate"
+	"github.com/hashicorp/terraform/internal/states"
+	"github.com/hashicorp/terraform/internal/tfdiags"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestExport(t *testing.T) {
+	deposedKey := states.NewDeposedKey()
+
+	stackState := stackstate.NewStateBuilder().
+		AddResourceInstance(stackstate.NewResourceInstanceBuilder().
+			SetAddr(mustAbsResourceInstanceObject("component.self.testing_resource.data")).
+			SetResourceInstanceObjectSrc(states.ResourceInstanceObjectSrc{
+				Status:    states.ObjectReady,
+				AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+			}).
+			SetProviderAddr(mustDefaultRootProvider("testing"))).
+		AddResourceInstance(stackstate.NewResourceInstanceBuilder().
+			SetAddr(stackaddrsAbsResourceInstanceObjectDeposed("component.self.testing_resource.data", deposedKey)).
+			SetResourceInstanceObjectSrc(states.ResourceInstanceObjectSrc{
+				Status:    states.ObjectReady,
+				AttrsJSON: []byte(`{"id":"old","value":"old"}`),
+			}).
+			SetProviderAddr(mustDefaultRootProvider("testing"))).
+		AddResourceInstance(stackstate.NewResourceInstanceBuilder().
+			SetAddr(mustAbsResourceInstanceObject(`component.child["a"].testing_resource.child_data`)).
+			SetResourceInstanceObjectSrc(states.ResourceInstanceObjectSrc{
+				Status:    states.ObjectReady,
+				AttrsJSON: []byte(`{"id":"bar","value":"hello"}`),
+			}).
+			SetProviderAddr(mustDefaultRootProvider("testing"))).
+		AddOutput("output", cty.StringVal("before")).
+		Build()
+
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+	modules := map[string]string{
+		"child": "child",
+	}
+
+	state, diags := Export(stackState, resources, modules)
+	if len(diags) > 0 {
+		t.Fatalf("unexpected diagnostics: %s", diags.Err())
+	}
+
+	rootResource := state.ResourceInstance(mustAbsResourceInstance("testing_resource.data"))
+	if rootResource == nil || rootResource.Current == nil {
+		t.Fatal("root resource missing from exported state")
+	}
+	if len(rootResource.Deposed) != 1 {
+		t.Errorf("expected 1 deposed object, got %d", len(rootResource.Deposed))
+	}
+	if _, ok := rootResource.Deposed[deposedKey]; !ok {
+		t.Errorf("deposed object lost its key during export")
+	}
+
+	childResource := state.ResourceInstance(mustAbsResourceInstance(`module.child["a"].testing_resource.child_data`))
+	if childResource == nil || childResource.Current == nil {
+		t.Fatal("child module resource missing from exported state")
+	}
+
+	output := state.RootOutputValues["output"]
+	if output == nil {
+		t.Fatal("root output missing from exported state")
+	}
+	if got, want := output.Value, cty.StringVal("before"); !want.RawEquals(got) {
+		t.Errorf("wrong output value: got %#v, want %#v", got, want)
+	}
+}
+
+func TestExport_wildcardMapping(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "another",
+		}.Instance(addrs.IntKey(0)),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"bar","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+	// Both resources are captured by a single wildcard pattern rather than
+	// exact keys.
+	resources := map[string]string{
+		"testing_resource.*": "parent",
+	}
+
+	var changes []stackstate.AppliedChange
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		changes = append(changes, change)
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+	if len(gotDiags) != 0 {
+		t.Fatalf("unexpected diagnostics from migration: %s", gotDiags.Err())
+	}
+
+	msgs, err := stackstate.AppliedChangesToProto(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stackState, err := stackstate.LoadFromProto(msgs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same wildcard mapping must route the resources back to the root
+	// module on export.
+	exported, diags := Export(stackState, resources, nil)
+	if len(diags) > 0 {
+		t.Fatalf("unexpected diagnostics from export: %s", diags.Err())
+	}
+	for _, addr := range []string{"testing_resource.data", "testing_resource.another[0]"} {
+		if r := exported.ResourceInstance(mustAbsResourceInstance(addr)); r == nil || r.Current == nil {
+			t.Errorf("%s missing from exported state", addr)
+		}
+	}
+}
+
+func stackaddrsAbsResourceInstanceObjectDeposed(addr string, key states.DeposedKey) stackaddrs.AbsResourceInstanceObject {
+	obj := mustAbsResourceInstanceObject(addr)
+	obj.Item.DeposedKey = key
+	return obj
+}
@@ -4,16 +4,23 @@
 package stackmigrate
 
 import (
+	"context"
 	"fmt"
 	"iter"
+	"sort"
+	"strings"
 
 	"github.com/hashicorp/go-slug/sourceaddrs"
 
 	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/collections"
 	"github.com/hashicorp/terraform/internal/configs"
+	"github.com/hashicorp/terraform/internal/depsfile"
+	"github.com/hashicorp/terraform/internal/plans"
 	"github.com/hashicorp/terraform/internal/providers"
 	"github.com/hashicorp/terraform/internal/stacks/stackaddrs"
 	"github.com/hashicorp/terraform/internal/stacks/stackconfig"
+	"github.com/hashicorp/terraform/internal/stacks/stackplan"
 	"github.com/hashicorp/terraform/internal/stacks/stackstate"
 	"github.com/hashicorp/terraform/internal/states"
 	"github.com/hashicorp/terraform/internal/tfdiags"
@@ -24,9 +31,295 @@ type Migration struct {
 	// Providers is a map of provider addresses available to the stack.
 	Providers map[addrs.Provider]providers.Factory
 
+	// migratedObjects records the resource instance object addresses emitted
+	// by the most recent call to Migrate, so that VerifyAgainstPlan can
+	// cross-check them against a plan of the target configuration.
+	migratedObjects []stackaddrs.AbsResourceInstanceObject
+
 	// PreviousState is the terraform core state that we are migrating from.
 	PreviousState *states.State
 	Config        *stackconfig.Config
+
+	// DependencyLocks optionally holds the dependency lock file for the
+	// destination stack. When set, Migrate verifies before doing any work
+	// that every provider recorded against a resource in the previous state
+	// is present in the locks, and refuses to migrate otherwise. Callers who
+	// manage provider locking themselves can leave this nil to skip the
+	// check.
+	DependencyLocks *depsfile.Locks
+
+	// ProviderConfigRemap optionally rewrites the provider configuration
+	// address recorded against each migrated resource instance object, for
+	// situations where the destination stack exposes a provider under a
+	// different configuration address than the source configuration did.
+	// Addresses that have no entry in the map are kept as-is.
+	ProviderConfigRemap addrs.Map[addrs.AbsProviderConfig, addrs.AbsProviderConfig]
+
+	// StopOnError causes the migration to halt as soon as an error
+	// diagnostic is delivered to the caller's diagnostics callback: no
+	// further applied changes or diagnostics are emitted after the first
+	// error. Changes that were already emitted before the error are still
+	// valid on their own, but together they describe only a partial stack
+	// state, so callers should discard them when this flag is set. When
+	// false, migration continues past errors and migrates whatever it can.
+	StopOnError bool
+
+	// Filter optionally restricts the migration to only the resource
+	// instances for which the predicate returns true, so that a large state
+	// can be migrated in phases. Resource instances that are filtered out
+	// are left entirely untouched: they produce no applied changes, no
+	// component instances, and no diagnostics. A nil Filter migrates
+	// everything.
+	Filter func(addrs.AbsResourceInstance) bool
+
+	// CoerceToCurrentSchema causes the stored attribute data of each
+	// migrated object to be decoded against the current resource type schema
+	// and re-encoded before it is emitted, so that attributes added to the
+	// schema since the state was written are present with null values in the
+	// stack state. Stored data that cannot be decoded against the current
+	// schema is reported as an error diagnostic. When false, attribute data
+	// is copied verbatim from the previous state.
+	CoerceToCurrentSchema bool
+
+	// StripAttributes optionally lists attribute paths to null out in the
+	// stored attribute data of migrated objects before they are emitted,
+	// keyed by resource type. Paths use dotted notation to address attributes
+	// nested inside object-typed attributes. This is for scrubbing values
+	// that should never have been persisted, such as ephemeral tokens, from
+	// the migrated state. The attributes are nulled rather than removed so
+	// that the result still conforms to the resource type schema, and a path
+	// that doesn't match the schema is reported as an error diagnostic.
+	StripAttributes map[string][]string
+
+	// NullWriteOnlyAttributes causes any value stored for a write-only
+	// attribute of a migrated object to be replaced with null before the
+	// object is emitted. Write-only attributes are ephemeral and should never
+	// have been persisted, so a stored value is necessarily stale; each
+	// object that had such a value scrubbed is reported with a warning
+	// diagnostic. When false, attribute data is migrated verbatim even if it
+	// includes write-only attribute values.
+	NullWriteOnlyAttributes bool
+
+	// RegenerateDeposedKeys causes each migrated deposed object to be
+	// assigned a newly-generated deposed key instead of keeping the key it
+	// had in the previous state. The default is to preserve the original
+	// keys, so that objects can be correlated across the migration.
+	RegenerateDeposedKeys bool
+
+	// DropDeposed causes deposed objects in the previous state to be skipped
+	// entirely rather than migrated, so that the stack starts with a clean
+	// current-only state. A single warning summarizes how many deposed
+	// objects were dropped from each resource. The default is to migrate
+	// deposed objects along with everything else.
+	DropDeposed bool
+
+	// FlagTaintedForReplacement records every tainted resource instance in
+	// [MigrationResult.TaintedResources]. A tainted object's status is
+	// always carried into the stack state (where it's stored as "damaged"),
+	// so the next plan proposes replacement either way; the flag exists so
+	// callers can report up front which resources that will affect, rather
+	// than having the replacements surface as a surprise in the first plan.
+	FlagTaintedForReplacement bool
+
+	// ResolveInputs causes each migrated component instance to record
+	// concrete values for the input variables whose expressions in the stack
+	// configuration are constants, instead of recording every input as
+	// unknown until the first plan. Inputs that reference variables, other
+	// components, or anything else that only the stack runtime can evaluate
+	// are still recorded as unknown. Resolving constants up front reduces
+	// the diff reported by the first plan against the migrated state.
+	ResolveInputs bool
+
+	// ComponentsOnly causes the migration to emit only the component
+	// instances and their dependency graph, with no resource instance
+	// objects, as the first phase of a staged rollout. A later run without
+	// this flag can then fill in the resources; the component instances it
+	// re-derives will match the ones emitted here, since both are computed
+	// from the same mappings.
+	ComponentsOnly bool
+
+	// DeduplicateDiagnostics collapses diagnostics that share a severity and
+	// summary into a single diagnostic reporting how many times the problem
+	// occurred, keeping the first occurrence as the sample. This keeps the
+	// output manageable when e.g. a missing provider affects every resource
+	// in a large state. Deduplicated diagnostics are delivered together once
+	// the migration has finished, rather than as the problems are found.
+	DeduplicateDiagnostics bool
+
+	// ShardByComponent buffers the emitted changes and delivers them grouped
+	// by component instance, in component address order, once the migration
+	// has finished, instead of streaming them in the order the resources
+	// were visited. Consumers that write each component's state separately
+	// can then treat the first change for a new component as a shard
+	// boundary; the [Migration.MigrateSharded] entry point makes those
+	// boundaries explicit. Buffering the whole change stream costs memory
+	// proportional to the state size, so leave this unset when the ordering
+	// doesn't matter.
+	ShardByComponent bool
+
+	// ResourceTypeRename optionally maps resource type names as recorded in
+	// the previous state to the name the provider uses for them now, for
+	// providers that renamed a resource type between releases. Each matching
+	// resource instance is recorded under the new type name, with its
+	// attribute data carried over unchanged on the assumption that the two
+	// types share a compatible schema; a warning is emitted when the stored
+	// data does not decode cleanly against the new type's schema.
+	ResourceTypeRename map[string]string
+
+	// ExistingState optionally refers to the stack state produced by an
+	// earlier migration run. When set, Migrate behaves as a reconcile
+	// operation: resource instance objects that are already recorded
+	// identically in this state, and component instances it already tracks,
+	// are not emitted again, so re-running a migration after a partial
+	// failure only emits what is new or different.
+	ExistingState *stackstate.State
+
+	// MergePolicy decides what happens when the mappings direct resources
+	// into a component instance that ExistingState already tracks. The
+	// default, [MergeExistingComponents], adds the migrated resources
+	// alongside whatever the component instance already holds.
+	// [ConflictOnExistingComponents] instead treats such a mapping as a
+	// configuration mistake and reports it as an error before any changes
+	// are emitted. This field has no effect when ExistingState is nil.
+	MergePolicy MergePolicy
+
+	// PerInstanceComponents controls how instances of a child module listed
+	// in the modules mapping are assigned to component instances. When true,
+	// each module instance becomes its own component instance, with the
+	// module instance key preserved as the component instance key (e.g.
+	// module.child["a"] becomes component.child["a"]). When false, all
+	// instances collapse into a single unkeyed component instance, which is
+	// only safe if their resource instance addresses do not collide.
+	PerInstanceComponents bool
+
+	// OutputRename optionally maps output names recorded in the previous
+	// state to the name the target component declares for the same value,
+	// for modules whose outputs were renamed as part of adopting stacks.
+	// Only root module output values are persisted in state, so the mapping
+	// is keyed by the plain output name. The renamed target must be
+	// declared by the component's module; mapping onto an undeclared output
+	// is reported as an error, since the rename states an explicit intent
+	// that silently dropping the value would betray.
+	OutputRename map[string]string
+
+	// ComponentNamer optionally derives a component name from a module
+	// instance address, for child modules that have no explicit entry in the
+	// modules mapping. This supports automated migrations that name
+	// components systematically instead of enumerating every module. The
+	// produced name must be a valid component name and must refer to a
+	// component declared in the root stack configuration; a name that is not
+	// a valid identifier is reported as an error. Explicit mapping entries
+	// always take precedence over the naming function. When nil, unmapped
+	// modules are reported as unmigrated, as before.
+	ComponentNamer func(addrs.ModuleInstance) string
+
+	// Progress is an optional callback that is invoked with a
+	// [MigrationProgress] snapshot each time the migration finishes
+	// processing a resource instance or a component, so callers can render
+	// feedback during long migrations. It may be left nil.
+	Progress func(progress MigrationProgress)
+}
+
+// MergePolicy controls how [Migration.Migrate] treats resources that the
+// mappings direct into a component instance that already exists in the
+// configured ExistingState.
+type MergePolicy int
+
+const (
+	// MergeExistingComponents allows resources to be migrated into component
+	// instances that already exist, adding to whatever those instances
+	// already track. This is the default.
+	MergeExistingComponents MergePolicy = iota
+
+	// ConflictOnExistingComponents reports an error diagnostic for each
+	// component instance that both already exists and would receive migrated
+	// resources, and refuses to migrate before emitting any changes.
+	ConflictOnExistingComponents
+)
+
+// MigrationProgress describes how far through a migration we have gotten.
+//
+// The counts are monotonic: TotalResourceInstances is discovered from the
+// previous state before any work begins and does not change, while the
+// migrated counts only ever increase.
+type MigrationProgress struct {
+	// TotalResourceInstances is the total number of resource instances
+	// discovered in the previous state, whether or not they end up being
+	// migrated. Instances excluded by a configured Filter are not counted.
+	TotalResourceInstances int
+
+	// MigratedResourceInstances is the number of resource instances that
+	// have been processed so far.
+	MigratedResourceInstances int
+
+	// MigratedComponents is the number of component instances that have
+	// been emitted so far.
+	MigratedComponents int
+}
+
+// MigrationResult summarizes a completed migration, so that callers can
+// assert on what happened without reconstructing the counts from the stream
+// of [stackstate.AppliedChange] values delivered to the emit callback.
+type MigrationResult struct {
+	// ResourceInstanceObjects is the total number of resource instance
+	// objects that were migrated, including deposed objects.
+	ResourceInstanceObjects int
+
+	// DeposedObjects is the number of those objects that were deposed
+	// rather than current.
+	DeposedObjects int
+
+	// Components is the number of component instances that were created.
+	Components int
+
+	// Providers is the set of distinct providers that the migrated resource
+	// instance objects belong to.
+	Providers collections.Set[addrs.Provider]
+
+	// TaintedResources is the set of migrated resource instances whose
+	// current object was tainted in the previous state, populated only when
+	// [Migration.FlagTaintedForReplacement] is set. The next plan of the
+	// target stack will propose to replace these instances.
+	TaintedResources collections.Set[stackaddrs.AbsResourceInstance]
+
+	// SourceModules records, for each component instance that received
+	// migrated resources, the module instance in the previous state that
+	// those resources came from, so that callers can report how old and new
+	// addresses correlate. When several module instances collapse into a
+	// single component instance (see [Migration.PerInstanceComponents]) one
+	// of them is recorded as a representative.
+	SourceModules collections.Map[stackaddrs.AbsComponentInstance, addrs.ModuleInstance]
+}
+
+// MigrationFailure is one entry of a [MigrationError], describing a single
+// error diagnostic in a form convenient for programmatic inspection.
+type MigrationFailure struct {
+	// Addr is the address the diagnostic relates to, when the diagnostic
+	// carries one, or empty otherwise.
+	Addr string
+
+	// Summary and Detail echo the corresponding parts of the diagnostic's
+	// description.
+	Summary string
+	Detail  string
+}
+
+// MigrationError aggregates every error diagnostic reported during a call to
+// [Migration.MigrateResult] into a single error value, so that callers who
+// don't need live streaming can inspect all failures at once.
+type MigrationError struct {
+	Failures []MigrationFailure
+}
+
+func (e *MigrationError) Error() string {
+	if len(e.Failures) == 1 {
+		return fmt.Sprintf("migration failed: %s", e.Failures[0].Summary)
+	}
+	summaries := make([]string, len(e.Failures))
+	for i, failure := range e.Failures {
+		summaries[i] = failure.Summary
+	}
+	return fmt.Sprintf("migration failed with %d errors: %s", len(e.Failures), strings.Join(summaries, "; "))
 }
 
 // Alias common types to make the code more readable.
@@ -42,34 +335,745 @@ type (
 	AbsComponent = stackaddrs.AbsComponent
 )
 
-func (m *Migration) Migrate(resources map[string]string, modules map[string]string, emit func(change stackstate.AppliedChange), emitDiag func(diagnostic tfdiags.Diagnostic)) {
+// Migrate runs the migration without any external cancellation: it is
+// equivalent to calling [Migration.MigrateContext] with context.Background().
+func (m *Migration) Migrate(resources map[string]string, modules map[string]string, emit func(change stackstate.AppliedChange), emitDiag func(diagnostic tfdiags.Diagnostic)) MigrationResult {
+	return m.MigrateContext(context.Background(), resources, modules, emit, emitDiag)
+}
+
+// MigrateResult behaves like [Migration.Migrate], but additionally collects
+// every error diagnostic into a single [*MigrationError], returned alongside
+// the usual [MigrationResult]. The error is nil when no error diagnostics
+// were reported. The streaming callbacks still receive every change and
+// diagnostic as the migration progresses, so UIs that want live output lose
+// nothing by using this entry point.
+func (m *Migration) MigrateResult(resources map[string]string, modules map[string]string, emit func(change stackstate.AppliedChange), emitDiag func(diagnostic tfdiags.Diagnostic)) (MigrationResult, error) {
+	var failures []MigrationFailure
+	result := m.Migrate(resources, modules, emit, func(diagnostic tfdiags.Diagnostic) {
+		if diagnostic.Severity() == tfdiags.Error {
+			desc := diagnostic.Description()
+			failures = append(failures, MigrationFailure{
+				Addr:    desc.Address,
+				Summary: desc.Summary,
+				Detail:  desc.Detail,
+			})
+		}
+		emitDiag(diagnostic)
+	})
+	if len(failures) > 0 {
+		return result, &MigrationError{Failures: failures}
+	}
+	return result, nil
+}
+
+// ComponentShard is one group of applied changes delivered by
+// [Migration.MigrateSharded], covering a single component instance.
+type ComponentShard struct {
+	// Component is the component instance that the changes belong to.
+	Component stackaddrs.AbsComponentInstance
+
+	// Changes are the applied changes for that component instance, in the
+	// order they were originally emitted.
+	Changes []stackstate.AppliedChange
+}
+
+// MigrateSharded behaves like [Migration.Migrate] but partitions the emitted
+// changes by component instance, delivering everything for one component as
+// a single [ComponentShard]. Each callback invocation is therefore a shard
+// boundary, so a consumer can write each component's state separately
+// without inspecting the individual changes for their addresses. Shards are
+// delivered in component address order once the migration has finished;
+// diagnostics still stream as they are found.
+func (m *Migration) MigrateSharded(resources map[string]string, modules map[string]string, emitShard func(shard ComponentShard), emitDiag func(diagnostic tfdiags.Diagnostic)) MigrationResult {
+	var changes []stackstate.AppliedChange
+	result := m.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		changes = append(changes, change)
+	}, emitDiag)
+	for _, shard := range shardChangesByComponent(changes) {
+		emitShard(shard)
+	}
+	return result
+}
+
+// shardChangesByComponent partitions a sequence of applied changes into one
+// [ComponentShard] per component instance, sorted by component address, with
+// each shard preserving the original relative order of its changes.
+func shardChangesByComponent(changes []stackstate.AppliedChange) []ComponentShard {
+	index := make(map[string]int)
+	var shards []ComponentShard
+	for _, change := range changes {
+		addr := appliedChangeComponentInstance(change)
+		key := addr.String()
+		i, ok := index[key]
+		if !ok {
+			i = len(shards)
+			index[key] = i
+			shards = append(shards, ComponentShard{Component: addr})
+		}
+		shards[i].Changes = append(shards[i].Changes, change)
+	}
+	sort.Slice(shards, func(i, j int) bool {
+		return shards[i].Component.String() < shards[j].Component.String()
+	})
+	return shards
+}
+
+// appliedChangeComponentInstance returns the component instance that an
+// applied change emitted by this package belongs to.
+func appliedChangeComponentInstance(change stackstate.AppliedChange) stackaddrs.AbsComponentInstance {
+	switch change := change.(type) {
+	case *stackstate.AppliedChangeComponentInstance:
+		return change.ComponentInstanceAddr
+	case *stackstate.AppliedChangeResourceInstanceObject:
+		return change.ResourceInstanceObjectAddr.Component
+	default:
+		// Should not get here: the migration only emits the change types
+		// handled above.
+		panic(fmt.Sprintf("unsupported applied change type %T", change))
+	}
+}
+
+// shardEmittedChanges wraps an emit callback so that changes are buffered
+// until flush is called and then delivered grouped by component instance, in
+// component address order. This is the plain-callback form of
+// [Migration.MigrateSharded], for callers that want the grouped ordering
+// without switching to the shard callback signature.
+func shardEmittedChanges(emit func(change stackstate.AppliedChange)) (func(change stackstate.AppliedChange), func()) {
+	var changes []stackstate.AppliedChange
+	wrapped := func(change stackstate.AppliedChange) {
+		changes = append(changes, change)
+	}
+	flush := func() {
+		for _, shard := range shardChangesByComponent(changes) {
+			for _, change := range shard.Changes {
+				emit(change)
+			}
+		}
+	}
+	return wrapped, flush
+}
+
+// DiscoverComponents reports the component instances that a call to
+// [Migration.Migrate] with the same mappings would create, sorted by address,
+// without emitting any applied changes. This is a cheap preview for callers
+// that want to show the shape of the target stack before the changes stream,
+// without the extra detail of the full dependency graph.
+//
+// Any diagnostics that the component discovery produces, such as mapping
+// entries that don't parse, are returned rather than streamed.
+func (m *Migration) DiscoverComponents(resources map[string]string, modules map[string]string) ([]stackaddrs.AbsComponentInstance, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	// The discovery is just a components-only migration with the applied
+	// changes discarded, so the preview can never disagree with what a later
+	// full Migrate emits for the same mappings.
+	previous := m.ComponentsOnly
+	m.ComponentsOnly = true
+	defer func() { m.ComponentsOnly = previous }()
+
+	var discovered []stackaddrs.AbsComponentInstance
+	m.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeComponentInstance); ok {
+			discovered = append(discovered, c.ComponentInstanceAddr)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		diags = diags.Append(diagnostic)
+	})
+
+	sort.Slice(discovered, func(i, j int) bool {
+		return discovered[i].String() < discovered[j].String()
+	})
+	return discovered, diags
+}
+
+// MigrateContext behaves like [Migration.Migrate], but additionally honors
+// cancellation of the given context. Cancellation is checked between resource
+// instances and between components, so a long migration driven by e.g. a
+// server request aborts promptly once the request is cancelled: a diagnostic
+// reporting the interruption is delivered, no further changes are emitted,
+// and any provider instances that were started are still closed.
+func (m *Migration) MigrateContext(ctx context.Context, resources map[string]string, modules map[string]string, emit func(change stackstate.AppliedChange), emitDiag func(diagnostic tfdiags.Diagnostic)) MigrationResult {
+	m.migratedObjects = nil
 
 	migration := &migration{
+		ctx:       ctx,
 		Migration: m,
-		emit:      emit,
-		emitDiag:  emitDiag,
 		providers: make(map[addrs.Provider]providers.Interface),
 		parser:    configs.NewSourceBundleParser(m.Config.Sources),
 		configs:   make(map[sourceaddrs.FinalSource]*configs.Config),
+		result: MigrationResult{
+			Providers:        collections.NewSetCmp[addrs.Provider](),
+			SourceModules:    collections.NewMap[stackaddrs.AbsComponentInstance, addrs.ModuleInstance](),
+			TaintedResources: collections.NewSet[stackaddrs.AbsResourceInstance](),
+		},
+		unmatchedOutputs: make(map[string]bool),
+	}
+
+	if m.DeduplicateDiagnostics {
+		var flush func()
+		emitDiag, flush = deduplicateDiagnostics(emitDiag)
+		defer flush()
+	}
+
+	if m.ShardByComponent {
+		var flush func()
+		emit, flush = shardEmittedChanges(emit)
+		defer flush()
+	}
+
+	// The callbacks are wrapped so that, once StopOnError has tripped, the
+	// caller stops receiving changes and diagnostics entirely.
+	migration.emit = func(change stackstate.AppliedChange) {
+		if migration.stopped {
+			return
+		}
+		emit(change)
+	}
+	migration.emitDiag = func(diagnostic tfdiags.Diagnostic) {
+		if migration.stopped {
+			return
+		}
+		emitDiag(diagnostic)
+		if m.StopOnError && diagnostic.Severity() == tfdiags.Error {
+			migration.stopped = true
+		}
 	}
 
 	defer migration.close() // cleanup any opened providers.
 
+	// Count the resource instances up front so that progress reports have a
+	// stable total from the start.
+	for _, resource := range migration.stateResources() {
+		migration.progress.TotalResourceInstances += len(migration.includedInstances(resource))
+	}
+
+	// If the caller gave us a lock file, make sure it covers every provider
+	// the previous state depends on before we emit anything, rather than
+	// failing partway through and leaving a half-built stack state.
+	if !migration.verifyDependencyLocks() {
+		return migration.result
+	}
+
+	// Similarly, mappings that would merge resources from different source
+	// modules into one component instance are a configuration mistake that
+	// we detect up front, before emitting any changes.
+	if !migration.verifyComponentInstanceUniqueness(resources, modules) {
+		return migration.result
+	}
+
+	// Mappings may route resources to keyed component instances, but a
+	// component addressed both with and without instance keys is almost
+	// certainly a mistake, so we also detect that up front.
+	if !migration.verifyComponentInstanceKeys(resources, modules) {
+		return migration.result
+	}
+
+	// When migrating into an existing stack state, the merge policy decides
+	// whether landing resources in a component instance that already exists
+	// is acceptable or an error.
+	if !migration.verifyMergePolicy(resources, modules) {
+		return migration.result
+	}
+
 	components := migration.migrateResources(resources, modules)
-	migration.migrateComponents(components)
+	if !migration.stopped {
+		migration.migrateComponents(components, modules)
+	}
 
 	// Everything is migrated!
+	return migration.result
+}
+
+// ExpandResourceTypeMapping converts a convenience mapping from managed
+// resource type to component name into the per-resource mapping accepted by
+// [Migration.Migrate], by enumerating the root module resources of the
+// previous state. Large root modules often split naturally by resource type,
+// and this saves listing every resource address individually.
+//
+// Every managed resource type in the root module must be covered by the
+// mapping: an uncovered type is reported as an error diagnostic rather than
+// silently leaving its resources behind. Resources in child modules are not
+// considered here; route those with the modules mapping as usual.
+func (m *Migration) ExpandResourceTypeMapping(types map[string]string) (map[string]string, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+	resources := make(map[string]string)
+	uncovered := make(map[string]bool)
+	for _, resource := range m.PreviousState.RootModule().Resources {
+		if resource.Addr.Resource.Mode != addrs.ManagedResourceMode {
+			continue
+		}
+		target, ok := types[resource.Addr.Resource.Type]
+		if !ok {
+			uncovered[resource.Addr.Resource.Type] = true
+			continue
+		}
+		resources[resource.Addr.Resource.String()] = target
+	}
+
+	missing := make([]string, 0, len(uncovered))
+	for name := range uncovered {
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		diags = diags.Append(tfdiags.Sourceless(tfdiags.Error, "Resource type not mapped", fmt.Sprintf("The root module of the previous state contains resources of type %q, but the type mapping does not assign that type to a component.", name)))
+	}
+	return resources, diags
+}
+
+// VerifyAgainstPlan cross-checks the objects emitted by the most recent call
+// to [Migration.Migrate] against a plan of the target stack configuration,
+// which the caller typically creates by planning the target config with the
+// migrated state. Every migrated object must appear in the prior state of its
+// component instance in the plan, and must not carry a planned change other
+// than a no-op; anything else suggests a mapping mistake that would otherwise
+// only surface as drift on the first real apply.
+//
+// Missing objects are reported as errors, since the plan would recreate or
+// silently forget them, while unexpected planned changes are reported as
+// warnings because they may also be legitimate differences between the old
+// and new configurations.
+func (m *Migration) VerifyAgainstPlan(plan *stackplan.Plan) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	objects := make([]stackaddrs.AbsResourceInstanceObject, len(m.migratedObjects))
+	copy(objects, m.migratedObjects)
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].String() < objects[j].String()
+	})
+
+	for _, addr := range objects {
+		component, ok := plan.Components.GetOk(addr.Component)
+		if !ok {
+			diags = diags.Append(tfdiags.Sourceless(tfdiags.Error, "Component instance missing from plan", fmt.Sprintf("The migrated object %s belongs to %s, which the plan does not include. The component may be missing from the target configuration, or the mapping may name the wrong component.", addr, addr.Component)))
+			continue
+		}
+		if _, ok := component.ResourceInstancePriorState.GetOk(addr.Item); !ok {
+			diags = diags.Append(tfdiags.Sourceless(tfdiags.Error, "Migrated resource missing from plan", fmt.Sprintf("The migrated object %s does not appear in the plan's prior state for %s, so the plan does not account for it at all.", addr, addr.Component)))
+			continue
+		}
+		if change, ok := component.ResourceInstancePlanned.GetOk(addr.Item); ok && change.Action != plans.NoOp {
+			diags = diags.Append(tfdiags.Sourceless(tfdiags.Warning, "Unexpected planned change", fmt.Sprintf("The plan proposes to %s %s even though it was just migrated. This may indicate a mapping mistake, or a legitimate difference between the old and new configurations.", change.Action.String(), addr)))
+		}
+	}
+	return diags
+}
+
+// VerifyComponentInputs cross-checks the component instances among the given
+// applied changes against the target configuration, reporting an error for
+// any recorded input variable that the component's module does not declare,
+// and for any declared variable without a default that the change does not
+// populate. A stack state containing either mismatch is invalid.
+//
+// Migrate derives the emitted input variables directly from the module
+// declarations, so a mismatch normally means the changes were edited or
+// combined with changes from another source before being applied; this is a
+// safety net for callers that post-process the change stream before handing
+// it to a stacks backend.
+func (m *Migration) VerifyComponentInputs(changes []stackstate.AppliedChange) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	migration := &migration{
+		Migration: m,
+		parser:    configs.NewSourceBundleParser(m.Config.Sources),
+		configs:   make(map[sourceaddrs.FinalSource]*configs.Config),
+	}
+
+	for _, change := range changes {
+		c, ok := change.(*stackstate.AppliedChangeComponentInstance)
+		if !ok {
+			continue
+		}
+
+		component := m.Config.Component(stackaddrs.ConfigComponentForAbsInstance(c.ComponentInstanceAddr))
+		if component == nil {
+			diags = diags.Append(tfdiags.Sourceless(tfdiags.Error, "Component not declared", fmt.Sprintf("The change for %s refers to a component that the target configuration does not declare.", c.ComponentInstanceAddr)))
+			continue
+		}
+		config, moreDiags := migration.moduleConfig(component)
+		if moreDiags.HasErrors() {
+			diags = diags.Append(moreDiags)
+			continue
+		}
+
+		var undeclared, missing []string
+		for variable := range c.InputVariables {
+			if _, ok := config.Module.Variables[variable.Name]; !ok {
+				undeclared = append(undeclared, variable.Name)
+			}
+		}
+		for name, decl := range config.Module.Variables {
+			if !decl.Required() {
+				continue
+			}
+			if _, ok := c.InputVariables[addrs.InputVariable{Name: name}]; !ok {
+				missing = append(missing, name)
+			}
+		}
+
+		if len(undeclared) > 0 {
+			sort.Strings(undeclared)
+			diags = diags.Append(tfdiags.Sourceless(tfdiags.Error, "Undeclared component inputs", fmt.Sprintf("The change for %s records input variables that the component's module does not declare: %s.", c.ComponentInstanceAddr, strings.Join(undeclared, ", "))))
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			diags = diags.Append(tfdiags.Sourceless(tfdiags.Error, "Missing required component inputs", fmt.Sprintf("The change for %s does not record the required input variables %s declared by the component's module.", c.ComponentInstanceAddr, strings.Join(missing, ", "))))
+		}
+	}
+	return diags
+}
+
+// Preflight checks that every resource type recorded in the previous state
+// has a corresponding schema available from the configured providers, so that
+// callers can confirm before migrating that the migration cannot fail
+// partway through on schema availability. Missing providers, schema load
+// failures, and missing resource types are all reported together as a batch.
+//
+// This is deliberately independent of the resource and module mappings: it
+// validates schema availability only, and emits no changes.
+func (m *Migration) Preflight() tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+	migration := &migration{
+		Migration: m,
+		providers: make(map[addrs.Provider]providers.Interface),
+	}
+	migration.emitDiag = func(diagnostic tfdiags.Diagnostic) {
+		diags = diags.Append(diagnostic)
+	}
+	defer migration.close()
+
+	// We check each distinct provider and resource type pair only once, no
+	// matter how many resources use it.
+	checkedProviders := collections.NewSetCmp[addrs.Provider]()
+	failedProviders := collections.NewSetCmp[addrs.Provider]()
+	missingTypes := make(map[string]bool)
+
+	for _, resource := range migration.stateResources() {
+		if resource.Addr.Resource.Mode != addrs.ManagedResourceMode {
+			// Data resources are never migrated, so their schemas are not
+			// needed.
+			continue
+		}
+		if len(migration.includedInstances(resource)) == 0 {
+			continue
+		}
+
+		providerAddr := resource.ProviderConfig.Provider
+		if failedProviders.Has(providerAddr) {
+			// Already reported when we first tried this provider.
+			continue
+		}
+
+		provider, moreDiags := migration.provider(providerAddr)
+		if moreDiags.HasErrors() {
+			failedProviders.Add(providerAddr)
+			diags = diags.Append(moreDiags)
+			continue
+		}
+
+		if !checkedProviders.Has(providerAddr) {
+			checkedProviders.Add(providerAddr)
+			if schemaDiags := provider.GetProviderSchema().Diagnostics; schemaDiags.HasErrors() {
+				failedProviders.Add(providerAddr)
+				diags = diags.Append(tfdiags.Sourceless(tfdiags.Error, "Failed to load provider schema", fmt.Sprintf("Could not load the schema for provider %s: %s.", providerAddr.ForDisplay(), schemaDiags.Err())))
+				continue
+			}
+		}
+
+		resourceType := resource.Addr.Resource.Type
+		schema := provider.GetProviderSchema().SchemaForResourceType(resource.Addr.Resource.Mode, resourceType)
+		if schema.Body == nil {
+			missingTypes[fmt.Sprintf("Resource type %q is not supported by provider %s, which is required by %s in the previous state.", resourceType, providerAddr.ForDisplay(), resource.Addr)] = true
+		}
+	}
+
+	var missing []string
+	for detail := range missingTypes {
+		missing = append(missing, detail)
+	}
+	sort.Strings(missing)
+	for _, detail := range missing {
+		diags = diags.Append(tfdiags.Sourceless(tfdiags.Error, "Resource type not found", detail))
+	}
+
+	return diags
 }
 
 type migration struct {
 	*Migration
 
+	// ctx carries cancellation for the migration as a whole; it is checked
+	// between resource instances and between components.
+	ctx context.Context
+
 	emit     func(change stackstate.AppliedChange)
 	emitDiag func(diagnostic tfdiags.Diagnostic)
 
 	providers map[addrs.Provider]providers.Interface
 	parser    *configs.SourceBundleParser
 	configs   map[sourceaddrs.FinalSource]*configs.Config
+
+	progress MigrationProgress
+	result   MigrationResult
+
+	// stopped records that no further changes or diagnostics should be
+	// emitted, either because StopOnError is set and an error diagnostic has
+	// already been delivered, or because the context was cancelled.
+	stopped bool
+
+	// unmatchedOutputs tracks which state output values have already been
+	// reported as having no matching component output declaration, keyed by
+	// module instance and output name, so each one warns only once.
+	unmatchedOutputs map[string]bool
+}
+
+// verifyDependencyLocks checks that every provider recorded against a managed
+// resource in the previous state is present in the configured DependencyLocks,
+// emitting an error diagnostic for each missing provider. It returns true if
+// migration can proceed, which includes the case where no locks were
+// configured at all.
+func (m *migration) verifyDependencyLocks() bool {
+	if m.DependencyLocks == nil {
+		return true
+	}
+
+	missing := collections.NewSetCmp[addrs.Provider]()
+	for _, resource := range m.stateResources() {
+		if resource.Addr.Resource.Mode != addrs.ManagedResourceMode {
+			// Data resources are never migrated, so they don't need their
+			// provider to be locked.
+			continue
+		}
+		if len(m.includedInstances(resource)) == 0 {
+			continue
+		}
+		provider := resource.ProviderConfig.Provider
+		if !depsfile.ProviderIsLockable(provider) {
+			continue
+		}
+		if m.DependencyLocks.Provider(provider) == nil {
+			missing.Add(provider)
+		}
+	}
+
+	for provider := range missing.All() {
+		m.emitDiag(tfdiags.Sourceless(tfdiags.Error, "Provider not locked", fmt.Sprintf("Provider %s is required by resources in the previous state but is not recorded in the dependency lock file. Run \"terraform init\" to install the missing providers before migrating.", provider.ForDisplay())))
+	}
+	return missing.Len() == 0
+}
+
+// verifyComponentInstanceUniqueness checks that no two different source
+// modules in the previous state map to the same component instance address,
+// which would silently merge unrelated modules into one component and corrupt
+// the resulting stack state. It also checks that no two resource instances
+// would end up at the same address within a component, which can happen when
+// several instances of the same module collapse into one component instance
+// (see [Migration.PerInstanceComponents]); the later write would otherwise
+// silently replace the earlier one. It emits an error diagnostic naming the
+// source addresses for each collision and returns true if migration can
+// proceed.
+func (m *migration) verifyComponentInstanceUniqueness(resources map[string]string, modules map[string]string) bool {
+	type targetSources struct {
+		component Instance
+		froms     map[string]bool
+	}
+	sources := collections.NewMap[Instance, map[string]bool]()
+	targets := make(map[string]*targetSources)
+	for _, resource := range m.stateResources() {
+		if resource.Addr.Resource.Mode != addrs.ManagedResourceMode {
+			continue
+		}
+		instances := m.includedInstances(resource)
+		if len(instances) == 0 {
+			continue
+		}
+		target, diags := m.search(resource.Addr, resources, modules)
+		if diags.HasErrors() || target == nil {
+			// Invalid and unmapped addresses are reported during the main
+			// migration pass; here we only care about mapped resources.
+			continue
+		}
+		source := "the root module"
+		if !target.ContainingModule.IsRoot() {
+			source = target.ContainingModule.String()
+		}
+		instance := target.AbsResource.Component
+		if !sources.HasKey(instance) {
+			sources.Put(instance, make(map[string]bool))
+		}
+		sources.Get(instance)[source] = true
+
+		for instanceKey := range instances {
+			instanceAddr := stackaddrs.AbsResourceInstance{
+				Component: instance,
+				Item:      target.AbsResource.Item.Instance(instanceKey),
+			}
+			if targets[instanceAddr.String()] == nil {
+				targets[instanceAddr.String()] = &targetSources{
+					component: instance,
+					froms:     make(map[string]bool),
+				}
+			}
+			targets[instanceAddr.String()].froms[resource.Addr.Instance(instanceKey).String()] = true
+		}
+	}
+
+	var colliding []Instance
+	for instance, froms := range sources.All() {
+		if len(froms) > 1 {
+			colliding = append(colliding, instance)
+		}
+	}
+	sort.Slice(colliding, func(i, j int) bool {
+		return colliding[i].String() < colliding[j].String()
+	})
+	for _, instance := range colliding {
+		var names []string
+		for name := range sources.Get(instance) {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		m.emitDiag(tfdiags.Sourceless(tfdiags.Error, "Duplicate component instance", fmt.Sprintf("Resources from multiple source modules (%s) would be migrated into the same component instance %s. Each component instance can receive resources from only one module, so update the resource or module mappings to give these modules distinct component instances.", strings.Join(names, ", "), instance)))
+	}
+
+	collidingInstances := make(map[string]bool, len(colliding))
+	for _, instance := range colliding {
+		collidingInstances[instance.String()] = true
+	}
+
+	var collidingAddrs []string
+	for addr, target := range targets {
+		if len(target.froms) > 1 && !collidingInstances[target.component.String()] {
+			// Addresses inside a component instance that was already
+			// reported as a module-level collision are a consequence of
+			// that collision, so we don't report them again.
+			collidingAddrs = append(collidingAddrs, addr)
+		}
+	}
+	sort.Strings(collidingAddrs)
+	for _, addr := range collidingAddrs {
+		var names []string
+		for name := range targets[addr].froms {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		m.emitDiag(tfdiags.Sourceless(tfdiags.Error, "Duplicate resource instance address", fmt.Sprintf("Resource instances %s would all be migrated to %s, and all but one of them would be lost. Set PerInstanceComponents to keep each module instance as its own component instance, or update the mappings so that each resource instance keeps a distinct address.", strings.Join(names, ", "), addr)))
+	}
+
+	return len(colliding) == 0 && len(collidingAddrs) == 0
+}
+
+// verifyComponentInstanceKeys checks that the mappings address each component
+// consistently: its target instances must either all carry instance keys or
+// all be unkeyed. A mixture would produce a component that is simultaneously
+// single-instance and multi-instance, which no configuration can describe, so
+// it is reported as an error before any changes are emitted.
+func (m *migration) verifyComponentInstanceKeys(resources map[string]string, modules map[string]string) bool {
+	keyed := collections.NewSet[AbsComponent]()
+	unkeyed := collections.NewSet[AbsComponent]()
+	for _, resource := range m.stateResources() {
+		if resource.Addr.Resource.Mode != addrs.ManagedResourceMode {
+			continue
+		}
+		if len(m.includedInstances(resource)) == 0 {
+			continue
+		}
+		target, diags := m.search(resource.Addr, resources, modules)
+		if diags.HasErrors() || target == nil {
+			// Invalid and unmapped addresses are reported during the main
+			// migration pass; here we only care about mapped resources.
+			continue
+		}
+		instance := target.AbsResource.Component
+		component := AbsComponent{
+			Stack: instance.Stack,
+			Item:  instance.Item.Component,
+		}
+		if instance.Item.Key == addrs.NoKey {
+			unkeyed.Add(component)
+		} else {
+			keyed.Add(component)
+		}
+	}
+
+	var mixed []AbsComponent
+	for component := range keyed.All() {
+		if unkeyed.Has(component) {
+			mixed = append(mixed, component)
+		}
+	}
+	sort.Slice(mixed, func(i, j int) bool {
+		return mixed[i].String() < mixed[j].String()
+	})
+	for _, component := range mixed {
+		m.emitDiag(tfdiags.Sourceless(tfdiags.Error, "Inconsistent component instance keys", fmt.Sprintf("The resource or module mappings address %s both with and without instance keys. A component's instances must either all be keyed or all be unkeyed, so update the mappings to be consistent.", component)))
+	}
+	return len(mixed) == 0
+}
+
+// verifyMergePolicy checks, when [ConflictOnExistingComponents] is in
+// effect, that none of the mappings direct resources into a component
+// instance that the existing stack state already tracks. It emits an error
+// diagnostic for each such component instance and returns true if migration
+// can proceed.
+func (m *migration) verifyMergePolicy(resources map[string]string, modules map[string]string) bool {
+	if m.ExistingState == nil || m.MergePolicy != ConflictOnExistingComponents {
+		return true
+	}
+
+	existing := collections.NewSet[Instance]()
+	for _, resource := range m.stateResources() {
+		if resource.Addr.Resource.Mode != addrs.ManagedResourceMode {
+			continue
+		}
+		if len(m.includedInstances(resource)) == 0 {
+			continue
+		}
+		target, diags := m.search(resource.Addr, resources, modules)
+		if diags.HasErrors() || target == nil {
+			// Invalid and unmapped addresses are reported during the main
+			// migration pass; here we only care about mapped resources.
+			continue
+		}
+		existing.Add(target.AbsResource.Component)
+	}
+
+	var conflicting []Instance
+	for instance := range existing.All() {
+		if m.ExistingState.HasComponentInstance(instance) {
+			conflicting = append(conflicting, instance)
+		}
+	}
+	sort.Slice(conflicting, func(i, j int) bool {
+		return conflicting[i].String() < conflicting[j].String()
+	})
+	for _, instance := range conflicting {
+		m.emitDiag(tfdiags.Sourceless(tfdiags.Error, "Component instance already exists", fmt.Sprintf("The existing stack state already tracks component instance %s, but the resource or module mappings would migrate additional resources into it. Map these resources to a new component instance, or allow merging into existing component instances.", instance)))
+	}
+	return len(conflicting) == 0
+}
+
+// checkCancelled reports whether the migration's context has been cancelled.
+// The first time it notices a cancellation it emits a diagnostic describing
+// the interruption and halts the migration, so that no further changes or
+// diagnostics are emitted.
+func (m *migration) checkCancelled() bool {
+	if m.ctx == nil || m.ctx.Err() == nil {
+		return false
+	}
+	if !m.stopped {
+		m.emitDiag(tfdiags.Sourceless(tfdiags.Error, "Migration cancelled", fmt.Sprintf("The migration was interrupted before it could complete: %s. Any changes emitted before the interruption describe only a partial stack state.", m.ctx.Err())))
+		m.stopped = true
+	}
+	return true
+}
+
+// reportProgress delivers the current progress snapshot to the caller's
+// Progress callback, if one was provided.
+func (m *migration) reportProgress() {
+	if m.Progress != nil {
+		m.Progress(m.progress)
+	}
 }
 
 func (m *migration) stateResources() iter.Seq2[addrs.AbsResource, *states.Resource] {
@@ -84,6 +1088,23 @@ func (m *migration) stateResources() iter.Seq2[addrs.AbsResource, *states.Resour
 	}
 }
 
+// includedInstances returns the instances of the given resource that pass the
+// configured Filter, or all of them when no Filter is set. Resources whose
+// instances are all filtered out are skipped entirely by every phase of the
+// migration.
+func (m *migration) includedInstances(resource *states.Resource) map[addrs.InstanceKey]*states.ResourceInstance {
+	if m.Filter == nil {
+		return resource.Instances
+	}
+	ret := make(map[addrs.InstanceKey]*states.ResourceInstance, len(resource.Instances))
+	for key, instance := range resource.Instances {
+		if m.Filter(resource.Addr.Instance(key)) {
+			ret[key] = instance
+		}
+	}
+	return ret
+}
+
 // moduleConfig returns the module configuration for the component. If the configuration
 // has already been loaded, it will be returned from the cache.
 func (m *migration) moduleConfig(component *stackconfig.Component) (*configs.Config, tfdiags.Diagnostics) {
@@ -104,6 +1125,47 @@ func (m *migration) moduleConfig(component *stackconfig.Component) (*configs.Con
 	return moduleConfig, diags
 }
 
+// deduplicateDiagnostics wraps the given diagnostic callback so that
+// diagnostics sharing a severity and summary are collapsed into a single
+// diagnostic that reports how many times the problem occurred, keeping the
+// first occurrence as the sample. The returned flush function must be called
+// once the migration is finished; it emits the collected diagnostics in
+// first-seen order.
+func deduplicateDiagnostics(emitDiag func(tfdiags.Diagnostic)) (func(tfdiags.Diagnostic), func()) {
+	type group struct {
+		first tfdiags.Diagnostic
+		count int
+	}
+	var order []string
+	groups := make(map[string]*group)
+
+	wrapped := func(diagnostic tfdiags.Diagnostic) {
+		key := fmt.Sprintf("%d:%s", diagnostic.Severity(), diagnostic.Description().Summary)
+		if g, ok := groups[key]; ok {
+			g.count++
+			return
+		}
+		groups[key] = &group{first: diagnostic, count: 1}
+		order = append(order, key)
+	}
+	flush := func() {
+		for _, key := range order {
+			g := groups[key]
+			if g.count == 1 {
+				emitDiag(g.first)
+				continue
+			}
+			desc := g.first.Description()
+			emitDiag(tfdiags.Sourceless(
+				g.first.Severity(),
+				desc.Summary,
+				fmt.Sprintf("%s\n\nThis problem occurred %d times during the migration; only the first occurrence is shown in full.", desc.Detail, g.count),
+			))
+		}
+	}
+	return wrapped, flush
+}
+
 func (m *migration) emitDiags(diags tfdiags.Diagnostics) {
 	for _, diag := range diags {
 		m.emitDiag(diag)
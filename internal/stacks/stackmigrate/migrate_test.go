@@ -5,7 +5,9 @@ package stackmigrate
 
 import (
 	stdcmp "cmp"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -18,9 +20,12 @@ import (
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/terraform/internal/addrs"
 	"github.com/hashicorp/terraform/internal/collections"
+	"github.com/hashicorp/terraform/internal/configs/configschema"
 	"github.com/hashicorp/terraform/internal/depsfile"
 	"github.com/hashicorp/terraform/internal/getproviders/providerreqs"
+	"github.com/hashicorp/terraform/internal/plans"
 	"github.com/hashicorp/terraform/internal/providers"
+	default_testing_provider "github.com/hashicorp/terraform/internal/providers/testing"
 	"github.com/hashicorp/terraform/internal/stacks/stackaddrs"
 	"github.com/hashicorp/terraform/internal/stacks/stackconfig"
 	"github.com/hashicorp/terraform/internal/stacks/stackplan"
@@ -141,6 +146,16 @@ func TestMigrate_Module(t *testing.T) {
 	}
 
 	var expDiags, gotDiags tfdiags.Diagnostics
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Resource has a pending replacement",
+		"Resource testing_resource.data has both a current and a deposed object, which usually means a create_before_destroy replacement was interrupted. Both objects were migrated, and the next plan and apply of the stack may complete the replacement by destroying the deposed object.",
+	))
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Output value not migrated",
+		"The previous state records output \"output\" for the root module, but the target component does not declare a matching output, so its value was not migrated.",
+	))
 	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
 		applied = append(applied, change)
 	}, func(diagnostic tfdiags.Diagnostic) {
@@ -153,7 +168,7 @@ func TestMigrate_Module(t *testing.T) {
 		t.Fatalf("unexpected applied changes:\n%s", diff)
 	}
 
-	if diff := cmp.Diff(expDiags, gotDiags); diff != "" {
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
 		t.Fatalf("unexpected diagnostics:\n%s", diff)
 	}
 }
@@ -213,8 +228,9 @@ func TestMigrate_RootResources(t *testing.T) {
 				return stacks_testing_provider.NewProvider(t), nil
 			},
 		},
-		PreviousState: state,
-		Config:        cfg,
+		PreviousState:   state,
+		Config:          cfg,
+		DependencyLocks: lock,
 	}
 	resources := map[string]string{
 		"testing_resource.data": "self",
@@ -267,7 +283,17 @@ func TestMigrate_RootResources(t *testing.T) {
 	}
 
 	var expDiags, gotDiags tfdiags.Diagnostics
-	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Resource has a pending replacement",
+		"Resource testing_resource.data has both a current and a deposed object, which usually means a create_before_destroy replacement was interrupted. Both objects were migrated, and the next plan and apply of the stack may complete the replacement by destroying the deposed object.",
+	))
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Output value not migrated",
+		"The previous state records output \"output\" for the root module, but the target component does not declare a matching output, so its value was not migrated.",
+	))
+	result := mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
 		applied = append(applied, change)
 	}, func(diagnostic tfdiags.Diagnostic) {
 		gotDiags = append(gotDiags, diagnostic)
@@ -279,27 +305,35 @@ func TestMigrate_RootResources(t *testing.T) {
 		t.Fatalf("unexpected applied changes:\n%s", diff)
 	}
 
-	if diff := cmp.Diff(expDiags, gotDiags); diff != "" {
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
 		t.Fatalf("unexpected diagnostics:\n%s", diff)
 	}
-}
 
-func TestMigrate_ComponentDependency(t *testing.T) {
-	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-dependency", "input-dependency"))
+	if got, want := result.ResourceInstanceObjects, 2; got != want {
+		t.Errorf("wrong ResourceInstanceObjects in result: got %d, want %d", got, want)
+	}
+	if got, want := result.DeposedObjects, 1; got != want {
+		t.Errorf("wrong DeposedObjects in result: got %d, want %d", got, want)
+	}
+	if got, want := result.Components, 1; got != want {
+		t.Errorf("wrong Components in result: got %d, want %d", got, want)
+	}
+	if got, want := result.Providers.Len(), 1; got != want {
+		t.Errorf("wrong number of Providers in result: got %d, want %d", got, want)
+	}
+	if !result.Providers.Has(addrs.NewDefaultProvider("testing")) {
+		t.Errorf("result.Providers does not include the testing provider")
+	}
+}
 
-	lock := depsfile.NewLocks()
-	lock.SetProvider(
-		addrs.NewDefaultProvider("testing"),
-		providerreqs.MustParseVersion("0.0.0"),
-		providerreqs.MustParseVersionConstraints("=0.0.0"),
-		providerreqs.PreferredHashes([]providerreqs.Hash{}),
-	)
+func TestMigrate_OutputValuesCarried(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
 
 	state := states.BuildState(func(ss *states.SyncState) {
 		ss.SetOutputValue(addrs.AbsOutputValue{
 			Module:      addrs.RootModuleInstance,
-			OutputValue: addrs.OutputValue{Name: "output"},
-		}, cty.StringVal("before"), false)
+			OutputValue: addrs.OutputValue{Name: "id"},
+		}, cty.StringVal("carried-id"), false)
 	})
 	rootModule := state.RootModule()
 	rootModule.SetResourceInstanceCurrent(
@@ -317,37 +351,6 @@ func TestMigrate_ComponentDependency(t *testing.T) {
 		},
 		mustDefaultRootProvider("testing"),
 	)
-	rootModule.SetResourceInstanceCurrent(
-		addrs.Resource{
-			Mode: addrs.ManagedResourceMode,
-			Type: "testing_resource",
-			Name: "another",
-		}.Instance(addrs.IntKey(0)),
-		&states.ResourceInstanceObjectSrc{
-			Status: states.ObjectReady,
-			AttrsJSON: []byte(`{
-				"id": "foo",
-				"value": "hello"
-			}`),
-		},
-		mustDefaultRootProvider("testing"),
-	)
-	rootModule.SetResourceInstanceCurrent(
-		addrs.Resource{
-			Mode: addrs.ManagedResourceMode,
-			Type: "testing_resource",
-			Name: "another",
-		}.Instance(addrs.IntKey(1)),
-		&states.ResourceInstanceObjectSrc{
-			Status: states.ObjectReady,
-			AttrsJSON: []byte(`{
-				"id": "foo",
-				"value": "hello"
-			}`),
-		},
-		mustDefaultRootProvider("testing"),
-	)
-
 	mig := Migration{
 		Providers: map[addrs.Provider]providers.Factory{
 			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
@@ -358,127 +361,112 @@ func TestMigrate_ComponentDependency(t *testing.T) {
 		Config:        cfg,
 	}
 	resources := map[string]string{
-		"testing_resource.data":    "parent",
-		"testing_resource.another": "child",
+		"testing_resource.data": "parent",
 	}
 	modules := map[string]string{}
 
-	appliedResources := []*stackstate.AppliedChangeResourceInstanceObject{}
 	appliedComponents := []*stackstate.AppliedChangeComponentInstance{}
-	expectedResources := []*stackstate.AppliedChangeResourceInstanceObject{
-		{
-			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.parent.testing_resource.data"),
-			NewStateSrc: &states.ResourceInstanceObjectSrc{
-				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
-					"id":    "foo",
-					"value": "hello",
-				}),
-				Status:  states.ObjectReady,
-				Private: nil,
-			},
-			ProviderConfigAddr: mustDefaultRootProvider("testing"),
-			Schema:             stacks_testing_provider.TestingResourceSchema,
-		},
-		{
-			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.child.testing_resource.another[0]"),
-			NewStateSrc: &states.ResourceInstanceObjectSrc{
-				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
-					"id":    "foo",
-					"value": "hello",
-				}),
-				Status:  states.ObjectReady,
-				Private: nil,
-			},
-			ProviderConfigAddr: mustDefaultRootProvider("testing"),
-			Schema:             stacks_testing_provider.TestingResourceSchema,
-		},
-		{
-			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.child.testing_resource.another[1]"),
-			NewStateSrc: &states.ResourceInstanceObjectSrc{
-				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
-					"id":    "foo",
-					"value": "hello",
-				}),
-				Status:  states.ObjectReady,
-				Private: nil,
-			},
-			ProviderConfigAddr: mustDefaultRootProvider("testing"),
-			Schema:             stacks_testing_provider.TestingResourceSchema,
-		},
-	}
 	expectedComponents := []*stackstate.AppliedChangeComponentInstance{
 		{
 			ComponentAddr:         mustAbsComponent("component.parent"),
 			ComponentInstanceAddr: mustAbsComponentInstance("component.parent"),
 			OutputValues: map[addrs.OutputValue]cty.Value{
-				{Name: "id"}: cty.DynamicVal,
-			},
-			InputVariables: map[addrs.InputVariable]cty.Value{
-				{Name: "id"}:    cty.DynamicVal,
-				{Name: "input"}: cty.DynamicVal,
-			},
-			Dependents: collections.NewSet(mustAbsComponent("component.child")),
-		},
-		{
-			ComponentAddr:         mustAbsComponent("component.child"),
-			ComponentInstanceAddr: mustAbsComponentInstance("component.child"),
-			OutputValues: map[addrs.OutputValue]cty.Value{
-				{Name: "id"}: cty.DynamicVal,
+				// The root module declares output "id", so its known value
+				// from the previous state is carried over rather than left
+				// unknown.
+				{Name: "id"}: cty.StringVal("carried-id"),
 			},
 			InputVariables: map[addrs.InputVariable]cty.Value{
 				{Name: "id"}:    cty.DynamicVal,
 				{Name: "input"}: cty.DynamicVal,
 			},
-			Dependencies: collections.NewSet(mustAbsComponent("component.parent")),
 		},
 	}
 
 	var expDiags, gotDiags tfdiags.Diagnostics
 	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
-		switch c := change.(type) {
-		case *stackstate.AppliedChangeResourceInstanceObject:
-			appliedResources = append(appliedResources, c)
-		case *stackstate.AppliedChangeComponentInstance:
+		if c, ok := change.(*stackstate.AppliedChangeComponentInstance); ok {
 			appliedComponents = append(appliedComponents, c)
 		}
 	}, func(diagnostic tfdiags.Diagnostic) {
 		gotDiags = append(gotDiags, diagnostic)
 	})
 
-	if diff := compareAppliedChanges(t, expectedResources, appliedResources, func(c *stackstate.AppliedChangeResourceInstanceObject) string {
-		return c.ResourceInstanceObjectAddr.String()
-	}); diff != "" {
-		t.Fatalf("unexpected applied resource changes:\n%s", diff)
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
+		t.Fatalf("unexpected diagnostics:\n%s", diff)
 	}
 
 	if diff := compareAppliedChanges(t, expectedComponents, appliedComponents, func(c *stackstate.AppliedChangeComponentInstance) string {
 		return c.ComponentAddr.String()
 	}); diff != "" {
-		t.Fatalf("unexpected applied component changes:\n%s", diff)
-	}
-
-	if diff := cmp.Diff(expDiags, gotDiags); diff != "" {
-		t.Fatalf("unexpected diagnostics:\n%s", diff)
+		t.Errorf("unexpected applied component changes:\n%s", diff)
 	}
 }
 
-func TestMigrateConfig_NestedModuleResources(t *testing.T) {
+func TestMigrate_DuplicateComponentInstance(t *testing.T) {
 	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
 
-	lock := depsfile.NewLocks()
-	lock.SetProvider(
-		addrs.NewDefaultProvider("testing"),
-		providerreqs.MustParseVersion("0.0.0"),
-		providerreqs.MustParseVersionConstraints("=0.0.0"),
-		providerreqs.PreferredHashes([]providerreqs.Hash{}),
-	)
+	state := states.NewState()
+	for _, moduleName := range []string{"child_a", "child_b"} {
+		module := state.EnsureModule(addrs.RootModuleInstance.Child(moduleName, addrs.NoKey))
+		module.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "testing_resource",
+				Name: "child_data",
+			}.Instance(addrs.NoKey),
+			&states.ResourceInstanceObjectSrc{
+				Status: states.ObjectReady,
+				AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+			},
+			mustDefaultRootProvider("testing"),
+		)
+	}
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+	resources := map[string]string{}
+	// Both module mappings target the same component instance, which would
+	// silently merge two unrelated modules into one component.
+	modules := map[string]string{
+		"child_a": "child",
+		"child_b": "child",
+	}
 
-	state := states.BuildState(func(ss *states.SyncState) {
-		ss.SetOutputValue(addrs.AbsOutputValue{
-			Module:      addrs.RootModuleInstance,
-			OutputValue: addrs.OutputValue{Name: "output"},
-		}, cty.StringVal("before"), false)
+	applied := []stackstate.AppliedChange{}
+	var expDiags, gotDiags tfdiags.Diagnostics
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Error,
+		"Duplicate component instance",
+		"Resources from multiple source modules (module.child_a, module.child_b) would be migrated into the same component instance component.child. Each component instance can receive resources from only one module, so update the resource or module mappings to give these modules distinct component instances.",
+	))
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		applied = append(applied, change)
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
 	})
+
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
+		t.Fatalf("unexpected diagnostics:\n%s", diff)
+	}
+	if len(applied) != 0 {
+		t.Errorf("expected no applied changes, got %d", len(applied))
+	}
+}
+
+func TestMigrate_MissingProviderLock(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
 	rootModule := state.RootModule()
 	rootModule.SetResourceInstanceCurrent(
 		addrs.Resource{
@@ -495,12 +483,57 @@ func TestMigrateConfig_NestedModuleResources(t *testing.T) {
 		},
 		mustDefaultRootProvider("testing"),
 	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+		// The lock file doesn't record the testing provider, so the
+		// migration should refuse to start.
+		DependencyLocks: depsfile.NewLocks(),
+	}
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	var applied []stackstate.AppliedChange
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		applied = append(applied, change)
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if len(applied) != 0 {
+		t.Errorf("expected no applied changes, got %d", len(applied))
+	}
+
+	var expDiags tfdiags.Diagnostics
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Error,
+		"Provider not locked",
+		"Provider hashicorp/testing is required by resources in the previous state but is not recorded in the dependency lock file. Run \"terraform init\" to install the missing providers before migrating.",
+	))
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
+		t.Errorf("unexpected diagnostics:\n%s", diff)
+	}
+}
+
+func TestMigrate_ProviderConfigRemap(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
 	rootModule.SetResourceInstanceCurrent(
 		addrs.Resource{
 			Mode: addrs.ManagedResourceMode,
 			Type: "testing_resource",
-			Name: "another",
-		}.Instance(addrs.IntKey(0)),
+			Name: "data",
+		}.Instance(addrs.NoKey),
 		&states.ResourceInstanceObjectSrc{
 			Status: states.ObjectReady,
 			AttrsJSON: []byte(`{
@@ -510,14 +543,60 @@ func TestMigrateConfig_NestedModuleResources(t *testing.T) {
 		},
 		mustDefaultRootProvider("testing"),
 	)
+
+	remapped := mustDefaultRootProvider("testing")
+	remapped.Alias = "backup"
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+		ProviderConfigRemap: addrs.MakeMap(
+			addrs.MakeMapElem(mustDefaultRootProvider("testing"), remapped),
+		),
+	}
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	var appliedResources []*stackstate.AppliedChangeResourceInstanceObject
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			appliedResources = append(appliedResources, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if len(gotDiags) != 0 {
+		t.Fatalf("unexpected diagnostics: %s", gotDiags.Err())
+	}
+	if len(appliedResources) != 1 {
+		t.Fatalf("expected 1 applied resource change, got %d", len(appliedResources))
+	}
+	if got, want := appliedResources[0].ProviderConfigAddr, remapped; !got.Equal(want) {
+		t.Errorf("wrong ProviderConfigAddr: got %s, want %s", got, want)
+	}
+}
+
+func TestMigrate_TaintedObjects(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
 	rootModule.SetResourceInstanceCurrent(
 		addrs.Resource{
 			Mode: addrs.ManagedResourceMode,
 			Type: "testing_resource",
-			Name: "another",
-		}.Instance(addrs.IntKey(1)),
+			Name: "data",
+		}.Instance(addrs.NoKey),
 		&states.ResourceInstanceObjectSrc{
-			Status: states.ObjectReady,
+			Status: states.ObjectTainted,
 			AttrsJSON: []byte(`{
 				"id": "foo",
 				"value": "hello"
@@ -525,29 +604,3600 @@ func TestMigrateConfig_NestedModuleResources(t *testing.T) {
 		},
 		mustDefaultRootProvider("testing"),
 	)
-
-	childModule := state.EnsureModule(addrs.RootModuleInstance.Child("child_mod", addrs.NoKey))
-	childModule.SetResourceInstanceCurrent(
+	rootModule.SetResourceInstanceDeposed(
 		addrs.Resource{
 			Mode: addrs.ManagedResourceMode,
 			Type: "testing_resource",
-			Name: "child_data",
+			Name: "data",
 		}.Instance(addrs.NoKey),
+		states.NewDeposedKey(),
 		&states.ResourceInstanceObjectSrc{
 			Status: states.ObjectReady,
 			AttrsJSON: []byte(`{
-				"id": "foo",
-				"value": "hello"
+				"id": "old",
+				"value": "old"
 			}`),
 		},
 		mustDefaultRootProvider("testing"),
 	)
-	childModule.SetResourceInstanceCurrent(
-		addrs.Resource{
-			Mode: addrs.ManagedResourceMode,
-			Type: "testing_resource",
-			Name: "another_child_data",
-		}.Instance(addrs.IntKey(0)),
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	var gotDiags tfdiags.Diagnostics
+	gotStatus := make(map[string]states.ObjectStatus)
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		if change, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			key := "current"
+			if change.ResourceInstanceObjectAddr.Item.DeposedKey != addrs.NotDeposed {
+				key = "deposed"
+			}
+			gotStatus[key] = change.NewStateSrc.Status
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if len(gotDiags) != 1 || gotDiags[0].Description().Summary != "Resource has a pending replacement" {
+		t.Fatalf("expected only the pending replacement advisory, got: %s", gotDiags.Err())
+	}
+
+	// The tainted current object must stay tainted (mapped to DAMAGED in the
+	// stack state encoding) rather than being promoted to ready, and the
+	// ready deposed object must stay ready.
+	if got, want := gotStatus["current"], states.ObjectTainted; got != want {
+		t.Errorf("wrong status for current object: got %s, want %s", got, want)
+	}
+	if got, want := gotStatus["deposed"], states.ObjectReady; got != want {
+		t.Errorf("wrong status for deposed object: got %s, want %s", got, want)
+	}
+}
+
+func TestMigrate_MissingResourceTypeSchema(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				// A provider that no longer declares the testing_resource
+				// type, as if the type had been removed or renamed in a
+				// newer provider release.
+				return &default_testing_provider.MockProvider{
+					GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+						Provider: providers.Schema{Body: &configschema.Block{}},
+					},
+				}, nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	var gotDiags tfdiags.Diagnostics
+	result := mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		if _, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			t.Errorf("unexpected resource instance change for %v", change)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if got, want := result.ResourceInstanceObjects, 0; got != want {
+		t.Errorf("wrong ResourceInstanceObjects in result: got %d, want %d", got, want)
+	}
+	if len(gotDiags) != 1 {
+		t.Fatalf("expected exactly one diagnostic, got: %v", gotDiags)
+	}
+	if got, want := gotDiags[0].Severity(), tfdiags.Error; got != want {
+		t.Errorf("wrong diagnostic severity: got %s, want %s", got, want)
+	}
+	if got, want := gotDiags[0].Description().Summary, "Resource type not found"; got != want {
+		t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+	}
+	detail := gotDiags[0].Description().Detail
+	if !strings.Contains(detail, "testing_resource.data") || !strings.Contains(detail, "hashicorp/testing") {
+		t.Errorf("diagnostic detail does not identify the resource and provider: %s", detail)
+	}
+}
+
+func TestMigrate_StopOnError(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			// The provider doesn't know about any resource types, so the
+			// resource above fails to migrate. Without StopOnError the
+			// component instance would still be emitted afterwards.
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return &default_testing_provider.MockProvider{
+					GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+						Provider: providers.Schema{Body: &configschema.Block{}},
+					},
+				}, nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+		StopOnError:   true,
+	}
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	var applied []stackstate.AppliedChange
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		applied = append(applied, change)
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if len(gotDiags) != 1 {
+		t.Fatalf("expected exactly one diagnostic, got: %v", gotDiags)
+	}
+	if got, want := gotDiags[0].Description().Summary, "Resource type not found"; got != want {
+		t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+	}
+	if len(applied) != 0 {
+		t.Errorf("expected no applied changes after the error, got %d", len(applied))
+	}
+}
+
+func TestMigrate_IdentityUpgrade(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+			IdentityJSON:          []byte(`{"id":"foo"}`),
+			IdentitySchemaVersion: 1,
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	// The provider's identity schema is at version 2, so the version 1
+	// identity in the prior state must be upgraded on the way through.
+	provider := &default_testing_provider.MockProvider{
+		GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+			Provider: providers.Schema{Body: &configschema.Block{}},
+			ResourceTypes: map[string]providers.Schema{
+				"testing_resource": {
+					Body: stacks_testing_provider.TestingResourceSchema.Body,
+					Identity: &configschema.Object{
+						Attributes: map[string]*configschema.Attribute{
+							"id": {Type: cty.String, Required: true},
+						},
+						Nesting: configschema.NestingSingle,
+					},
+					IdentityVersion: 2,
+				},
+			},
+		},
+		UpgradeResourceIdentityResponse: &providers.UpgradeResourceIdentityResponse{
+			UpgradedIdentity: cty.ObjectVal(map[string]cty.Value{
+				"id": cty.StringVal("upgraded-foo"),
+			}),
+		},
+	}
+	provider.ConfigureProviderCalled = true
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return provider, nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	var appliedResources []*stackstate.AppliedChangeResourceInstanceObject
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			appliedResources = append(appliedResources, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if len(gotDiags) != 0 {
+		t.Fatalf("unexpected diagnostics: %s", gotDiags.Err())
+	}
+	if !provider.UpgradeResourceIdentityCalled {
+		t.Fatal("expected UpgradeResourceIdentity to be called")
+	}
+	if len(appliedResources) != 1 {
+		t.Fatalf("expected 1 applied resource change, got %d", len(appliedResources))
+	}
+
+	src := appliedResources[0].NewStateSrc
+	if got, want := string(src.IdentityJSON), `{"id":"upgraded-foo"}`; got != want {
+		t.Errorf("wrong IdentityJSON after upgrade: got %s, want %s", got, want)
+	}
+	if got, want := src.IdentitySchemaVersion, uint64(2); got != want {
+		t.Errorf("wrong IdentitySchemaVersion after upgrade: got %d, want %d", got, want)
+	}
+}
+
+func TestMigrate_IdentityMismatch(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	// The provider's identity schema expects exactly "id" and "region", at
+	// the same version as the stored identities, so any structural mismatch
+	// comes from the data rather than from a version skew.
+	newProvider := func() providers.Interface {
+		provider := &default_testing_provider.MockProvider{
+			GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+				Provider: providers.Schema{Body: &configschema.Block{}},
+				ResourceTypes: map[string]providers.Schema{
+					"testing_resource": {
+						Body: stacks_testing_provider.TestingResourceSchema.Body,
+						Identity: &configschema.Object{
+							Attributes: map[string]*configschema.Attribute{
+								"id":     {Type: cty.String, Required: true},
+								"region": {Type: cty.String, Required: true},
+							},
+							Nesting: configschema.NestingSingle,
+						},
+						IdentityVersion: 1,
+					},
+				},
+			},
+		}
+		provider.ConfigureProviderCalled = true
+		return provider
+	}
+
+	tests := map[string]struct {
+		identityJSON string
+		wantDetail   string
+	}{
+		"extra attribute": {
+			identityJSON: `{"id":"foo","region":"eu","stale":"x"}`,
+			wantDetail:   `contains attribute "stale"`,
+		},
+		"missing attribute": {
+			identityJSON: `{"id":"foo"}`,
+			wantDetail:   `missing attribute "region"`,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			state := states.NewState()
+			rootModule := state.RootModule()
+			rootModule.SetResourceInstanceCurrent(
+				addrs.Resource{
+					Mode: addrs.ManagedResourceMode,
+					Type: "testing_resource",
+					Name: "data",
+				}.Instance(addrs.NoKey),
+				&states.ResourceInstanceObjectSrc{
+					Status: states.ObjectReady,
+					AttrsJSON: []byte(`{
+						"id": "foo",
+						"value": "hello"
+					}`),
+					IdentityJSON:          []byte(test.identityJSON),
+					IdentitySchemaVersion: 1,
+				},
+				mustDefaultRootProvider("testing"),
+			)
+
+			mig := Migration{
+				Providers: map[addrs.Provider]providers.Factory{
+					addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+						return newProvider(), nil
+					},
+				},
+				PreviousState: state,
+				Config:        cfg,
+			}
+			resources := map[string]string{
+				"testing_resource.data": "self",
+			}
+
+			var appliedResources []*stackstate.AppliedChangeResourceInstanceObject
+			var gotDiags tfdiags.Diagnostics
+			mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+				if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+					appliedResources = append(appliedResources, c)
+				}
+			}, func(diagnostic tfdiags.Diagnostic) {
+				gotDiags = append(gotDiags, diagnostic)
+			})
+
+			if len(appliedResources) != 0 {
+				t.Errorf("expected no applied resource changes, got %d", len(appliedResources))
+			}
+			if len(gotDiags) != 1 {
+				t.Fatalf("expected 1 diagnostic, got %d: %s", len(gotDiags), gotDiags.Err())
+			}
+			if got, want := gotDiags[0].Description().Summary, "Invalid resource identity"; got != want {
+				t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+			}
+			if got := gotDiags[0].Description().Detail; !strings.Contains(got, test.wantDetail) {
+				t.Errorf("diagnostic detail does not contain %q: %s", test.wantDetail, got)
+			}
+		})
+	}
+}
+
+func TestMigrate_CreateBeforeDestroy(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+			CreateBeforeDestroy: true,
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	// Deposed objects only exist because of create_before_destroy
+	// replacements, so this one carries the flag too.
+	deposedKey := states.NewDeposedKey()
+	rootModule.SetResourceInstanceDeposed(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		deposedKey,
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "old",
+				"value": "old"
+			}`),
+			CreateBeforeDestroy: true,
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	var appliedResources []*stackstate.AppliedChangeResourceInstanceObject
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			appliedResources = append(appliedResources, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if len(gotDiags) != 1 || gotDiags[0].Description().Summary != "Resource has a pending replacement" {
+		t.Fatalf("expected only the pending replacement advisory, got: %s", gotDiags.Err())
+	}
+	if len(appliedResources) != 2 {
+		t.Fatalf("expected 2 applied resource changes, got %d", len(appliedResources))
+	}
+	for _, applied := range appliedResources {
+		if !applied.NewStateSrc.CreateBeforeDestroy {
+			t.Errorf("CreateBeforeDestroy flag was lost for %s", applied.ResourceInstanceObjectAddr)
+		}
+	}
+}
+
+func TestMigrate_DeposedKeyPreserved(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	deposedKey := states.DeposedKey("deadbeef")
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceDeposed(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		deposedKey,
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+	modules := map[string]string{}
+
+	migrate := func(regenerate bool) addrs.DeposedKey {
+		mig := Migration{
+			Providers: map[addrs.Provider]providers.Factory{
+				addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+					return stacks_testing_provider.NewProvider(t), nil
+				},
+			},
+			PreviousState:         state,
+			Config:                cfg,
+			RegenerateDeposedKeys: regenerate,
+		}
+
+		var deposed []*stackstate.AppliedChangeResourceInstanceObject
+		mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+			if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+				if c.ResourceInstanceObjectAddr.Item.DeposedKey != addrs.NotDeposed {
+					deposed = append(deposed, c)
+				}
+			}
+		}, func(diagnostic tfdiags.Diagnostic) {
+			t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+		})
+
+		if len(deposed) != 1 {
+			t.Fatalf("expected 1 deposed object, got %d", len(deposed))
+		}
+		return deposed[0].ResourceInstanceObjectAddr.Item.DeposedKey
+	}
+
+	if got := migrate(false); got != addrs.DeposedKey(deposedKey) {
+		t.Errorf("deposed key not preserved: got %s, want %s", got, deposedKey)
+	}
+	if got := migrate(true); got == addrs.DeposedKey(deposedKey) {
+		t.Errorf("deposed key was not regenerated: got %s", got)
+	}
+}
+
+func TestMigrate_DropDeposed(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	rootModule.SetResourceInstanceDeposed(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		states.DeposedKey("deadbeef"),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"bar","value":"old"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+		DropDeposed:   true,
+	}
+
+	var resourceChanges []*stackstate.AppliedChangeResourceInstanceObject
+	var gotDiags tfdiags.Diagnostics
+	result := mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			resourceChanges = append(resourceChanges, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if len(resourceChanges) != 1 {
+		t.Fatalf("expected 1 resource change, got %d", len(resourceChanges))
+	}
+	if key := resourceChanges[0].ResourceInstanceObjectAddr.Item.DeposedKey; key != addrs.NotDeposed {
+		t.Errorf("deposed object was migrated despite DropDeposed: %s", key)
+	}
+	if result.DeposedObjects != 0 {
+		t.Errorf("result reports %d deposed objects, want 0", result.DeposedObjects)
+	}
+
+	if len(gotDiags) != 1 {
+		t.Fatalf("expected exactly one diagnostic, got: %v", gotDiags)
+	}
+	if got, want := gotDiags[0].Description().Summary, "Deposed objects dropped"; got != want {
+		t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+	}
+	if detail := gotDiags[0].Description().Detail; !strings.Contains(detail, "testing_resource.data (1)") {
+		t.Errorf("diagnostic detail does not count the dropped objects: %s", detail)
+	}
+}
+
+func TestMigrate_PendingReplacementAdvisory(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+
+	// This resource was mid-replacement when the previous run was
+	// interrupted, so it has both a current and a deposed object.
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	rootModule.SetResourceInstanceDeposed(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		states.DeposedKey("deadbeef"),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"bar","value":"old"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	// This one is clean and must not produce the advisory.
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "another",
+		}.Instance(addrs.IntKey(0)),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"baz","value":"fine"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	resources := map[string]string{
+		"testing_resource.data":    "parent",
+		"testing_resource.another": "parent",
+	}
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if len(gotDiags) != 1 {
+		t.Fatalf("expected exactly one diagnostic, got: %v", gotDiags)
+	}
+	if got, want := gotDiags[0].Description().Summary, "Resource has a pending replacement"; got != want {
+		t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+	}
+	if detail := gotDiags[0].Description().Detail; !strings.Contains(detail, "testing_resource.data") {
+		t.Errorf("diagnostic detail does not name the resource: %s", detail)
+	}
+}
+
+func TestMigrate_ResourceTypeMapping(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "by-resource-type"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_deferred_resource",
+			Name: "deferred",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"bar","value":"world","deferred":false}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+
+	// The type mapping expands into one entry per root resource.
+	resources, diags := mig.ExpandResourceTypeMapping(map[string]string{
+		"testing_resource":          "compute",
+		"testing_deferred_resource": "storage",
+	})
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics from expansion: %v", diags)
+	}
+	wantResources := map[string]string{
+		"testing_resource.data":              "compute",
+		"testing_deferred_resource.deferred": "storage",
+	}
+	if diff := cmp.Diff(wantResources, resources); diff != "" {
+		t.Fatalf("wrong expanded mapping:\n%s", diff)
+	}
+
+	var resourceChanges []*stackstate.AppliedChangeResourceInstanceObject
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			resourceChanges = append(resourceChanges, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+	})
+
+	gotResources := make(map[string]bool)
+	for _, change := range resourceChanges {
+		gotResources[change.ResourceInstanceObjectAddr.String()] = true
+	}
+	for _, want := range []string{
+		"component.compute.testing_resource.data",
+		"component.storage.testing_deferred_resource.deferred",
+	} {
+		if !gotResources[want] {
+			t.Errorf("missing resource change for %s; got: %v", want, gotResources)
+		}
+	}
+
+	// A type mapping that leaves a root resource type uncovered is refused.
+	_, diags = mig.ExpandResourceTypeMapping(map[string]string{
+		"testing_resource": "compute",
+	})
+	if len(diags) != 1 {
+		t.Fatalf("expected exactly one diagnostic, got: %v", diags)
+	}
+	if got, want := diags[0].Description().Summary, "Resource type not mapped"; got != want {
+		t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+	}
+	if detail := diags[0].Description().Detail; !strings.Contains(detail, "testing_deferred_resource") {
+		t.Errorf("diagnostic detail does not name the uncovered type: %s", detail)
+	}
+}
+
+func TestMigrate_VerifyAgainstPlan(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+	mig.Migrate(map[string]string{"testing_resource.data": "self"}, nil, func(change stackstate.AppliedChange) {}, func(diagnostic tfdiags.Diagnostic) {
+		t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+	})
+
+	objectAddr := mustAbsResourceInstanceObject("component.self.testing_resource.data")
+
+	// A plan that tracks the migrated object with no changes verifies clean.
+	plan := &stackplan.Plan{
+		Components: collections.NewMap(collections.MapElem[stackaddrs.AbsComponentInstance, *stackplan.Component]{
+			K: objectAddr.Component,
+			V: &stackplan.Component{
+				ResourceInstancePriorState: addrs.MakeMap(
+					addrs.MakeMapElem[addrs.AbsResourceInstanceObject, *states.ResourceInstanceObjectSrc](objectAddr.Item, &states.ResourceInstanceObjectSrc{}),
+				),
+			},
+		}),
+	}
+	if diags := mig.VerifyAgainstPlan(plan); len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics from clean verification: %v", diags)
+	}
+
+	// A plan that proposes a change to the migrated object is suspicious.
+	plan.Components.Get(objectAddr.Component).ResourceInstancePlanned = addrs.MakeMap(
+		addrs.MakeMapElem[addrs.AbsResourceInstanceObject, *plans.ResourceInstanceChangeSrc](objectAddr.Item, &plans.ResourceInstanceChangeSrc{
+			ChangeSrc: plans.ChangeSrc{Action: plans.Update},
+		}),
+	)
+	gotDiags := mig.VerifyAgainstPlan(plan)
+	if len(gotDiags) != 1 {
+		t.Fatalf("expected exactly one diagnostic, got: %v", gotDiags)
+	}
+	if got, want := gotDiags[0].Description().Summary, "Unexpected planned change"; got != want {
+		t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+	}
+	if got, want := gotDiags[0].Severity(), tfdiags.Warning; got != want {
+		t.Errorf("wrong diagnostic severity: got %s, want %s", got, want)
+	}
+
+	// A plan that doesn't track the object at all is an error.
+	plan.Components.Get(objectAddr.Component).ResourceInstancePriorState = addrs.MakeMap[addrs.AbsResourceInstanceObject, *states.ResourceInstanceObjectSrc]()
+	gotDiags = mig.VerifyAgainstPlan(plan)
+	if len(gotDiags) != 1 || gotDiags[0].Description().Summary != "Migrated resource missing from plan" {
+		t.Fatalf("expected a single \"Migrated resource missing from plan\" diagnostic, got: %v", gotDiags)
+	}
+
+	// And so is a plan that doesn't include the component instance.
+	gotDiags = mig.VerifyAgainstPlan(&stackplan.Plan{
+		Components: collections.NewMap[stackaddrs.AbsComponentInstance, *stackplan.Component](),
+	})
+	if len(gotDiags) != 1 || gotDiags[0].Description().Summary != "Component instance missing from plan" {
+		t.Fatalf("expected a single \"Component instance missing from plan\" diagnostic, got: %v", gotDiags)
+	}
+}
+
+func TestMigrate_StackVariableWiring(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "output-wiring"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	for _, name := range []string{"data", "second"} {
+		rootModule.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "testing_resource",
+				Name: name,
+			}.Instance(addrs.NoKey),
+			&states.ResourceInstanceObjectSrc{
+				Status:    states.ObjectReady,
+				AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+			},
+			mustDefaultRootProvider("testing"),
+		)
+	}
+
+	// component.self in stack.consumer references only var.input, which the
+	// stack call wires to component.producer.id, so the dependency edge must
+	// be inferred through the wiring.
+	resources := map[string]string{
+		"testing_resource.data":   "producer",
+		"testing_resource.second": "stack.consumer.component.self",
+	}
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+
+	componentChanges := make(map[string]*stackstate.AppliedChangeComponentInstance)
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeComponentInstance); ok {
+			componentChanges[c.ComponentInstanceAddr.String()] = c
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+	})
+
+	consumer, ok := componentChanges[`stack.consumer.component.self`]
+	if !ok {
+		t.Fatalf("missing component change for stack.consumer.component.self; got: %v", componentChanges)
+	}
+	if !consumer.Dependencies.Has(mustAbsComponent("component.producer")) {
+		t.Errorf("stack.consumer.component.self does not depend on component.producer: %v", consumer.Dependencies)
+	}
+
+	producer, ok := componentChanges[`component.producer`]
+	if !ok {
+		t.Fatalf("missing component change for component.producer; got: %v", componentChanges)
+	}
+	if !producer.Dependents.Has(mustAbsComponent("stack.consumer.component.self")) {
+		t.Errorf("component.producer does not list stack.consumer.component.self as a dependent: %v", producer.Dependents)
+	}
+	if producer.Dependencies.Len() != 0 {
+		t.Errorf("component.producer should have no dependencies, got: %v", producer.Dependencies)
+	}
+}
+
+func TestMigrate_KeyedComponentInstances(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "another",
+		}.Instance(addrs.IntKey(0)),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"bar","value":"world"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	// The mapping values carry explicit instance keys, routing each resource
+	// to a specific keyed instance of the same component.
+	resources := map[string]string{
+		"testing_resource.data":    `parent["a"]`,
+		"testing_resource.another": `parent["b"]`,
+	}
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+
+	var resourceChanges []*stackstate.AppliedChangeResourceInstanceObject
+	var componentChanges []*stackstate.AppliedChangeComponentInstance
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		switch c := change.(type) {
+		case *stackstate.AppliedChangeResourceInstanceObject:
+			resourceChanges = append(resourceChanges, c)
+		case *stackstate.AppliedChangeComponentInstance:
+			componentChanges = append(componentChanges, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+	})
+
+	gotResources := make(map[string]bool)
+	for _, change := range resourceChanges {
+		gotResources[change.ResourceInstanceObjectAddr.String()] = true
+	}
+	for _, want := range []string{
+		`component.parent["a"].testing_resource.data`,
+		`component.parent["b"].testing_resource.another[0]`,
+	} {
+		if !gotResources[want] {
+			t.Errorf("missing resource change for %s; got: %v", want, gotResources)
+		}
+	}
+	if len(resourceChanges) != 2 {
+		t.Errorf("expected 2 resource changes, got %d", len(resourceChanges))
+	}
+
+	gotComponents := make(map[string]bool)
+	for _, change := range componentChanges {
+		gotComponents[change.ComponentInstanceAddr.String()] = true
+	}
+	for _, want := range []string{
+		`component.parent["a"]`,
+		`component.parent["b"]`,
+	} {
+		if !gotComponents[want] {
+			t.Errorf("missing component change for %s; got: %v", want, gotComponents)
+		}
+	}
+
+	// Mixing keyed and unkeyed instances of the same component is refused.
+	mig2 := mig
+	var gotDiags tfdiags.Diagnostics
+	mig2.Migrate(map[string]string{
+		"testing_resource.data":    "parent",
+		"testing_resource.another": `parent["b"]`,
+	}, nil, func(change stackstate.AppliedChange) {
+		t.Errorf("unexpected change: %#v", change)
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+	if len(gotDiags) != 1 {
+		t.Fatalf("expected exactly one diagnostic, got: %v", gotDiags)
+	}
+	if got, want := gotDiags[0].Description().Summary, "Inconsistent component instance keys"; got != want {
+		t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+	}
+	if detail := gotDiags[0].Description().Detail; !strings.Contains(detail, "component.parent") {
+		t.Errorf("diagnostic detail does not name the component: %s", detail)
+	}
+}
+
+func TestMigrate_SchemaVersionDowngrade(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+
+	// The stored schema version is newer than anything the testing provider
+	// supports, as if the state had been written by a newer provider release.
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:        states.ObjectReady,
+			SchemaVersion: 5,
+			AttrsJSON:     []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+
+	var resourceChanges []*stackstate.AppliedChangeResourceInstanceObject
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(map[string]string{"testing_resource.data": "self"}, nil, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			resourceChanges = append(resourceChanges, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if len(gotDiags) != 1 {
+		t.Fatalf("expected exactly one diagnostic, got: %v", gotDiags)
+	}
+	if got, want := gotDiags[0].Description().Summary, "Resource schema version is newer than provider"; got != want {
+		t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+	}
+	if got, want := gotDiags[0].Severity(), tfdiags.Warning; got != want {
+		t.Errorf("wrong diagnostic severity: got %s, want %s", got, want)
+	}
+
+	// The object is still migrated, with its stored schema version intact.
+	if len(resourceChanges) != 1 {
+		t.Fatalf("expected 1 resource change, got %d", len(resourceChanges))
+	}
+	if got := resourceChanges[0].NewStateSrc.SchemaVersion; got != 5 {
+		t.Errorf("wrong schema version on migrated object: got %d, want 5", got)
+	}
+}
+
+func TestMigrate_MigrateResult(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+
+	// A clean migration returns a nil error.
+	result, err := mig.MigrateResult(map[string]string{"testing_resource.data": "self"}, nil, func(change stackstate.AppliedChange) {}, func(diagnostic tfdiags.Diagnostic) {
+		t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result.ResourceInstanceObjects != 1 {
+		t.Errorf("expected 1 migrated object, got %d", result.ResourceInstanceObjects)
+	}
+
+	// A mapping to a component that doesn't exist fails, and the failure is
+	// also available as a structured error. The streaming callback still
+	// receives the diagnostic.
+	var streamed tfdiags.Diagnostics
+	_, err = mig.MigrateResult(map[string]string{"testing_resource.data": "nonexistent"}, nil, func(change stackstate.AppliedChange) {}, func(diagnostic tfdiags.Diagnostic) {
+		streamed = append(streamed, diagnostic)
+	})
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+	var migErr *MigrationError
+	if !errors.As(err, &migErr) {
+		t.Fatalf("expected a *MigrationError, got %T: %s", err, err)
+	}
+	if len(migErr.Failures) == 0 {
+		t.Fatal("expected at least one failure in the error")
+	}
+	if migErr.Failures[0].Summary == "" {
+		t.Error("failure has an empty summary")
+	}
+	errorCount := 0
+	for _, diag := range streamed {
+		if diag.Severity() == tfdiags.Error {
+			errorCount++
+		}
+	}
+	if errorCount != len(migErr.Failures) {
+		t.Errorf("error contains %d failures, but %d error diagnostics were streamed", len(migErr.Failures), errorCount)
+	}
+}
+
+func TestMigrate_ComponentsOnly(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	childModule := state.EnsureModule(addrs.RootModuleInstance.Child("child_mod", addrs.NoKey))
+	childModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "child_data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"bar","value":"world"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	resources := map[string]string{"testing_resource.data": "parent"}
+	modules := map[string]string{"child_mod": "child"}
+
+	newMigration := func() Migration {
+		return Migration{
+			Providers: map[addrs.Provider]providers.Factory{
+				addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+					return stacks_testing_provider.NewProvider(t), nil
+				},
+			},
+			PreviousState: state,
+			Config:        cfg,
+		}
+	}
+
+	run := func(mig Migration) (map[string]*stackstate.AppliedChangeComponentInstance, int) {
+		t.Helper()
+		components := make(map[string]*stackstate.AppliedChangeComponentInstance)
+		resourceChanges := 0
+		mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+			switch c := change.(type) {
+			case *stackstate.AppliedChangeComponentInstance:
+				components[c.ComponentInstanceAddr.String()] = c
+			case *stackstate.AppliedChangeResourceInstanceObject:
+				resourceChanges++
+			}
+		}, func(diagnostic tfdiags.Diagnostic) {
+			t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+		})
+		return components, resourceChanges
+	}
+
+	fullComponents, fullResources := run(newMigration())
+	if fullResources == 0 {
+		t.Fatal("full migration emitted no resource changes")
+	}
+
+	skeleton := newMigration()
+	skeleton.ComponentsOnly = true
+	onlyComponents, onlyResources := run(skeleton)
+
+	if onlyResources != 0 {
+		t.Errorf("components-only migration emitted %d resource changes, want 0", onlyResources)
+	}
+	if len(onlyComponents) != len(fullComponents) {
+		t.Fatalf("components-only migration emitted %d components, full migration emitted %d", len(onlyComponents), len(fullComponents))
+	}
+	for addr, want := range fullComponents {
+		got, ok := onlyComponents[addr]
+		if !ok {
+			t.Errorf("components-only migration is missing %s", addr)
+			continue
+		}
+		if diff := cmp.Diff(want.Dependencies, got.Dependencies, collections.CmpOptions); diff != "" {
+			t.Errorf("wrong dependencies for %s:\n%s", addr, diff)
+		}
+		if diff := cmp.Diff(want.Dependents, got.Dependents, collections.CmpOptions); diff != "" {
+			t.Errorf("wrong dependents for %s:\n%s", addr, diff)
+		}
+	}
+}
+
+func TestMigrate_ObjectDependencies(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
+
+	childDataAddr := addrs.ConfigResource{
+		Module: addrs.Module{"child_mod"},
+		Resource: addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "child_data",
+		},
+	}
+	anotherAddr := addrs.ConfigResource{
+		Module: addrs.RootModule,
+		Resource: addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "another",
+		},
+	}
+	dataAddr := addrs.ConfigResource{
+		Module: addrs.RootModule,
+		Resource: addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		},
+	}
+	anotherChildAddr := addrs.ConfigResource{
+		Module: addrs.Module{"child_mod"},
+		Resource: addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "another_child_data",
+		},
+	}
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		dataAddr.Resource.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+			// One dependency stays within the component, the other crosses
+			// into component.child.
+			Dependencies: []addrs.ConfigResource{anotherAddr, childDataAddr},
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	rootModule.SetResourceInstanceCurrent(
+		anotherAddr.Resource.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"bar","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	childModule := state.EnsureModule(addrs.RootModuleInstance.Child("child_mod", addrs.NoKey))
+	childModule.SetResourceInstanceCurrent(
+		childDataAddr.Resource.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"baz","value":"world"}`),
+			Dependencies: []addrs.ConfigResource{
+				anotherChildAddr, // same component, module prefix must be stripped
+				dataAddr,         // crosses into component.parent
+			},
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	childModule.SetResourceInstanceCurrent(
+		anotherChildAddr.Resource.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"qux","value":"world"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+	resources := map[string]string{
+		"testing_resource.data":    "parent",
+		"testing_resource.another": "parent",
+	}
+	modules := map[string]string{"child_mod": "child"}
+
+	applied := make(map[string]*stackstate.AppliedChangeResourceInstanceObject)
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			applied[c.ResourceInstanceObjectAddr.String()] = c
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	wantDeps := map[string][]addrs.ConfigResource{
+		"component.parent.testing_resource.data": {anotherAddr},
+		"component.child.testing_resource.child_data": {
+			{Module: addrs.RootModule, Resource: anotherChildAddr.Resource},
+		},
+	}
+	for addr, want := range wantDeps {
+		change, ok := applied[addr]
+		if !ok {
+			t.Fatalf("no applied change for %s", addr)
+		}
+		if diff := cmp.Diff(want, change.NewStateSrc.Dependencies); diff != "" {
+			t.Errorf("wrong dependencies for %s\n%s", addr, diff)
+		}
+	}
+
+	var expDiags tfdiags.Diagnostics
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Cross-component resource dependencies dropped",
+		"Dependencies recorded against the following resources refer to resources that were migrated into other components:\n  - module.child_mod.testing_resource.child_data (1)\n  - testing_resource.data (1)\n\nEach entry shows how many dependencies were dropped from the resource's objects. The ordering between components is preserved by the component dependency metadata instead.",
+	))
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
+		t.Errorf("unexpected diagnostics\n%s", diff)
+	}
+}
+
+func TestMigrate_OutputRename(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
+
+	state := states.BuildState(func(ss *states.SyncState) {
+		ss.SetOutputValue(addrs.AbsOutputValue{
+			Module:      addrs.RootModuleInstance,
+			OutputValue: addrs.OutputValue{Name: "vpc_id"},
+		}, cty.StringVal("net-123"), false)
+		ss.SetOutputValue(addrs.AbsOutputValue{
+			Module:      addrs.RootModuleInstance,
+			OutputValue: addrs.OutputValue{Name: "legacy"},
+		}, cty.StringVal("old"), false)
+	})
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+		OutputRename: map[string]string{
+			"vpc_id": "id",   // declared by the component's module
+			"legacy": "nope", // not declared, so an error
+		},
+	}
+
+	var component *stackstate.AppliedChangeComponentInstance
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(map[string]string{"testing_resource.data": "parent"}, nil, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeComponentInstance); ok {
+			component = c
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if component == nil {
+		t.Fatal("no component instance change was emitted")
+	}
+	got, ok := component.OutputValues[addrs.OutputValue{Name: "id"}]
+	if !ok {
+		t.Fatalf("renamed output %q missing from emitted change: %#v", "id", component.OutputValues)
+	}
+	if want := cty.StringVal("net-123"); !got.RawEquals(want) {
+		t.Errorf("wrong value for renamed output: got %#v, want %#v", got, want)
+	}
+	if _, ok := component.OutputValues[addrs.OutputValue{Name: "vpc_id"}]; ok {
+		t.Error("old output name still present in emitted change")
+	}
+
+	var expDiags tfdiags.Diagnostics
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Error,
+		"Renamed output not declared",
+		"The output rename maps \"legacy\" to \"nope\", but the target component does not declare an output of that name.",
+	))
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
+		t.Errorf("unexpected diagnostics\n%s", diff)
+	}
+}
+
+func TestMigrate_ComponentNamer(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
+
+	newState := func() *states.State {
+		state := states.NewState()
+		childModule := state.EnsureModule(addrs.RootModuleInstance.Child("child_mod", addrs.NoKey))
+		childModule.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "testing_resource",
+				Name: "child_data",
+			}.Instance(addrs.NoKey),
+			&states.ResourceInstanceObjectSrc{
+				Status:    states.ObjectReady,
+				AttrsJSON: []byte(`{"id":"bar","value":"world"}`),
+			},
+			mustDefaultRootProvider("testing"),
+		)
+		return state
+	}
+
+	newMigration := func(state *states.State) Migration {
+		return Migration{
+			Providers: map[addrs.Provider]providers.Factory{
+				addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+					return stacks_testing_provider.NewProvider(t), nil
+				},
+			},
+			PreviousState: state,
+			Config:        cfg,
+		}
+	}
+
+	t.Run("derived name", func(t *testing.T) {
+		mig := newMigration(newState())
+		// module.child_mod has no entry in the modules mapping, so the naming
+		// function derives the component name from the module address.
+		mig.ComponentNamer = func(module addrs.ModuleInstance) string {
+			return strings.TrimSuffix(module[0].Name, "_mod")
+		}
+
+		var appliedResources []*stackstate.AppliedChangeResourceInstanceObject
+		var gotDiags tfdiags.Diagnostics
+		mig.Migrate(nil, nil, func(change stackstate.AppliedChange) {
+			if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+				appliedResources = append(appliedResources, c)
+			}
+		}, func(diagnostic tfdiags.Diagnostic) {
+			gotDiags = append(gotDiags, diagnostic)
+		})
+
+		if len(gotDiags) != 0 {
+			t.Fatalf("unexpected diagnostics: %s", gotDiags.Err())
+		}
+		if len(appliedResources) != 1 {
+			t.Fatalf("expected 1 applied resource change, got %d", len(appliedResources))
+		}
+		if got, want := appliedResources[0].ResourceInstanceObjectAddr.String(), "component.child.testing_resource.child_data"; got != want {
+			t.Errorf("wrong resource address: got %s, want %s", got, want)
+		}
+	})
+
+	t.Run("invalid name", func(t *testing.T) {
+		mig := newMigration(newState())
+		mig.ComponentNamer = func(module addrs.ModuleInstance) string {
+			return module.String() // "module.child_mod" is not a valid identifier
+		}
+
+		var gotDiags tfdiags.Diagnostics
+		mig.Migrate(nil, nil, func(change stackstate.AppliedChange) {}, func(diagnostic tfdiags.Diagnostic) {
+			gotDiags = append(gotDiags, diagnostic)
+		})
+
+		if len(gotDiags) != 1 {
+			t.Fatalf("expected 1 diagnostic, got %d: %s", len(gotDiags), gotDiags.Err())
+		}
+		if got, want := gotDiags[0].Description().Summary, "Invalid component name"; got != want {
+			t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestMigrate_DiscoverComponents(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	childModule := state.EnsureModule(addrs.RootModuleInstance.Child("child_mod", addrs.NoKey))
+	childModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "child_data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"bar","value":"world"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	resources := map[string]string{"testing_resource.data": "parent"}
+	modules := map[string]string{"child_mod": "child"}
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+
+	discovered, diags := mig.DiscoverComponents(resources, modules)
+	if diags.HasErrors() {
+		t.Fatalf("unexpected diagnostics: %s", diags.Err())
+	}
+
+	got := make([]string, len(discovered))
+	for i, addr := range discovered {
+		got[i] = addr.String()
+	}
+	want := []string{"component.child", "component.parent"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("wrong discovered components\n%s", diff)
+	}
+
+	// The preview must match the component instances that a full migration
+	// with the same mappings then emits.
+	emitted := make(map[string]bool)
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeComponentInstance); ok {
+			emitted[c.ComponentInstanceAddr.String()] = true
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+	})
+	if len(emitted) != len(discovered) {
+		t.Fatalf("full migration emitted %d component instances, discovery found %d", len(emitted), len(discovered))
+	}
+	for _, addr := range got {
+		if !emitted[addr] {
+			t.Errorf("discovered component %s was not emitted by the full migration", addr)
+		}
+	}
+}
+
+func TestMigrate_ShardByComponent(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "another",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"bar","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	childModule := state.EnsureModule(addrs.RootModuleInstance.Child("child_mod", addrs.NoKey))
+	childModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "child_data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"baz","value":"world"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	resources := map[string]string{
+		"testing_resource.data":    "parent",
+		"testing_resource.another": "parent",
+	}
+	modules := map[string]string{"child_mod": "child"}
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState:    state,
+		Config:           cfg,
+		ShardByComponent: true,
+	}
+
+	// The shard callback variant delivers everything for one component in a
+	// single call, so each invocation is a shard boundary.
+	var shards []ComponentShard
+	mig.MigrateSharded(resources, modules, func(shard ComponentShard) {
+		shards = append(shards, shard)
+	}, func(diagnostic tfdiags.Diagnostic) {
+		t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+	})
+
+	gotComponents := make([]string, len(shards))
+	for i, shard := range shards {
+		gotComponents[i] = shard.Component.String()
+		if len(shard.Changes) == 0 {
+			t.Errorf("shard for %s has no changes", shard.Component)
+		}
+		for _, change := range shard.Changes {
+			if got := appliedChangeComponentInstance(change).String(); got != gotComponents[i] {
+				t.Errorf("shard for %s contains change for %s", gotComponents[i], got)
+			}
+		}
+	}
+	wantComponents := []string{"component.child", "component.parent"}
+	if diff := cmp.Diff(wantComponents, gotComponents); diff != "" {
+		t.Fatalf("wrong shards\n%s", diff)
+	}
+
+	// The parent shard holds two resource objects plus the component
+	// instance itself, and the child shard one of each.
+	if got, want := len(shards[0].Changes), 2; got != want {
+		t.Errorf("wrong number of changes in child shard: got %d, want %d", got, want)
+	}
+	if got, want := len(shards[1].Changes), 3; got != want {
+		t.Errorf("wrong number of changes in parent shard: got %d, want %d", got, want)
+	}
+
+	// With just the ShardByComponent option the plain callback receives the
+	// same changes contiguously grouped by component.
+	var order []string
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		order = append(order, appliedChangeComponentInstance(change).String())
+	}, func(diagnostic tfdiags.Diagnostic) {
+		t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+	})
+	seen := make(map[string]bool)
+	for i, component := range order {
+		if i > 0 && order[i-1] != component && seen[component] {
+			t.Fatalf("changes for %s are not contiguous: %v", component, order)
+		}
+		seen[component] = true
+	}
+}
+
+func TestMigrate_VerifyComponentInputs(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+
+	var applied []stackstate.AppliedChange
+	mig.Migrate(map[string]string{"testing_resource.data": "self"}, nil, func(change stackstate.AppliedChange) {
+		applied = append(applied, change)
+	}, func(diagnostic tfdiags.Diagnostic) {
+		t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+	})
+
+	// The changes produced by the migration itself must verify cleanly.
+	if diags := mig.VerifyComponentInputs(applied); len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics from unmodified changes: %s", diags.Err())
+	}
+
+	// Tampering with the recorded inputs must be caught: one undeclared
+	// variable added, and the required "input" variable removed.
+	for _, change := range applied {
+		if c, ok := change.(*stackstate.AppliedChangeComponentInstance); ok {
+			delete(c.InputVariables, addrs.InputVariable{Name: "input"})
+			c.InputVariables[addrs.InputVariable{Name: "nonsense"}] = cty.DynamicVal
+		}
+	}
+
+	gotDiags := mig.VerifyComponentInputs(applied)
+	var expDiags tfdiags.Diagnostics
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Error,
+		"Undeclared component inputs",
+		"The change for component.self records input variables that the component's module does not declare: nonsense.",
+	))
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Error,
+		"Missing required component inputs",
+		"The change for component.self does not record the required input variables input declared by the component's module.",
+	))
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
+		t.Errorf("unexpected diagnostics\n%s", diff)
+	}
+}
+
+func TestMigrate_TaintedResources(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectTainted,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState:             state,
+		Config:                    cfg,
+		FlagTaintedForReplacement: true,
+	}
+
+	var emitted *stackstate.AppliedChangeResourceInstanceObject
+	result := mig.Migrate(map[string]string{"testing_resource.data": "self"}, nil, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			emitted = c
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+	})
+
+	if emitted == nil {
+		t.Fatal("no resource instance object was emitted")
+	}
+	// ObjectTainted is what the stack state stores as DAMAGED, so the next
+	// plan proposes replacement.
+	if got, want := emitted.NewStateSrc.Status, states.ObjectTainted; got != want {
+		t.Errorf("wrong status for migrated object: got %v, want %v", got, want)
+	}
+
+	objAddr := mustAbsResourceInstanceObject("component.self.testing_resource.data")
+	wantTainted := stackaddrs.AbsResourceInstance{
+		Component: objAddr.Component,
+		Item:      objAddr.Item.ResourceInstance,
+	}
+	if result.TaintedResources.Len() != 1 || !result.TaintedResources.Has(wantTainted) {
+		t.Errorf("wrong tainted set: %#v", result.TaintedResources)
+	}
+}
+
+func TestMigrate_StripAttributes(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "super-secret"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+		StripAttributes: map[string][]string{
+			"testing_resource": {"value"},
+		},
+	}
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	var appliedResources []*stackstate.AppliedChangeResourceInstanceObject
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			appliedResources = append(appliedResources, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if len(gotDiags) != 0 {
+		t.Fatalf("unexpected diagnostics: %s", gotDiags.Err())
+	}
+	if len(appliedResources) != 1 {
+		t.Fatalf("expected 1 applied resource change, got %d", len(appliedResources))
+	}
+
+	var gotAttrs map[string]interface{}
+	if err := json.Unmarshal(appliedResources[0].NewStateSrc.AttrsJSON, &gotAttrs); err != nil {
+		t.Fatalf("emitted AttrsJSON does not decode: %s", err)
+	}
+	if got := gotAttrs["id"]; got != "foo" {
+		t.Errorf("wrong id after stripping: got %v, want %q", got, "foo")
+	}
+	if got := gotAttrs["value"]; got != nil {
+		t.Errorf("stripped attribute still present: got %v, want null", got)
+	}
+
+	// A path that doesn't match the schema must be reported, not ignored.
+	mig.StripAttributes = map[string][]string{
+		"testing_resource": {"nonexistent"},
+	}
+	gotDiags = nil
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+	if len(gotDiags) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %d", len(gotDiags))
+	}
+	if got, want := gotDiags[0].Description().Summary, "Cannot strip attribute from resource state"; got != want {
+		t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+	}
+}
+
+func TestMigrate_NullWriteOnlyAttributes(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			// A module bug persisted a value for the write-only attribute
+			// "secret", which must not survive the migration.
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello",
+				"secret": "super-secret"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				// A variation of testing_resource whose schema includes a
+				// write-only attribute.
+				return &default_testing_provider.MockProvider{
+					GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+						Provider: providers.Schema{Body: &configschema.Block{}},
+						ResourceTypes: map[string]providers.Schema{
+							"testing_resource": {
+								Body: &configschema.Block{
+									Attributes: map[string]*configschema.Attribute{
+										"id":     {Type: cty.String, Required: true},
+										"value":  {Type: cty.String, Optional: true},
+										"secret": {Type: cty.String, Optional: true, WriteOnly: true},
+									},
+								},
+							},
+						},
+					},
+				}, nil
+			},
+		},
+		PreviousState:           state,
+		Config:                  cfg,
+		NullWriteOnlyAttributes: true,
+	}
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	var appliedResources []*stackstate.AppliedChangeResourceInstanceObject
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			appliedResources = append(appliedResources, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if len(appliedResources) != 1 {
+		t.Fatalf("expected 1 applied resource change, got %d", len(appliedResources))
+	}
+	var gotAttrs map[string]interface{}
+	if err := json.Unmarshal(appliedResources[0].NewStateSrc.AttrsJSON, &gotAttrs); err != nil {
+		t.Fatalf("emitted AttrsJSON does not decode: %s", err)
+	}
+	if got := gotAttrs["secret"]; got != nil {
+		t.Errorf("write-only attribute still present: got %v, want null", got)
+	}
+	if got := gotAttrs["value"]; got != "hello" {
+		t.Errorf("wrong value for ordinary attribute: got %v, want %q", got, "hello")
+	}
+
+	// The scrubbing is reported as a warning naming the affected path.
+	if len(gotDiags) != 1 {
+		t.Fatalf("expected exactly one diagnostic, got: %v", gotDiags)
+	}
+	if got, want := gotDiags[0].Severity(), tfdiags.Warning; got != want {
+		t.Errorf("wrong diagnostic severity: got %s, want %s", got, want)
+	}
+	if got, want := gotDiags[0].Description().Summary, "Stale write-only attribute values discarded"; got != want {
+		t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+	}
+	if detail := gotDiags[0].Description().Detail; !strings.Contains(detail, "secret") || !strings.Contains(detail, "testing_resource.data") {
+		t.Errorf("diagnostic detail does not identify the attribute and resource: %s", detail)
+	}
+
+	// With a state that has no stored write-only values there is nothing to
+	// scrub and no warning.
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello",
+				"secret": null
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	gotDiags = nil
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+	if len(gotDiags) != 0 {
+		t.Errorf("unexpected diagnostics: %s", gotDiags.Err())
+	}
+}
+
+func TestMigrate_AliasedProviderConfigs(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "aliased-provider"))
+
+	westProvider := mustDefaultRootProvider("testing")
+	westProvider.Alias = "west"
+
+	t.Run("aliases preserved per resource", func(t *testing.T) {
+		state := states.NewState()
+		rootModule := state.RootModule()
+		rootModule.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "testing_resource",
+				Name: "data",
+			}.Instance(addrs.NoKey),
+			&states.ResourceInstanceObjectSrc{
+				Status: states.ObjectReady,
+				AttrsJSON: []byte(`{
+					"id": "foo",
+					"value": "hello"
+				}`),
+			},
+			mustDefaultRootProvider("testing"),
+		)
+		rootModule.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "testing_resource",
+				Name: "west",
+			}.Instance(addrs.NoKey),
+			&states.ResourceInstanceObjectSrc{
+				Status: states.ObjectReady,
+				AttrsJSON: []byte(`{
+					"id": "bar",
+					"value": "hello"
+				}`),
+			},
+			westProvider,
+		)
+
+		mig := Migration{
+			Providers: map[addrs.Provider]providers.Factory{
+				addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+					return stacks_testing_provider.NewProvider(t), nil
+				},
+			},
+			PreviousState: state,
+			Config:        cfg,
+		}
+		resources := map[string]string{
+			"testing_resource.data": "self",
+			"testing_resource.west": "self",
+		}
+
+		gotAliases := make(map[string]string)
+		var gotDiags tfdiags.Diagnostics
+		mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+			if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+				gotAliases[c.ResourceInstanceObjectAddr.Item.ResourceInstance.Resource.Resource.Name] = c.ProviderConfigAddr.Alias
+			}
+		}, func(diagnostic tfdiags.Diagnostic) {
+			gotDiags = append(gotDiags, diagnostic)
+		})
+
+		if len(gotDiags) != 0 {
+			t.Fatalf("unexpected diagnostics: %s", gotDiags.Err())
+		}
+		wantAliases := map[string]string{
+			"data": "",
+			"west": "west",
+		}
+		if diff := cmp.Diff(wantAliases, gotAliases); diff != "" {
+			t.Errorf("wrong provider config aliases\n%s", diff)
+		}
+	})
+
+	t.Run("alias not accepted by component", func(t *testing.T) {
+		eastProvider := mustDefaultRootProvider("testing")
+		eastProvider.Alias = "east"
+
+		state := states.NewState()
+		rootModule := state.RootModule()
+		rootModule.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "testing_resource",
+				Name: "data",
+			}.Instance(addrs.NoKey),
+			&states.ResourceInstanceObjectSrc{
+				Status: states.ObjectReady,
+				AttrsJSON: []byte(`{
+					"id": "foo",
+					"value": "hello"
+				}`),
+			},
+			eastProvider,
+		)
+
+		mig := Migration{
+			Providers: map[addrs.Provider]providers.Factory{
+				addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+					return stacks_testing_provider.NewProvider(t), nil
+				},
+			},
+			PreviousState: state,
+			Config:        cfg,
+		}
+		resources := map[string]string{
+			"testing_resource.data": "self",
+		}
+
+		var appliedResources []*stackstate.AppliedChangeResourceInstanceObject
+		var gotDiags tfdiags.Diagnostics
+		mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+			if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+				appliedResources = append(appliedResources, c)
+			}
+		}, func(diagnostic tfdiags.Diagnostic) {
+			gotDiags = append(gotDiags, diagnostic)
+		})
+
+		if len(appliedResources) != 0 {
+			t.Errorf("expected no applied resource changes, got %d", len(appliedResources))
+		}
+		var errCount int
+		for _, diag := range gotDiags {
+			if diag.Severity() == tfdiags.Error {
+				errCount++
+				if got, want := diag.Description().Summary, "Provider configuration not found in component"; got != want {
+					t.Errorf("wrong error summary: got %q, want %q", got, want)
+				}
+			}
+		}
+		if errCount != 1 {
+			t.Errorf("expected exactly 1 error diagnostic, got %d: %s", errCount, gotDiags.Err())
+		}
+	})
+}
+
+func TestMigrate_Preflight(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+	if diags := mig.Preflight(); len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics from clean preflight: %v", diags)
+	}
+
+	// With a provider that doesn't know the resource type, preflight reports
+	// the missing schema without emitting any changes.
+	mig.Providers = map[addrs.Provider]providers.Factory{
+		addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+			return &default_testing_provider.MockProvider{
+				GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+					Provider: providers.Schema{Body: &configschema.Block{}},
+				},
+			}, nil
+		},
+	}
+
+	var expDiags tfdiags.Diagnostics
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Error,
+		"Resource type not found",
+		"Resource type \"testing_resource\" is not supported by provider hashicorp/testing, which is required by testing_resource.data in the previous state.",
+	))
+	gotDiags := mig.Preflight()
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
+		t.Fatalf("unexpected diagnostics:\n%s", diff)
+	}
+
+	// A provider that isn't configured at all is also reported.
+	mig.Providers = nil
+	gotDiags = mig.Preflight()
+	if len(gotDiags) != 1 || gotDiags[0].Description().Summary != "Provider not found" {
+		t.Fatalf("expected a single \"Provider not found\" diagnostic, got: %v", gotDiags)
+	}
+}
+
+func TestMigrate_FilteredByModule(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	childModule := state.EnsureModule(addrs.RootModuleInstance.Child("child_mod", addrs.NoKey))
+	childModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "child_data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+		// Only the child module's resources are migrated in this phase; the
+		// root module's resource is left untouched despite being mapped.
+		Filter: func(instance addrs.AbsResourceInstance) bool {
+			return !instance.Module.IsRoot() && instance.Module[0].Name == "child_mod"
+		},
+	}
+	resources := map[string]string{
+		"testing_resource.data": "parent",
+	}
+	modules := map[string]string{
+		"child_mod": "child",
+	}
+
+	appliedResources := []*stackstate.AppliedChangeResourceInstanceObject{}
+	appliedComponents := []*stackstate.AppliedChangeComponentInstance{}
+	expectedResources := []*stackstate.AppliedChangeResourceInstanceObject{
+		{
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.child.testing_resource.child_data"),
+			NewStateSrc: &states.ResourceInstanceObjectSrc{
+				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
+					"id":    "foo",
+					"value": "hello",
+				}),
+				Status:  states.ObjectReady,
+				Private: nil,
+			},
+			ProviderConfigAddr: mustDefaultRootProvider("testing"),
+			Schema:             stacks_testing_provider.TestingResourceSchema,
+		},
+	}
+	expectedComponents := []*stackstate.AppliedChangeComponentInstance{
+		{
+			ComponentAddr:         mustAbsComponent("component.child"),
+			ComponentInstanceAddr: mustAbsComponentInstance("component.child"),
+			OutputValues: map[addrs.OutputValue]cty.Value{
+				{Name: "id"}: cty.DynamicVal,
+			},
+			InputVariables: map[addrs.InputVariable]cty.Value{
+				{Name: "id"}:    cty.DynamicVal,
+				{Name: "input"}: cty.DynamicVal,
+			},
+			// The config-level dependency on the parent component is still
+			// recorded, even though the parent migrates in a later phase.
+			Dependencies: collections.NewSet(mustAbsComponent("component.parent")),
+		},
+	}
+
+	var expDiags, gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		switch c := change.(type) {
+		case *stackstate.AppliedChangeResourceInstanceObject:
+			appliedResources = append(appliedResources, c)
+		case *stackstate.AppliedChangeComponentInstance:
+			appliedComponents = append(appliedComponents, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
+		t.Fatalf("unexpected diagnostics:\n%s", diff)
+	}
+
+	if diff := compareAppliedChanges(t, expectedResources, appliedResources, func(c *stackstate.AppliedChangeResourceInstanceObject) string {
+		return c.ResourceInstanceObjectAddr.String()
+	}); diff != "" {
+		t.Errorf("unexpected applied resource changes:\n%s", diff)
+	}
+
+	if diff := compareAppliedChanges(t, expectedComponents, appliedComponents, func(c *stackstate.AppliedChangeComponentInstance) string {
+		return c.ComponentAddr.String()
+	}); diff != "" {
+		t.Errorf("unexpected applied component changes:\n%s", diff)
+	}
+}
+
+func TestMigrate_CoerceToCurrentSchema(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	// The provider's schema has gained an optional attribute since the state
+	// was written.
+	schemaBody := &configschema.Block{
+		Attributes: map[string]*configschema.Attribute{
+			"id":    {Type: cty.String, Optional: true, Computed: true},
+			"value": {Type: cty.String, Optional: true},
+			"extra": {Type: cty.String, Optional: true},
+		},
+	}
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return &default_testing_provider.MockProvider{
+					GetProviderSchemaResponse: &providers.GetProviderSchemaResponse{
+						Provider: providers.Schema{Body: &configschema.Block{}},
+						ResourceTypes: map[string]providers.Schema{
+							"testing_resource": {Body: schemaBody},
+						},
+					},
+				}, nil
+			},
+		},
+		PreviousState:         state,
+		Config:                cfg,
+		CoerceToCurrentSchema: true,
+	}
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	var appliedResources []*stackstate.AppliedChangeResourceInstanceObject
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			appliedResources = append(appliedResources, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if len(gotDiags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", gotDiags)
+	}
+	if len(appliedResources) != 1 {
+		t.Fatalf("expected 1 applied resource, got %d", len(appliedResources))
+	}
+	want := mustMarshalJSONAttrs(map[string]interface{}{
+		"extra": nil,
+		"id":    "foo",
+		"value": "hello",
+	})
+	if got := appliedResources[0].NewStateSrc.AttrsJSON; string(got) != string(want) {
+		t.Errorf("wrong attrs after coercion\ngot:  %s\nwant: %s", got, want)
+	}
+
+	// Stored data that doesn't fit the current schema is reported clearly.
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id": "foo", "value": {"nested": true}}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	gotDiags = nil
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+	if len(gotDiags) != 1 {
+		t.Fatalf("expected exactly one diagnostic, got: %v", gotDiags)
+	}
+	if got, want := gotDiags[0].Description().Summary, "Incompatible resource state"; got != want {
+		t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+	}
+}
+
+func TestMigrate_Reconcile(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+	modules := map[string]string{}
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+
+	var changes []stackstate.AppliedChange
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		changes = append(changes, change)
+	}, func(diagnostic tfdiags.Diagnostic) {
+		t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+	})
+	if len(changes) == 0 {
+		t.Fatal("first run emitted no changes")
+	}
+
+	// Record everything the first run emitted as the existing stack state,
+	// as if those changes had been applied.
+	builder := stackstate.NewStateBuilder()
+	for _, change := range changes {
+		switch c := change.(type) {
+		case *stackstate.AppliedChangeResourceInstanceObject:
+			builder.AddResourceInstance(stackstate.NewResourceInstanceBuilder().
+				SetAddr(c.ResourceInstanceObjectAddr).
+				SetResourceInstanceObjectSrc(*c.NewStateSrc).
+				SetProviderAddr(c.ProviderConfigAddr))
+		case *stackstate.AppliedChangeComponentInstance:
+			builder.AddComponentInstance(stackstate.NewComponentInstanceBuilder(c.ComponentInstanceAddr))
+		}
+	}
+	mig.ExistingState = builder.Build()
+
+	// A second run against that state must be a no-op.
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		t.Errorf("second run emitted unexpected change: %#v", change)
+	}, func(diagnostic tfdiags.Diagnostic) {
+		t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+	})
+}
+
+func TestMigrate_ResolveInputs(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "constant-inputs"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+		ResolveInputs: true,
+	}
+
+	var componentChanges []*stackstate.AppliedChangeComponentInstance
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeComponentInstance); ok {
+			componentChanges = append(componentChanges, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+	})
+
+	if len(componentChanges) != 1 {
+		t.Fatalf("expected 1 component change, got %d", len(componentChanges))
+	}
+	inputs := componentChanges[0].InputVariables
+
+	// The "id" input is a constant in the stack configuration, so its value
+	// must be carried into the migrated state.
+	if got, want := inputs[addrs.InputVariable{Name: "id"}], cty.StringVal("foo"); !want.RawEquals(got) {
+		t.Errorf("wrong value for input %q: got %#v, want %#v", "id", got, want)
+	}
+
+	// The "input" input references a stack variable, which only the stack
+	// runtime can evaluate, so it must stay unknown.
+	if got := inputs[addrs.InputVariable{Name: "input"}]; !got.RawEquals(cty.DynamicVal) {
+		t.Errorf("wrong value for input %q: got %#v, want unknown", "input", got)
+	}
+}
+
+func TestMigrate_ContextCancelled(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+
+	// A context that is already cancelled must stop the migration before it
+	// emits any changes, reporting the interruption as a diagnostic.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var gotDiags tfdiags.Diagnostics
+	mig.MigrateContext(ctx, resources, nil, func(change stackstate.AppliedChange) {
+		t.Errorf("unexpected change: %#v", change)
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if len(gotDiags) != 1 {
+		t.Fatalf("expected exactly one diagnostic, got: %v", gotDiags)
+	}
+	if got, want := gotDiags[0].Description().Summary, "Migration cancelled"; got != want {
+		t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+	}
+}
+
+func TestMigrate_ExistingComponents(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+	modules := map[string]string{}
+
+	// The target stack state already has two components populated by an
+	// earlier migration: the one we're migrating into, and an unrelated one.
+	existing := stackstate.NewStateBuilder().
+		AddComponentInstance(stackstate.NewComponentInstanceBuilder(mustAbsComponentInstance("component.self"))).
+		AddComponentInstance(stackstate.NewComponentInstanceBuilder(mustAbsComponentInstance("component.existing"))).
+		Build()
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+		ExistingState: existing,
+	}
+
+	// Under the default merge policy the resource lands in the existing
+	// component.self, which itself is not emitted again.
+	var resourceChanges []*stackstate.AppliedChangeResourceInstanceObject
+	var componentChanges []*stackstate.AppliedChangeComponentInstance
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		switch c := change.(type) {
+		case *stackstate.AppliedChangeResourceInstanceObject:
+			resourceChanges = append(resourceChanges, c)
+		case *stackstate.AppliedChangeComponentInstance:
+			componentChanges = append(componentChanges, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+	})
+	if len(resourceChanges) != 1 {
+		t.Fatalf("expected 1 resource change, got %d", len(resourceChanges))
+	}
+	if len(componentChanges) != 0 {
+		t.Fatalf("expected no component changes, got %d", len(componentChanges))
+	}
+
+	// With the conflict policy the same mapping must be refused outright.
+	mig.MergePolicy = ConflictOnExistingComponents
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		t.Errorf("unexpected change: %#v", change)
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+	if len(gotDiags) != 1 {
+		t.Fatalf("expected exactly one diagnostic, got: %v", gotDiags)
+	}
+	if got, want := gotDiags[0].Description().Summary, "Component instance already exists"; got != want {
+		t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+	}
+	if detail := gotDiags[0].Description().Detail; !strings.Contains(detail, "component.self") {
+		t.Errorf("diagnostic detail does not name the component instance: %s", detail)
+	}
+}
+
+func TestMigrate_NonDefaultProviderRegistry(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "non-default-provider"))
+
+	// The previous state references the provider through a non-default
+	// registry, so every lookup must key off the fully-qualified address.
+	providerAddr := addrs.NewProvider("registry.example.com", "awesomecorp", "testing")
+	providerConfig := addrs.AbsProviderConfig{
+		Module:   addrs.RootModule,
+		Provider: providerAddr,
+	}
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		providerConfig,
+	)
+
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			providerAddr: func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+
+	var resourceChanges []*stackstate.AppliedChangeResourceInstanceObject
+	mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			resourceChanges = append(resourceChanges, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+	})
+
+	if len(resourceChanges) != 1 {
+		t.Fatalf("expected 1 resource change, got %d", len(resourceChanges))
+	}
+	if got := resourceChanges[0].ProviderConfigAddr.Provider; !got.Equals(providerAddr) {
+		t.Errorf("wrong provider recorded against migrated resource: got %s, want %s", got, providerAddr)
+	}
+}
+
+func TestMigrate_ResourceTypeRename(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource_legacy",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	resources := map[string]string{
+		"testing_resource_legacy.data": "self",
+	}
+	modules := map[string]string{}
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+		ResourceTypeRename: map[string]string{
+			"testing_resource_legacy": "testing_resource",
+		},
+	}
+
+	var resourceChanges []*stackstate.AppliedChangeResourceInstanceObject
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			resourceChanges = append(resourceChanges, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if len(gotDiags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", gotDiags)
+	}
+	if len(resourceChanges) != 1 {
+		t.Fatalf("expected 1 resource change, got %d", len(resourceChanges))
+	}
+
+	// The instance must be recorded under the new type name, with the
+	// attribute data carried over unchanged.
+	got := resourceChanges[0]
+	if want := mustAbsResourceInstanceObject("component.self.testing_resource.data"); got.ResourceInstanceObjectAddr.String() != want.String() {
+		t.Errorf("wrong address: got %s, want %s", got.ResourceInstanceObjectAddr, want)
+	}
+	wantAttrs := []byte(`{"id":"foo","value":"hello"}`)
+	if diff := cmp.Diff(wantAttrs, got.NewStateSrc.AttrsJSON); diff != "" {
+		t.Errorf("attribute data was not carried over unchanged:\n%s", diff)
+	}
+
+	// Stored data that doesn't decode against the new type's schema is still
+	// migrated, but with a warning.
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource_legacy",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id": "foo", "value": {"nested": true}}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	gotDiags = nil
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+	if len(gotDiags) != 1 || gotDiags[0].Description().Summary != "Renamed resource type may be incompatible" {
+		t.Fatalf("expected a single incompatibility warning, got: %v", gotDiags)
+	}
+}
+
+func TestMigrate_DeduplicateDiagnostics(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	resources := map[string]string{}
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("data%d", i)
+		rootModule.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "testing_resource",
+				Name: name,
+			}.Instance(addrs.NoKey),
+			&states.ResourceInstanceObjectSrc{
+				Status:    states.ObjectReady,
+				AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+			},
+			mustDefaultRootProvider("testing"),
+		)
+		resources["testing_resource."+name] = "self"
+	}
+	modules := map[string]string{}
+
+	// No provider factories at all, so every resource hits the same
+	// missing-provider condition.
+	mig := Migration{
+		Providers:              map[addrs.Provider]providers.Factory{},
+		PreviousState:          state,
+		Config:                 cfg,
+		DeduplicateDiagnostics: true,
+	}
+
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if len(gotDiags) != 1 {
+		t.Fatalf("expected a single consolidated diagnostic, got %d: %v", len(gotDiags), gotDiags)
+	}
+	desc := gotDiags[0].Description()
+	if desc.Summary != "Provider not found" {
+		t.Errorf("wrong summary: %s", desc.Summary)
+	}
+	if !strings.Contains(desc.Detail, "occurred 10 times") {
+		t.Errorf("detail does not report the occurrence count:\n%s", desc.Detail)
+	}
+}
+
+func TestMigrate_DataSourcesSkipped(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.DataResourceMode,
+			Type: "testing_data_source",
+			Name: "lookup",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	var gotDiags tfdiags.Diagnostics
+	var gotResources []string
+	result := mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+		if change, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			gotResources = append(gotResources, change.ResourceInstanceObjectAddr.String())
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	// The managed resource migrates as normal, while the data resource is
+	// skipped with a warning rather than producing a "not found in mapping"
+	// error or being silently dropped.
+	if len(gotResources) != 1 || gotResources[0] != "component.self.testing_resource.data" {
+		t.Errorf("wrong migrated resources: %v", gotResources)
+	}
+	if got, want := result.ResourceInstanceObjects, 1; got != want {
+		t.Errorf("wrong ResourceInstanceObjects in result: got %d, want %d", got, want)
+	}
+
+	if len(gotDiags) != 1 {
+		t.Fatalf("expected exactly one diagnostic, got: %v", gotDiags)
+	}
+	if got, want := gotDiags[0].Severity(), tfdiags.Warning; got != want {
+		t.Errorf("wrong diagnostic severity: got %s, want %s", got, want)
+	}
+	if got, want := gotDiags[0].Description().Summary, "Data source not migrated"; got != want {
+		t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+	}
+	if detail := gotDiags[0].Description().Detail; !strings.Contains(detail, "data.testing_data_source.lookup") {
+		t.Errorf("diagnostic detail does not name the data resource: %s", detail)
+	}
+}
+
+func TestMigrate_WildcardResourceMapping(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+
+	migrated := func(t *testing.T, resources map[string]string) (map[string]bool, tfdiags.Diagnostics) {
+		t.Helper()
+		var gotDiags tfdiags.Diagnostics
+		gotResources := make(map[string]bool)
+		mig.Migrate(resources, nil, func(change stackstate.AppliedChange) {
+			if change, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+				gotResources[change.ResourceInstanceObjectAddr.String()] = true
+			}
+		}, func(diagnostic tfdiags.Diagnostic) {
+			gotDiags = append(gotDiags, diagnostic)
+		})
+		return gotResources, gotDiags
+	}
+
+	t.Run("wildcard only", func(t *testing.T) {
+		gotResources, gotDiags := migrated(t, map[string]string{
+			"testing_resource.*": "self",
+		})
+		if len(gotDiags) > 0 {
+			t.Fatalf("unexpected diagnostics: %s", gotDiags.Err())
+		}
+		if !gotResources["component.self.testing_resource.data"] {
+			t.Errorf("missing migrated resource component.self.testing_resource.data")
+		}
+	})
+
+	t.Run("exact key wins over wildcard", func(t *testing.T) {
+		// An exact key alongside a matching wildcard must not be reported
+		// as ambiguous: the exact key takes precedence.
+		gotResources, gotDiags := migrated(t, map[string]string{
+			"testing_resource.data": "self",
+			"testing_resource.*":    "self",
+		})
+		if len(gotDiags) > 0 {
+			t.Fatalf("unexpected diagnostics: %s", gotDiags.Err())
+		}
+		if !gotResources["component.self.testing_resource.data"] {
+			t.Errorf("missing migrated resource component.self.testing_resource.data")
+		}
+	})
+
+	t.Run("ambiguous patterns", func(t *testing.T) {
+		_, gotDiags := migrated(t, map[string]string{
+			"testing_resource.*": "self",
+			"*.data":             "self",
+		})
+
+		found := false
+		for _, diag := range gotDiags {
+			if diag.Description().Summary != "Ambiguous resource mapping" {
+				continue
+			}
+			found = true
+			if got, want := diag.Description().Detail, `Resource "testing_resource.data" is matched by multiple wildcard patterns: *.data, testing_resource.*.`; got != want {
+				t.Errorf("wrong detail\ngot:  %s\nwant: %s", got, want)
+			}
+		}
+		if !found {
+			t.Errorf("expected an ambiguity diagnostic, got: %s", gotDiags.Err())
+		}
+	})
+}
+
+func TestMigrate_ComponentDependency(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-dependency", "input-dependency"))
+
+	lock := depsfile.NewLocks()
+	lock.SetProvider(
+		addrs.NewDefaultProvider("testing"),
+		providerreqs.MustParseVersion("0.0.0"),
+		providerreqs.MustParseVersionConstraints("=0.0.0"),
+		providerreqs.PreferredHashes([]providerreqs.Hash{}),
+	)
+
+	state := states.BuildState(func(ss *states.SyncState) {
+		ss.SetOutputValue(addrs.AbsOutputValue{
+			Module:      addrs.RootModuleInstance,
+			OutputValue: addrs.OutputValue{Name: "output"},
+		}, cty.StringVal("before"), false)
+	})
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "another",
+		}.Instance(addrs.IntKey(0)),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "another",
+		}.Instance(addrs.IntKey(1)),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+	resources := map[string]string{
+		"testing_resource.data":    "parent",
+		"testing_resource.another": "child",
+	}
+	modules := map[string]string{}
+
+	appliedResources := []*stackstate.AppliedChangeResourceInstanceObject{}
+	appliedComponents := []*stackstate.AppliedChangeComponentInstance{}
+	expectedResources := []*stackstate.AppliedChangeResourceInstanceObject{
+		{
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.parent.testing_resource.data"),
+			NewStateSrc: &states.ResourceInstanceObjectSrc{
+				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
+					"id":    "foo",
+					"value": "hello",
+				}),
+				Status:  states.ObjectReady,
+				Private: nil,
+			},
+			ProviderConfigAddr: mustDefaultRootProvider("testing"),
+			Schema:             stacks_testing_provider.TestingResourceSchema,
+		},
+		{
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.child.testing_resource.another[0]"),
+			NewStateSrc: &states.ResourceInstanceObjectSrc{
+				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
+					"id":    "foo",
+					"value": "hello",
+				}),
+				Status:  states.ObjectReady,
+				Private: nil,
+			},
+			ProviderConfigAddr: mustDefaultRootProvider("testing"),
+			Schema:             stacks_testing_provider.TestingResourceSchema,
+		},
+		{
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.child.testing_resource.another[1]"),
+			NewStateSrc: &states.ResourceInstanceObjectSrc{
+				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
+					"id":    "foo",
+					"value": "hello",
+				}),
+				Status:  states.ObjectReady,
+				Private: nil,
+			},
+			ProviderConfigAddr: mustDefaultRootProvider("testing"),
+			Schema:             stacks_testing_provider.TestingResourceSchema,
+		},
+	}
+	expectedComponents := []*stackstate.AppliedChangeComponentInstance{
+		{
+			ComponentAddr:         mustAbsComponent("component.parent"),
+			ComponentInstanceAddr: mustAbsComponentInstance("component.parent"),
+			OutputValues: map[addrs.OutputValue]cty.Value{
+				{Name: "id"}: cty.DynamicVal,
+			},
+			InputVariables: map[addrs.InputVariable]cty.Value{
+				{Name: "id"}:    cty.DynamicVal,
+				{Name: "input"}: cty.DynamicVal,
+			},
+			Dependents: collections.NewSet(mustAbsComponent("component.child")),
+		},
+		{
+			ComponentAddr:         mustAbsComponent("component.child"),
+			ComponentInstanceAddr: mustAbsComponentInstance("component.child"),
+			OutputValues: map[addrs.OutputValue]cty.Value{
+				{Name: "id"}: cty.DynamicVal,
+			},
+			InputVariables: map[addrs.InputVariable]cty.Value{
+				{Name: "id"}:    cty.DynamicVal,
+				{Name: "input"}: cty.DynamicVal,
+			},
+			Dependencies: collections.NewSet(mustAbsComponent("component.parent")),
+		},
+	}
+
+	var expDiags, gotDiags tfdiags.Diagnostics
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Output value not migrated",
+		"The previous state records output \"output\" for the root module, but the target component does not declare a matching output, so its value was not migrated.",
+	))
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		switch c := change.(type) {
+		case *stackstate.AppliedChangeResourceInstanceObject:
+			appliedResources = append(appliedResources, c)
+		case *stackstate.AppliedChangeComponentInstance:
+			appliedComponents = append(appliedComponents, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if diff := compareAppliedChanges(t, expectedResources, appliedResources, func(c *stackstate.AppliedChangeResourceInstanceObject) string {
+		return c.ResourceInstanceObjectAddr.String()
+	}); diff != "" {
+		t.Fatalf("unexpected applied resource changes:\n%s", diff)
+	}
+
+	if diff := compareAppliedChanges(t, expectedComponents, appliedComponents, func(c *stackstate.AppliedChangeComponentInstance) string {
+		return c.ComponentAddr.String()
+	}); diff != "" {
+		t.Fatalf("unexpected applied component changes:\n%s", diff)
+	}
+
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
+		t.Fatalf("unexpected diagnostics:\n%s", diff)
+	}
+}
+
+func TestMigrateConfig_NestedModuleResources(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
+
+	lock := depsfile.NewLocks()
+	lock.SetProvider(
+		addrs.NewDefaultProvider("testing"),
+		providerreqs.MustParseVersion("0.0.0"),
+		providerreqs.MustParseVersionConstraints("=0.0.0"),
+		providerreqs.PreferredHashes([]providerreqs.Hash{}),
+	)
+
+	state := states.BuildState(func(ss *states.SyncState) {
+		ss.SetOutputValue(addrs.AbsOutputValue{
+			Module:      addrs.RootModuleInstance,
+			OutputValue: addrs.OutputValue{Name: "output"},
+		}, cty.StringVal("before"), false)
+	})
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "another",
+		}.Instance(addrs.IntKey(0)),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "another",
+		}.Instance(addrs.IntKey(1)),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	childModule := state.EnsureModule(addrs.RootModuleInstance.Child("child_mod", addrs.NoKey))
+	childModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "child_data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	childModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "another_child_data",
+		}.Instance(addrs.IntKey(0)),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	childModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "another_child_data",
+		}.Instance(addrs.IntKey(1)),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+	resources := map[string]string{
+		"testing_resource.data":    "parent",
+		"testing_resource.another": "parent",
+	}
+	modules := map[string]string{
+		"child_mod": "child",
+	}
+
+	appliedResources := []*stackstate.AppliedChangeResourceInstanceObject{}
+	appliedComponents := []*stackstate.AppliedChangeComponentInstance{}
+	expectedResources := []*stackstate.AppliedChangeResourceInstanceObject{
+		{
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.parent.testing_resource.data"),
+			NewStateSrc: &states.ResourceInstanceObjectSrc{
+				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
+					"id":    "foo",
+					"value": "hello",
+				}),
+				Status:  states.ObjectReady,
+				Private: nil,
+			},
+			ProviderConfigAddr: mustDefaultRootProvider("testing"),
+			Schema:             stacks_testing_provider.TestingResourceSchema,
+		},
+		{
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.parent.testing_resource.another[0]"),
+			NewStateSrc: &states.ResourceInstanceObjectSrc{
+				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
+					"id":    "foo",
+					"value": "hello",
+				}),
+				Status:  states.ObjectReady,
+				Private: nil,
+			},
+			ProviderConfigAddr: mustDefaultRootProvider("testing"),
+			Schema:             stacks_testing_provider.TestingResourceSchema,
+		},
+		{
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.parent.testing_resource.another[1]"),
+			NewStateSrc: &states.ResourceInstanceObjectSrc{
+				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
+					"id":    "foo",
+					"value": "hello",
+				}),
+				Status:  states.ObjectReady,
+				Private: nil,
+			},
+			ProviderConfigAddr: mustDefaultRootProvider("testing"),
+			Schema:             stacks_testing_provider.TestingResourceSchema,
+		},
+		{
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.child.testing_resource.child_data"),
+			NewStateSrc: &states.ResourceInstanceObjectSrc{
+				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
+					"id":    "foo",
+					"value": "hello",
+				}),
+				Status:  states.ObjectReady,
+				Private: nil,
+			},
+			ProviderConfigAddr: mustDefaultRootProvider("testing"),
+			Schema:             stacks_testing_provider.TestingResourceSchema,
+		},
+		{
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.child.testing_resource.another_child_data[0]"),
+			NewStateSrc: &states.ResourceInstanceObjectSrc{
+				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
+					"id":    "foo",
+					"value": "hello",
+				}),
+				Status:  states.ObjectReady,
+				Private: nil,
+			},
+			ProviderConfigAddr: mustDefaultRootProvider("testing"),
+			Schema:             stacks_testing_provider.TestingResourceSchema,
+		},
+		{
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.child.testing_resource.another_child_data[1]"),
+			NewStateSrc: &states.ResourceInstanceObjectSrc{
+				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
+					"id":    "foo",
+					"value": "hello",
+				}),
+				Status:  states.ObjectReady,
+				Private: nil,
+			},
+			ProviderConfigAddr: mustDefaultRootProvider("testing"),
+			Schema:             stacks_testing_provider.TestingResourceSchema,
+		},
+	}
+	expectedComponents := []*stackstate.AppliedChangeComponentInstance{
+		{
+			ComponentAddr:         mustAbsComponent("component.parent"),
+			ComponentInstanceAddr: mustAbsComponentInstance("component.parent"),
+			OutputValues: map[addrs.OutputValue]cty.Value{
+				{Name: "id"}: cty.DynamicVal,
+			},
+			InputVariables: map[addrs.InputVariable]cty.Value{
+				{Name: "id"}:    cty.DynamicVal,
+				{Name: "input"}: cty.DynamicVal,
+			},
+			Dependents: collections.NewSet(mustAbsComponent("component.child")),
+		},
+		{
+			ComponentAddr:         mustAbsComponent("component.child"),
+			ComponentInstanceAddr: mustAbsComponentInstance("component.child"),
+			OutputValues: map[addrs.OutputValue]cty.Value{
+				{Name: "id"}: cty.DynamicVal,
+			},
+			InputVariables: map[addrs.InputVariable]cty.Value{
+				{Name: "id"}:    cty.DynamicVal,
+				{Name: "input"}: cty.DynamicVal,
+			},
+			Dependencies: collections.NewSet(mustAbsComponent("component.parent")),
+		},
+	}
+
+	var expDiags, gotDiags tfdiags.Diagnostics
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Output value not migrated",
+		"The previous state records output \"output\" for the root module, but the target component does not declare a matching output, so its value was not migrated.",
+	))
+	result := mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		switch c := change.(type) {
+		case *stackstate.AppliedChangeResourceInstanceObject:
+			appliedResources = append(appliedResources, c)
+		case *stackstate.AppliedChangeComponentInstance:
+			appliedComponents = append(appliedComponents, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
+		t.Errorf("unexpected diagnostics:\n%s", diff)
+	}
+
+	if diff := compareAppliedChanges(t, expectedResources, appliedResources, func(c *stackstate.AppliedChangeResourceInstanceObject) string {
+		return c.ResourceInstanceObjectAddr.String()
+	}); diff != "" {
+		t.Errorf("unexpected applied resource changes:\n%s", diff)
+	}
+
+	if diff := compareAppliedChanges(t, expectedComponents, appliedComponents, func(c *stackstate.AppliedChangeComponentInstance) string {
+		return c.ComponentAddr.String()
+	}); diff != "" {
+		t.Errorf("unexpected applied component changes:\n%s", diff)
+	}
+
+	// The result must record which module instance each component's
+	// resources came from.
+	if got, want := result.SourceModules.Len(), 2; got != want {
+		t.Fatalf("wrong number of source module records: got %d, want %d", got, want)
+	}
+	if got, want := result.SourceModules.Get(mustAbsComponentInstance("component.parent")), addrs.RootModuleInstance; !got.Equal(want) {
+		t.Errorf("wrong source module for component.parent: got %s, want the root module", got)
+	}
+	if got, want := result.SourceModules.Get(mustAbsComponentInstance("component.child")), addrs.RootModuleInstance.Child("child_mod", addrs.NoKey); !got.Equal(want) {
+		t.Errorf("wrong source module for component.child: got %s, want %s", got, want)
+	}
+}
+
+func TestMigrate_DependencyGraph(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
 		&states.ResourceInstanceObjectSrc{
 			Status: states.ObjectReady,
 			AttrsJSON: []byte(`{
@@ -557,12 +4207,13 @@ func TestMigrateConfig_NestedModuleResources(t *testing.T) {
 		},
 		mustDefaultRootProvider("testing"),
 	)
+	childModule := state.EnsureModule(addrs.RootModuleInstance.Child("child_mod", addrs.NoKey))
 	childModule.SetResourceInstanceCurrent(
 		addrs.Resource{
 			Mode: addrs.ManagedResourceMode,
 			Type: "testing_resource",
-			Name: "another_child_data",
-		}.Instance(addrs.IntKey(1)),
+			Name: "child_data",
+		}.Instance(addrs.NoKey),
 		&states.ResourceInstanceObjectSrc{
 			Status: states.ObjectReady,
 			AttrsJSON: []byte(`{
@@ -583,8 +4234,96 @@ func TestMigrateConfig_NestedModuleResources(t *testing.T) {
 		Config:        cfg,
 	}
 	resources := map[string]string{
-		"testing_resource.data":    "parent",
-		"testing_resource.another": "parent",
+		"testing_resource.data": "parent",
+	}
+	modules := map[string]string{
+		"child_mod": "child",
+	}
+
+	graph, diags := mig.DependencyGraph(resources, modules)
+	if diags.HasErrors() {
+		t.Fatalf("unexpected diagnostics: %s", diags.Err())
+	}
+
+	if got, want := graph.Len(), 2; got != want {
+		t.Fatalf("wrong number of components in graph: got %d, want %d", got, want)
+	}
+	parentDeps, ok := graph.GetOk(mustAbsComponent("component.parent"))
+	if !ok {
+		t.Fatal("component.parent not in graph")
+	}
+	if len(parentDeps) != 0 {
+		t.Errorf("expected component.parent to have no dependencies, got %v", parentDeps)
+	}
+	childDeps, ok := graph.GetOk(mustAbsComponent("component.child"))
+	if !ok {
+		t.Fatal("component.child not in graph")
+	}
+	if len(childDeps) != 1 || childDeps[0].String() != "component.parent" {
+		t.Errorf("expected component.child to depend only on component.parent, got %v", childDeps)
+	}
+}
+
+func TestMigrateConfig_ForEachModuleResources(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
+
+	lock := depsfile.NewLocks()
+	lock.SetProvider(
+		addrs.NewDefaultProvider("testing"),
+		providerreqs.MustParseVersion("0.0.0"),
+		providerreqs.MustParseVersionConstraints("=0.0.0"),
+		providerreqs.PreferredHashes([]providerreqs.Hash{}),
+	)
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	// The child module was expanded with for_each, so both the module
+	// instance and the resource instance carry string keys.
+	childModule := state.EnsureModule(addrs.RootModuleInstance.Child("child_mod", addrs.StringKey("a")))
+	childModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "child_data",
+		}.Instance(addrs.StringKey("x")),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState:         state,
+		Config:                cfg,
+		PerInstanceComponents: true,
+	}
+	resources := map[string]string{
+		"testing_resource.data": "parent",
 	}
 	modules := map[string]string{
 		"child_mod": "child",
@@ -607,7 +4346,9 @@ func TestMigrateConfig_NestedModuleResources(t *testing.T) {
 			Schema:             stacks_testing_provider.TestingResourceSchema,
 		},
 		{
-			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.parent.testing_resource.another[0]"),
+			// The module instance key becomes the component instance key, and
+			// the resource instance key is retained as-is.
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject(`component.child["a"].testing_resource.child_data["x"]`),
 			NewStateSrc: &states.ResourceInstanceObjectSrc{
 				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
 					"id":    "foo",
@@ -619,21 +4360,137 @@ func TestMigrateConfig_NestedModuleResources(t *testing.T) {
 			ProviderConfigAddr: mustDefaultRootProvider("testing"),
 			Schema:             stacks_testing_provider.TestingResourceSchema,
 		},
+	}
+	expectedComponents := []*stackstate.AppliedChangeComponentInstance{
 		{
-			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.parent.testing_resource.another[1]"),
-			NewStateSrc: &states.ResourceInstanceObjectSrc{
-				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
-					"id":    "foo",
-					"value": "hello",
-				}),
-				Status:  states.ObjectReady,
-				Private: nil,
+			ComponentAddr:         mustAbsComponent("component.parent"),
+			ComponentInstanceAddr: mustAbsComponentInstance("component.parent"),
+			OutputValues: map[addrs.OutputValue]cty.Value{
+				{Name: "id"}: cty.DynamicVal,
 			},
-			ProviderConfigAddr: mustDefaultRootProvider("testing"),
-			Schema:             stacks_testing_provider.TestingResourceSchema,
+			InputVariables: map[addrs.InputVariable]cty.Value{
+				{Name: "id"}:    cty.DynamicVal,
+				{Name: "input"}: cty.DynamicVal,
+			},
+			Dependents: collections.NewSet(mustAbsComponent("component.child")),
 		},
 		{
-			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.child.testing_resource.child_data"),
+			ComponentAddr:         mustAbsComponent("component.child"),
+			ComponentInstanceAddr: mustAbsComponentInstance(`component.child["a"]`),
+			OutputValues: map[addrs.OutputValue]cty.Value{
+				{Name: "id"}: cty.DynamicVal,
+			},
+			InputVariables: map[addrs.InputVariable]cty.Value{
+				{Name: "id"}:    cty.DynamicVal,
+				{Name: "input"}: cty.DynamicVal,
+			},
+			Dependencies: collections.NewSet(mustAbsComponent("component.parent")),
+		},
+	}
+
+	var expDiags, gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		switch c := change.(type) {
+		case *stackstate.AppliedChangeResourceInstanceObject:
+			appliedResources = append(appliedResources, c)
+		case *stackstate.AppliedChangeComponentInstance:
+			appliedComponents = append(appliedComponents, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
+		t.Errorf("unexpected diagnostics:\n%s", diff)
+	}
+
+	if diff := compareAppliedChanges(t, expectedResources, appliedResources, func(c *stackstate.AppliedChangeResourceInstanceObject) string {
+		return c.ResourceInstanceObjectAddr.String()
+	}); diff != "" {
+		t.Errorf("unexpected applied resource changes:\n%s", diff)
+	}
+
+	if diff := compareAppliedChanges(t, expectedComponents, appliedComponents, func(c *stackstate.AppliedChangeComponentInstance) string {
+		return c.ComponentAddr.String()
+	}); diff != "" {
+		t.Errorf("unexpected applied component changes:\n%s", diff)
+	}
+}
+
+func TestMigrateConfig_CollapsedModuleInstances(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
+
+	lock := depsfile.NewLocks()
+	lock.SetProvider(
+		addrs.NewDefaultProvider("testing"),
+		providerreqs.MustParseVersion("0.0.0"),
+		providerreqs.MustParseVersionConstraints("=0.0.0"),
+		providerreqs.PreferredHashes([]providerreqs.Hash{}),
+	)
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	// Two instances of the same child module, whose resource instance keys
+	// don't collide, so they can safely collapse into a single component
+	// instance.
+	for moduleKey, resourceKey := range map[string]string{"a": "x", "b": "y"} {
+		childModule := state.EnsureModule(addrs.RootModuleInstance.Child("child_mod", addrs.StringKey(moduleKey)))
+		childModule.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "testing_resource",
+				Name: "child_data",
+			}.Instance(addrs.StringKey(resourceKey)),
+			&states.ResourceInstanceObjectSrc{
+				Status: states.ObjectReady,
+				AttrsJSON: []byte(`{
+					"id": "foo",
+					"value": "hello"
+				}`),
+			},
+			mustDefaultRootProvider("testing"),
+		)
+	}
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+		// PerInstanceComponents is left false, so the module instance keys
+		// are dropped.
+	}
+	resources := map[string]string{
+		"testing_resource.data": "parent",
+	}
+	modules := map[string]string{
+		"child_mod": "child",
+	}
+
+	appliedResources := []*stackstate.AppliedChangeResourceInstanceObject{}
+	appliedComponents := []*stackstate.AppliedChangeComponentInstance{}
+	expectedResources := []*stackstate.AppliedChangeResourceInstanceObject{
+		{
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.parent.testing_resource.data"),
 			NewStateSrc: &states.ResourceInstanceObjectSrc{
 				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
 					"id":    "foo",
@@ -646,7 +4503,7 @@ func TestMigrateConfig_NestedModuleResources(t *testing.T) {
 			Schema:             stacks_testing_provider.TestingResourceSchema,
 		},
 		{
-			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.child.testing_resource.another_child_data[0]"),
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject(`component.child.testing_resource.child_data["x"]`),
 			NewStateSrc: &states.ResourceInstanceObjectSrc{
 				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
 					"id":    "foo",
@@ -659,7 +4516,7 @@ func TestMigrateConfig_NestedModuleResources(t *testing.T) {
 			Schema:             stacks_testing_provider.TestingResourceSchema,
 		},
 		{
-			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.child.testing_resource.another_child_data[1]"),
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject(`component.child.testing_resource.child_data["y"]`),
 			NewStateSrc: &states.ResourceInstanceObjectSrc{
 				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
 					"id":    "foo",
@@ -686,6 +4543,8 @@ func TestMigrateConfig_NestedModuleResources(t *testing.T) {
 			Dependents: collections.NewSet(mustAbsComponent("component.child")),
 		},
 		{
+			// Both module instances collapse into this single component
+			// instance.
 			ComponentAddr:         mustAbsComponent("component.child"),
 			ComponentInstanceAddr: mustAbsComponentInstance("component.child"),
 			OutputValues: map[addrs.OutputValue]cty.Value{
@@ -695,36 +4554,114 @@ func TestMigrateConfig_NestedModuleResources(t *testing.T) {
 				{Name: "id"}:    cty.DynamicVal,
 				{Name: "input"}: cty.DynamicVal,
 			},
-			Dependencies: collections.NewSet(mustAbsComponent("component.parent")),
+			Dependencies: collections.NewSet(mustAbsComponent("component.parent")),
+		},
+	}
+
+	var expDiags, gotDiags tfdiags.Diagnostics
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		switch c := change.(type) {
+		case *stackstate.AppliedChangeResourceInstanceObject:
+			appliedResources = append(appliedResources, c)
+		case *stackstate.AppliedChangeComponentInstance:
+			appliedComponents = append(appliedComponents, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
+		t.Errorf("unexpected diagnostics:\n%s", diff)
+	}
+
+	if diff := compareAppliedChanges(t, expectedResources, appliedResources, func(c *stackstate.AppliedChangeResourceInstanceObject) string {
+		return c.ResourceInstanceObjectAddr.String()
+	}); diff != "" {
+		t.Errorf("unexpected applied resource changes:\n%s", diff)
+	}
+
+	if diff := compareAppliedChanges(t, expectedComponents, appliedComponents, func(c *stackstate.AppliedChangeComponentInstance) string {
+		return c.ComponentAddr.String()
+	}); diff != "" {
+		t.Errorf("unexpected applied component changes:\n%s", diff)
+	}
+}
+
+func TestMigrateConfig_CollapsedModuleInstanceCollision(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
+
+	state := states.NewState()
+	// Two instances of the same child module whose resource instance
+	// addresses are identical, so collapsing them into a single component
+	// instance would have the later write silently replace the earlier one.
+	for _, moduleKey := range []string{"a", "b"} {
+		childModule := state.EnsureModule(addrs.RootModuleInstance.Child("child_mod", addrs.StringKey(moduleKey)))
+		childModule.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "testing_resource",
+				Name: "child_data",
+			}.Instance(addrs.NoKey),
+			&states.ResourceInstanceObjectSrc{
+				Status: states.ObjectReady,
+				AttrsJSON: []byte(`{
+					"id": "foo",
+					"value": "hello"
+				}`),
+			},
+			mustDefaultRootProvider("testing"),
+		)
+	}
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
 		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+	modules := map[string]string{
+		"child_mod": "child",
 	}
 
-	var expDiags, gotDiags tfdiags.Diagnostics
-	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
-		switch c := change.(type) {
-		case *stackstate.AppliedChangeResourceInstanceObject:
-			appliedResources = append(appliedResources, c)
-		case *stackstate.AppliedChangeComponentInstance:
-			appliedComponents = append(appliedComponents, c)
+	var gotDiags tfdiags.Diagnostics
+	mig.Migrate(nil, modules, func(change stackstate.AppliedChange) {
+		if _, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			t.Errorf("unexpected resource instance change for %v", change)
 		}
 	}, func(diagnostic tfdiags.Diagnostic) {
 		gotDiags = append(gotDiags, diagnostic)
 	})
 
-	if diff := cmp.Diff(expDiags, gotDiags); diff != "" {
+	var expDiags tfdiags.Diagnostics
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Error,
+		"Duplicate resource instance address",
+		"Resource instances module.child_mod[\"a\"].testing_resource.child_data, module.child_mod[\"b\"].testing_resource.child_data would all be migrated to component.child.testing_resource.child_data, and all but one of them would be lost. Set PerInstanceComponents to keep each module instance as its own component instance, or update the mappings so that each resource instance keeps a distinct address.",
+	))
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
 		t.Errorf("unexpected diagnostics:\n%s", diff)
 	}
 
-	if diff := compareAppliedChanges(t, expectedResources, appliedResources, func(c *stackstate.AppliedChangeResourceInstanceObject) string {
-		return c.ResourceInstanceObjectAddr.String()
-	}); diff != "" {
-		t.Errorf("unexpected applied resource changes:\n%s", diff)
+	// With PerInstanceComponents set, the same state fans out into distinct
+	// component instances and there is no collision.
+	mig.PerInstanceComponents = true
+	gotDiags = nil
+	applied := 0
+	mig.Migrate(nil, modules, func(change stackstate.AppliedChange) {
+		if _, ok := change.(*stackstate.AppliedChangeResourceInstanceObject); ok {
+			applied++
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+	if len(gotDiags) != 0 {
+		t.Errorf("unexpected diagnostics: %s", gotDiags.Err())
 	}
-
-	if diff := compareAppliedChanges(t, expectedComponents, appliedComponents, func(c *stackstate.AppliedChangeComponentInstance) string {
-		return c.ComponentAddr.String()
-	}); diff != "" {
-		t.Errorf("unexpected applied component changes:\n%s", diff)
+	if applied != 2 {
+		t.Errorf("expected 2 applied resource changes, got %d", applied)
 	}
 }
 
@@ -905,6 +4842,11 @@ func TestMigrateConfig_MissingConfigResource(t *testing.T) {
 			Detail:   "Resource \"testing_resource.for_child\" not found in root module.",
 		},
 	})
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Output value not migrated",
+		"The previous state records output \"output\" for the root module, but the target component does not declare a matching output, so its value was not migrated.",
+	))
 	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
 		switch c := change.(type) {
 		case *stackstate.AppliedChangeResourceInstanceObject:
@@ -1013,6 +4955,25 @@ func TestMigrateConfig_MissingMappingForStateResource(t *testing.T) {
 		mustDefaultRootProvider("testing"),
 	)
 
+	// This module isn't in the modules mapping either, so its resource is
+	// another orphan that should appear in the same consolidated warning.
+	childModule := state.EnsureModule(addrs.RootModuleInstance.Child("child_mod", addrs.NoKey))
+	childModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "child_data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
 	mig := Migration{
 		Providers: map[addrs.Provider]providers.Factory{
 			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
@@ -1090,13 +5051,16 @@ func TestMigrateConfig_MissingMappingForStateResource(t *testing.T) {
 	}
 
 	var expDiags, gotDiags tfdiags.Diagnostics
-	expDiags = expDiags.Append(hcl.Diagnostics{
-		{
-			Severity: hcl.DiagError,
-			Summary:  "Resource not found",
-			Detail:   "Resource \"testing_resource.for_child\" not found in mapping.",
-		},
-	})
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Resources not migrated",
+		"The following resources do not map to any component and were not migrated:\n  - module.child_mod.testing_resource.child_data\n  - testing_resource.for_child\n\nTerraform will no longer manage these resources. Add them to the resource or module mappings if they should be part of the stack state.",
+	))
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Output value not migrated",
+		"The previous state records output \"output\" for the root module, but the target component does not declare a matching output, so its value was not migrated.",
+	))
 	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
 		switch c := change.(type) {
 		case *stackstate.AppliedChangeResourceInstanceObject:
@@ -1275,6 +5239,11 @@ func TestMigrateConfigDependsOn(t *testing.T) {
 	}
 
 	var expDiags, gotDiags tfdiags.Diagnostics
+	expDiags = expDiags.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Output value not migrated",
+		"The previous state records output \"output\" for the root module, but the target component does not declare a matching output, so its value was not migrated.",
+	))
 	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
 		switch c := change.(type) {
 		case *stackstate.AppliedChangeResourceInstanceObject:
@@ -1298,29 +5267,15 @@ func TestMigrateConfigDependsOn(t *testing.T) {
 		t.Fatalf("unexpected applied resource changes:\n%s", diff)
 	}
 
-	if diff := cmp.Diff(expDiags, gotDiags); diff != "" {
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
 		t.Fatalf("unexpected diagnostics:\n%s", diff)
 	}
 }
 
-func TestMigrate_UnsupportedComponentRef(t *testing.T) {
-	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-depends-on"))
-
-	lock := depsfile.NewLocks()
-	lock.SetProvider(
-		addrs.NewDefaultProvider("testing"),
-		providerreqs.MustParseVersion("0.0.0"),
-		providerreqs.MustParseVersionConstraints("=0.0.0"),
-		providerreqs.PreferredHashes([]providerreqs.Hash{}),
-	)
-
-	state := states.BuildState(func(ss *states.SyncState) {
-		ss.SetOutputValue(addrs.AbsOutputValue{
-			Module:      addrs.RootModuleInstance,
-			OutputValue: addrs.OutputValue{Name: "output"},
-		}, cty.StringVal("before"), false)
-	})
+func TestMigrate_ModuleDependsOn(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "module-depends-on"))
 
+	state := states.NewState()
 	rootModule := state.RootModule()
 	rootModule.SetResourceInstanceCurrent(
 		addrs.Resource{
@@ -1329,44 +5284,93 @@ func TestMigrate_UnsupportedComponentRef(t *testing.T) {
 			Name: "data",
 		}.Instance(addrs.NoKey),
 		&states.ResourceInstanceObjectSrc{
-			Status: states.ObjectReady,
-			AttrsJSON: []byte(`{
-				"id": "foo",
-				"value": "depends_test"
-			}`),
-		},
-		mustDefaultRootProvider("testing"),
-	)
-	rootModule.SetResourceInstanceCurrent(
-		addrs.Resource{
-			Mode: addrs.ManagedResourceMode,
-			Type: "testing_resource",
-			Name: "second",
-		}.Instance(addrs.NoKey),
-		&states.ResourceInstanceObjectSrc{
-			Status: states.ObjectReady,
-			AttrsJSON: []byte(`{
-				"id": "foo",
-				"value": "depends_test"
-			}`),
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"root","value":"hello"}`),
 		},
 		mustDefaultRootProvider("testing"),
 	)
-	rootModule.SetResourceInstanceCurrent(
-		addrs.Resource{
-			Mode: addrs.ManagedResourceMode,
-			Type: "testing_resource",
-			Name: "third",
-		}.Instance(addrs.NoKey),
-		&states.ResourceInstanceObjectSrc{
-			Status: states.ObjectReady,
-			AttrsJSON: []byte(`{
-				"id": "foo",
-				"value": "depends_test"
-			}`),
+	for _, moduleName := range []string{"a", "b"} {
+		childModule := state.EnsureModule(addrs.RootModuleInstance.Child(moduleName, addrs.NoKey))
+		childModule.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "testing_resource",
+				Name: "child_data",
+			}.Instance(addrs.NoKey),
+			&states.ResourceInstanceObjectSrc{
+				Status:    states.ObjectReady,
+				AttrsJSON: []byte(`{"id":"` + moduleName + `","value":"hello"}`),
+			},
+			mustDefaultRootProvider("testing"),
+		)
+	}
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
 		},
-		mustDefaultRootProvider("testing"),
-	)
+		PreviousState: state,
+		Config:        cfg,
+	}
+
+	resources := map[string]string{"testing_resource.data": "root"}
+	modules := map[string]string{"a": "a", "b": "b"}
+
+	appliedComponents := make(map[string]*stackstate.AppliedChangeComponentInstance)
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		if c, ok := change.(*stackstate.AppliedChangeComponentInstance); ok {
+			appliedComponents[c.ComponentAddr.String()] = c
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		t.Errorf("unexpected diagnostic: %s", diagnostic.Description().Summary)
+	})
+
+	if len(appliedComponents) != 3 {
+		t.Fatalf("expected 3 component instances, got %d", len(appliedComponents))
+	}
+
+	// module.a carries depends_on = [module.b] in the source configuration,
+	// so the corresponding components must record the same ordering.
+	a := appliedComponents["component.a"]
+	if diff := cmp.Diff(collections.NewSet(mustAbsComponent("component.b")), a.Dependencies, collections.CmpOptions); diff != "" {
+		t.Errorf("wrong dependencies for component.a\n%s", diff)
+	}
+	b := appliedComponents["component.b"]
+	if diff := cmp.Diff(collections.NewSet(mustAbsComponent("component.a")), b.Dependents, collections.CmpOptions); diff != "" {
+		t.Errorf("wrong dependents for component.b\n%s", diff)
+	}
+}
+
+func TestMigrate_EmbeddedStackComponent(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-depends-on"))
+
+	state := states.BuildState(func(ss *states.SyncState) {
+		ss.SetOutputValue(addrs.AbsOutputValue{
+			Module:      addrs.RootModuleInstance,
+			OutputValue: addrs.OutputValue{Name: "output"},
+		}, cty.StringVal("before"), false)
+	})
+
+	rootModule := state.RootModule()
+	for _, name := range []string{"data", "second", "third"} {
+		rootModule.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "testing_resource",
+				Name: name,
+			}.Instance(addrs.NoKey),
+			&states.ResourceInstanceObjectSrc{
+				Status: states.ObjectReady,
+				AttrsJSON: []byte(`{
+					"id": "foo",
+					"value": "depends_test"
+				}`),
+			},
+			mustDefaultRootProvider("testing"),
+		)
+	}
 
 	mig := Migration{
 		Providers: map[addrs.Provider]providers.Factory{
@@ -1378,6 +5382,10 @@ func TestMigrate_UnsupportedComponentRef(t *testing.T) {
 		Config:        cfg,
 	}
 
+	// testing_resource.third is mapped into a component that lives in an
+	// embedded stack, so the depends_on reference to stack.embedded in
+	// component.second must produce a dependency edge across the stack
+	// boundary.
 	resources := map[string]string{
 		"testing_resource.data":   "component.first",
 		"testing_resource.second": "component.second",
@@ -1405,7 +5413,19 @@ func TestMigrate_UnsupportedComponentRef(t *testing.T) {
 				{Name: "input"}: cty.DynamicVal,
 				{Name: "id"}:    cty.DynamicVal,
 			},
-			Dependencies: collections.NewSet(mustAbsComponent("component.first")),
+			Dependencies: collections.NewSet(
+				mustAbsComponent("component.first"),
+				mustAbsComponent("stack.embedded.component.self"),
+			),
+		},
+		{
+			ComponentAddr:         mustAbsComponent("stack.embedded.component.self"),
+			ComponentInstanceAddr: mustAbsComponentInstance("stack.embedded.component.self"),
+			InputVariables: map[addrs.InputVariable]cty.Value{
+				{Name: "input"}: cty.DynamicVal,
+				{Name: "id"}:    cty.DynamicVal,
+			},
+			Dependents: collections.NewSet(mustAbsComponent("component.second")),
 		},
 	}
 
@@ -1436,16 +5456,153 @@ func TestMigrate_UnsupportedComponentRef(t *testing.T) {
 			ProviderConfigAddr: mustDefaultRootProvider("testing"),
 			Schema:             stacks_testing_provider.TestingResourceSchema,
 		},
+		{
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("stack.embedded.component.self.testing_resource.third"),
+			NewStateSrc: &states.ResourceInstanceObjectSrc{
+				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
+					"id":    "foo",
+					"value": "depends_test",
+				}),
+				Status:  states.ObjectReady,
+				Private: nil,
+			},
+			ProviderConfigAddr: mustDefaultRootProvider("testing"),
+			Schema:             stacks_testing_provider.TestingResourceSchema,
+		},
 	}
 
 	var gotDiags tfdiags.Diagnostics
-	expDiags := tfdiags.Diagnostics{}.Append(hcl.Diagnostics{
+	expDiags := tfdiags.Diagnostics{}.Append(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Output value not migrated",
+		"The previous state records output \"output\" for the root module, but the target component does not declare a matching output, so its value was not migrated.",
+	))
+
+	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
+		switch c := change.(type) {
+		case *stackstate.AppliedChangeResourceInstanceObject:
+			appliedResources = append(appliedResources, c)
+		case *stackstate.AppliedChangeComponentInstance:
+			appliedComponents = append(appliedComponents, c)
+		}
+	}, func(diagnostic tfdiags.Diagnostic) {
+		gotDiags = append(gotDiags, diagnostic)
+	})
+
+	if diff := compareAppliedChanges(t, expectedComponents, appliedComponents, func(c *stackstate.AppliedChangeComponentInstance) string {
+		return c.ComponentAddr.String()
+	}); diff != "" {
+		t.Fatalf("unexpected applied component changes:\n%s", diff)
+	}
+
+	if diff := compareAppliedChanges(t, expectedResources, appliedResources, func(c *stackstate.AppliedChangeResourceInstanceObject) string {
+		return c.ResourceInstanceObjectAddr.String()
+	}); diff != "" {
+		t.Fatalf("unexpected applied resource changes:\n%s", diff)
+	}
+
+	if diff := cmp.Diff(expDiags.ForRPC(), gotDiags.ForRPC(), tfdiags.DiagnosticComparer); diff != "" {
+		t.Fatalf("unexpected diagnostics:\n%s", diff)
+	}
+}
+
+func TestMigrate_NestedEmbeddedStacks(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "two-level-embedded"))
+
+	state := states.BuildState(func(ss *states.SyncState) {})
+
+	rootModule := state.RootModule()
+	for _, name := range []string{"data", "second"} {
+		rootModule.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "testing_resource",
+				Name: name,
+			}.Instance(addrs.NoKey),
+			&states.ResourceInstanceObjectSrc{
+				Status: states.ObjectReady,
+				AttrsJSON: []byte(`{
+					"id": "foo",
+					"value": "nested_test"
+				}`),
+			},
+			mustDefaultRootProvider("testing"),
+		)
+	}
+
+	mig := Migration{
+		Providers: map[addrs.Provider]providers.Factory{
+			addrs.NewDefaultProvider("testing"): func() (providers.Interface, error) {
+				return stacks_testing_provider.NewProvider(t), nil
+			},
+		},
+		PreviousState: state,
+		Config:        cfg,
+	}
+
+	// component.top declares depends_on = [stack.middle], and the only
+	// component in that subtree lives two stack levels down, so dependency
+	// inference must walk through stack.middle into stack.leaf.
+	resources := map[string]string{
+		"testing_resource.data":   "component.top",
+		"testing_resource.second": "stack.middle.stack.leaf.component.deep",
+	}
+	modules := map[string]string{}
+
+	appliedResources := []*stackstate.AppliedChangeResourceInstanceObject{}
+	appliedComponents := []*stackstate.AppliedChangeComponentInstance{}
+	expectedComponents := []*stackstate.AppliedChangeComponentInstance{
 		{
-			Severity: hcl.DiagError,
-			Summary:  "Invalid component instance",
-			Detail:   "Only root component instances are allowed, got \"stack.embedded.component.self\"",
+			ComponentAddr:         mustAbsComponent("component.top"),
+			ComponentInstanceAddr: mustAbsComponentInstance("component.top"),
+			InputVariables: map[addrs.InputVariable]cty.Value{
+				{Name: "input"}: cty.DynamicVal,
+				{Name: "id"}:    cty.DynamicVal,
+			},
+			Dependencies: collections.NewSet(mustAbsComponent("stack.middle.stack.leaf.component.deep")),
 		},
-	})
+		{
+			ComponentAddr:         mustAbsComponent("stack.middle.stack.leaf.component.deep"),
+			ComponentInstanceAddr: mustAbsComponentInstance("stack.middle.stack.leaf.component.deep"),
+			InputVariables: map[addrs.InputVariable]cty.Value{
+				{Name: "input"}: cty.DynamicVal,
+				{Name: "id"}:    cty.DynamicVal,
+			},
+			Dependents: collections.NewSet(mustAbsComponent("component.top")),
+		},
+	}
+
+	expectedResources := []*stackstate.AppliedChangeResourceInstanceObject{
+		{
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("component.top.testing_resource.data"),
+			NewStateSrc: &states.ResourceInstanceObjectSrc{
+				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
+					"id":    "foo",
+					"value": "nested_test",
+				}),
+				Status:  states.ObjectReady,
+				Private: nil,
+			},
+			ProviderConfigAddr: mustDefaultRootProvider("testing"),
+			Schema:             stacks_testing_provider.TestingResourceSchema,
+		},
+		{
+			ResourceInstanceObjectAddr: mustAbsResourceInstanceObject("stack.middle.stack.leaf.component.deep.testing_resource.second"),
+			NewStateSrc: &states.ResourceInstanceObjectSrc{
+				AttrsJSON: mustMarshalJSONAttrs(map[string]interface{}{
+					"id":    "foo",
+					"value": "nested_test",
+				}),
+				Status:  states.ObjectReady,
+				Private: nil,
+			},
+			ProviderConfigAddr: mustDefaultRootProvider("testing"),
+			Schema:             stacks_testing_provider.TestingResourceSchema,
+		},
+	}
+
+	var gotDiags tfdiags.Diagnostics
+	var expDiags tfdiags.Diagnostics
 
 	mig.Migrate(resources, modules, func(change stackstate.AppliedChange) {
 		switch c := change.(type) {
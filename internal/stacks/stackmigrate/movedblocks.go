@@ -0,0 +1,106 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package stackmigrate
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-slug/sourceaddrs"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/configs"
+	"github.com/hashicorp/terraform/internal/tfdiags"
+)
+
+// movedBlock is a single moved statement in the document produced by
+// [Migration.GenerateMovedBlocks].
+type movedBlock struct {
+	// From is the address of the resource in the previous state and To its
+	// new address inside a component of the stack.
+	From string
+	To   string
+}
+
+// GenerateMovedBlocks renders the moved statements that correspond to the
+// given mappings as HCL source, without emitting any applied changes, so
+// that operators have a ready-to-commit configuration artifact recording how
+// addresses changed alongside the state migration itself.
+//
+// The output is deterministic: statements are grouped by component instance,
+// with the components and the statements within each component both ordered
+// by address, so the artifact stays diffable across runs.
+//
+// Resources that cannot be mapped are reported through the returned
+// diagnostics in the same way that Migrate would report them.
+func (m *Migration) GenerateMovedBlocks(resources map[string]string, modules map[string]string) ([]byte, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	migration := &migration{
+		Migration: m,
+		emitDiag:  func(diagnostic tfdiags.Diagnostic) { diags = append(diags, diagnostic) },
+		parser:    configs.NewSourceBundleParser(m.Config.Sources),
+		configs:   make(map[sourceaddrs.FinalSource]*configs.Config),
+	}
+
+	blocks := make(map[string][]movedBlock)
+	var orphaned []string
+	for _, resource := range migration.stateResources() {
+		if resource.Addr.Resource.Mode == addrs.DataResourceMode {
+			// Data sources aren't tracked in stack state, so there is no
+			// address move to record for them.
+			continue
+		}
+
+		target, moreDiags := migration.search(resource.Addr, resources, modules)
+		if moreDiags.HasErrors() {
+			migration.emitDiags(moreDiags)
+			continue
+		}
+		if target == nil {
+			orphaned = append(orphaned, resource.Addr.String())
+			continue
+		}
+
+		component := target.AbsResource.Component
+		blocks[component.String()] = append(blocks[component.String()], movedBlock{
+			From: resource.Addr.String(),
+			To:   fmt.Sprintf("%s.%s", component, target.AbsResource.Item),
+		})
+	}
+
+	if len(orphaned) > 0 {
+		// The same consolidated warning that Migrate would produce for
+		// resources that no mapping captured.
+		sort.Strings(orphaned)
+		migration.emitDiag(tfdiags.Sourceless(tfdiags.Warning, "Resources not migrated", fmt.Sprintf("The following resources do not map to any component and were not migrated:\n  - %s\n\nTerraform will no longer manage these resources. Add them to the resource or module mappings if they should be part of the stack state.", strings.Join(orphaned, "\n  - "))))
+	}
+
+	components := make([]string, 0, len(blocks))
+	for component := range blocks {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+
+	var buf bytes.Buffer
+	for _, component := range components {
+		group := blocks[component]
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].From < group[j].From
+		})
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "# %s\n", component)
+		for i, block := range group {
+			if i > 0 {
+				buf.WriteString("\n")
+			}
+			fmt.Fprintf(&buf, "moved {\n  from = %s\n  to   = %s\n}\n", block.From, block.To)
+		}
+	}
+	return buf.Bytes(), diags
+}
@@ -0,0 +1,120 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package stackmigrate
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/states"
+)
+
+func TestGenerateMovedBlocks(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-nested-module"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "another",
+		}.Instance(addrs.IntKey(0)),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "bar",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	childModule := state.EnsureModule(addrs.RootModuleInstance.Child("child_mod", addrs.NoKey))
+	childModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "child_data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "baz",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	mig := Migration{
+		PreviousState: state,
+		Config:        cfg,
+	}
+	resources := map[string]string{
+		"testing_resource.data":    "parent",
+		"testing_resource.another": "parent",
+	}
+	modules := map[string]string{
+		"child_mod": "child",
+	}
+
+	src, diags := mig.GenerateMovedBlocks(resources, modules)
+	if diags.HasErrors() {
+		t.Fatalf("unexpected diagnostics: %s", diags.Err())
+	}
+
+	want := `# component.child
+moved {
+  from = module.child_mod.testing_resource.child_data
+  to   = component.child.testing_resource.child_data
+}
+
+# component.parent
+moved {
+  from = testing_resource.another
+  to   = component.parent.testing_resource.another
+}
+
+moved {
+  from = testing_resource.data
+  to   = component.parent.testing_resource.data
+}
+`
+	if diff := cmp.Diff(want, string(src)); diff != "" {
+		t.Errorf("wrong moved blocks:\n%s", diff)
+	}
+
+	// A resource that no mapping captures gets no moved block, and the same
+	// consolidated warning that Migrate would produce.
+	src, diags = mig.GenerateMovedBlocks(map[string]string{
+		"testing_resource.data": "parent",
+	}, modules)
+	if got, want := len(diags), 1; got != want {
+		t.Fatalf("expected %d diagnostic, got %d: %v", want, got, diags)
+	}
+	if got, want := diags[0].Description().Summary, "Resources not migrated"; got != want {
+		t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+	}
+	if len(src) == 0 {
+		t.Errorf("expected moved blocks for the mapped resources, got none")
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package stackmigrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-slug/sourceaddrs"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/configs"
+	"github.com/hashicorp/terraform/internal/stacks/stackaddrs"
+	"github.com/hashicorp/terraform/internal/states"
+	"github.com/hashicorp/terraform/internal/tfdiags"
+)
+
+// PlanJSONFormatVersion is the value of the format_version field in the
+// document produced by [Migration.PlanJSON]. Consumers should check this
+// before relying on the rest of the document, and we should increment it on
+// any breaking change to the format.
+const PlanJSONFormatVersion = "1.0"
+
+// migrationPlanJSON is the top-level structure of the document produced by
+// [Migration.PlanJSON].
+type migrationPlanJSON struct {
+	FormatVersion           string                    `json:"format_version"`
+	ResourceInstanceObjects []migrationPlanObjectJSON `json:"resource_instance_objects"`
+}
+
+// migrationPlanObjectJSON describes where a single resource instance object
+// from the previous state would end up after migration.
+type migrationPlanObjectJSON struct {
+	// PreviousAddress is the address of the resource instance in the
+	// previous state, and PreviousModule the module it belonged to, with an
+	// empty string representing the root module.
+	PreviousAddress string `json:"previous_address"`
+	PreviousModule  string `json:"previous_module,omitempty"`
+
+	// NewAddress is the full address of the resource instance within the
+	// stack, and Component the component instance it was assigned to.
+	NewAddress string `json:"new_address"`
+	Component  string `json:"component"`
+
+	// DeposedKey is set only for deposed objects.
+	DeposedKey string `json:"deposed,omitempty"`
+
+	ProviderConfig string `json:"provider_config"`
+	Status         string `json:"status"`
+}
+
+// PlanJSON describes what [Migration.Migrate] would do with the given
+// mappings as a stable JSON document, without emitting any applied changes,
+// so that a migration can be reviewed before it runs.
+//
+// Resources that cannot be migrated are reported through the returned
+// diagnostics in the same way that Migrate would report them.
+func (m *Migration) PlanJSON(resources map[string]string, modules map[string]string) ([]byte, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	migration := &migration{
+		Migration: m,
+		emitDiag:  func(diagnostic tfdiags.Diagnostic) { diags = append(diags, diagnostic) },
+		parser:    configs.NewSourceBundleParser(m.Config.Sources),
+		configs:   make(map[sourceaddrs.FinalSource]*configs.Config),
+	}
+
+	doc := &migrationPlanJSON{
+		FormatVersion:           PlanJSONFormatVersion,
+		ResourceInstanceObjects: []migrationPlanObjectJSON{},
+	}
+
+	var orphaned []string
+	droppedDeposed := make(map[string]int)
+	for _, resource := range migration.stateResources() {
+		if resource.Addr.Resource.Mode == addrs.DataResourceMode {
+			migration.emitDiag(tfdiags.Sourceless(tfdiags.Warning, "Data source not migrated", fmt.Sprintf("Data resource %s was not migrated. Data sources are read again during the next plan of the target component, so their previous results are not persisted in stack state.", resource.Addr)))
+			continue
+		}
+
+		target, moreDiags := migration.search(resource.Addr, resources, modules)
+		if moreDiags.HasErrors() {
+			migration.emitDiags(moreDiags)
+			continue
+		}
+		if target == nil {
+			orphaned = append(orphaned, resource.Addr.String())
+			continue
+		}
+
+		if moreDiags := migration.loadConfig(target); moreDiags.HasErrors() {
+			migration.emitDiags(moreDiags)
+			continue
+		}
+		component := target.AbsResource.Component
+		componentAddr := target.AbsResource.Item
+
+		// Migrate records the remapped provider configuration, so the plan
+		// document must show the same address.
+		providerConfig := resource.ProviderConfig
+		if remapped, ok := m.ProviderConfigRemap.GetOk(providerConfig); ok {
+			providerConfig = remapped
+		}
+
+		// Only the instances that the configured Filter admits would be
+		// migrated, so only those belong in the plan document.
+		for instanceKey, instance := range migration.includedInstances(resource) {
+			instanceAddr := stackaddrs.AbsResourceInstance{
+				Component: component,
+				Item:      componentAddr.Instance(instanceKey),
+			}
+			previousAddr := resource.Addr.Instance(instanceKey)
+
+			if instance.Current != nil {
+				doc.ResourceInstanceObjects = append(doc.ResourceInstanceObjects, migrationPlanObjectJSON{
+					PreviousAddress: previousAddr.String(),
+					PreviousModule:  resource.Addr.Module.String(),
+					NewAddress:      instanceAddr.String(),
+					Component:       component.String(),
+					ProviderConfig:  providerConfig.String(),
+					Status:          planObjectStatus(instance.Current),
+				})
+			}
+
+			if m.DropDeposed {
+				// Migrate would leave the deposed objects behind, so they
+				// don't belong in the plan document either; they're counted
+				// for the same summary warning instead.
+				if n := len(instance.Deposed); n > 0 {
+					droppedDeposed[resource.Addr.String()] += n
+				}
+				continue
+			}
+
+			for deposedKey, deposed := range instance.Deposed {
+				doc.ResourceInstanceObjects = append(doc.ResourceInstanceObjects, migrationPlanObjectJSON{
+					PreviousAddress: previousAddr.String(),
+					PreviousModule:  resource.Addr.Module.String(),
+					NewAddress:      instanceAddr.String(),
+					Component:       component.String(),
+					DeposedKey:      deposedKey.String(),
+					ProviderConfig:  providerConfig.String(),
+					Status:          planObjectStatus(deposed),
+				})
+			}
+		}
+	}
+
+	if len(orphaned) > 0 {
+		// The same consolidated warning that Migrate would produce for
+		// resources that no mapping captured.
+		sort.Strings(orphaned)
+		migration.emitDiag(tfdiags.Sourceless(tfdiags.Warning, "Resources not migrated", fmt.Sprintf("The following resources do not map to any component and were not migrated:\n  - %s\n\nTerraform will no longer manage these resources. Add them to the resource or module mappings if they should be part of the stack state.", strings.Join(orphaned, "\n  - "))))
+	}
+
+	if len(droppedDeposed) > 0 {
+		lines := make([]string, 0, len(droppedDeposed))
+		for addr, count := range droppedDeposed {
+			lines = append(lines, fmt.Sprintf("%s (%d)", addr, count))
+		}
+		sort.Strings(lines)
+		migration.emitDiag(tfdiags.Sourceless(tfdiags.Warning, "Deposed objects dropped", fmt.Sprintf("Deposed objects recorded against the following resources were not migrated, as requested:\n  - %s\n\nEach entry shows how many deposed objects were dropped. The stack state contains only current objects.", strings.Join(lines, "\n  - "))))
+	}
+
+	// The state iteration order isn't stable, so we sort the entries to keep
+	// the document diffable.
+	sort.Slice(doc.ResourceInstanceObjects, func(i, j int) bool {
+		a, b := doc.ResourceInstanceObjects[i], doc.ResourceInstanceObjects[j]
+		if a.NewAddress != b.NewAddress {
+			return a.NewAddress < b.NewAddress
+		}
+		return a.DeposedKey < b.DeposedKey
+	})
+
+	src, err := json.Marshal(doc)
+	if err != nil {
+		// Should never happen, since the document contains only marshalable
+		// types.
+		diags = diags.Append(tfdiags.Sourceless(tfdiags.Error, "Failed to serialize migration plan", fmt.Sprintf("Error while serializing the migration plan to JSON: %s.", err)))
+		return nil, diags
+	}
+	return src, diags
+}
+
+func planObjectStatus(obj *states.ResourceInstanceObjectSrc) string {
+	switch {
+	case obj == nil:
+		return "unknown"
+	case obj.Status == states.ObjectTainted:
+		return "tainted"
+	case obj.Status == states.ObjectReady:
+		return "ready"
+	default:
+		return "unknown"
+	}
+}
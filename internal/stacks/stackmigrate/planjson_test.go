@@ -0,0 +1,265 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package stackmigrate
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/states"
+)
+
+func TestPlanJSON(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectTainted,
+			AttrsJSON: []byte(`{
+				"id": "foo",
+				"value": "hello"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	deposedKey := states.NewDeposedKey()
+	rootModule.SetResourceInstanceDeposed(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		deposedKey,
+		&states.ResourceInstanceObjectSrc{
+			Status: states.ObjectReady,
+			AttrsJSON: []byte(`{
+				"id": "old",
+				"value": "old"
+			}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	mig := Migration{
+		PreviousState: state,
+		Config:        cfg,
+	}
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	src, diags := mig.PlanJSON(resources, nil)
+	if diags.HasErrors() {
+		t.Fatalf("unexpected diagnostics: %s", diags.Err())
+	}
+
+	var got migrationPlanJSON
+	if err := json.Unmarshal(src, &got); err != nil {
+		t.Fatalf("failed to parse plan document: %s", err)
+	}
+
+	want := migrationPlanJSON{
+		FormatVersion: PlanJSONFormatVersion,
+		ResourceInstanceObjects: []migrationPlanObjectJSON{
+			{
+				PreviousAddress: "testing_resource.data",
+				NewAddress:      "component.self.testing_resource.data",
+				Component:       "component.self",
+				ProviderConfig:  mustDefaultRootProvider("testing").String(),
+				Status:          "tainted",
+			},
+			{
+				PreviousAddress: "testing_resource.data",
+				NewAddress:      "component.self.testing_resource.data",
+				Component:       "component.self",
+				DeposedKey:      deposedKey.String(),
+				ProviderConfig:  mustDefaultRootProvider("testing").String(),
+				Status:          "ready",
+			},
+		},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("wrong plan document:\n%s", diff)
+	}
+}
+
+func TestPlanJSON_dropDeposed(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	rootModule.SetResourceInstanceDeposed(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		states.NewDeposedKey(),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"old","value":"old"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+	mig := Migration{
+		PreviousState: state,
+		Config:        cfg,
+		DropDeposed:   true,
+	}
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	src, diags := mig.PlanJSON(resources, nil)
+	if diags.HasErrors() {
+		t.Fatalf("unexpected error diagnostics: %s", diags.Err())
+	}
+	var got migrationPlanJSON
+	if err := json.Unmarshal(src, &got); err != nil {
+		t.Fatalf("failed to parse plan document: %s", err)
+	}
+
+	// The deposed object would be dropped by the migration, so only the
+	// current object may appear in the plan document.
+	if len(got.ResourceInstanceObjects) != 1 {
+		t.Fatalf("expected 1 object in the plan document, got %d", len(got.ResourceInstanceObjects))
+	}
+	if key := got.ResourceInstanceObjects[0].DeposedKey; key != "" {
+		t.Errorf("unexpected deposed object in the plan document: %s", key)
+	}
+
+	if len(diags) != 1 {
+		t.Fatalf("expected 1 warning, got %d: %s", len(diags), diags.Err())
+	}
+	if got, want := diags[0].Description().Summary, "Deposed objects dropped"; got != want {
+		t.Errorf("wrong warning summary: got %q, want %q", got, want)
+	}
+	if detail := diags[0].Description().Detail; !strings.Contains(detail, "testing_resource.data (1)") {
+		t.Errorf("warning detail does not list the dropped object:\n%s", detail)
+	}
+}
+
+func TestPlanJSON_providerConfigRemap(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	rootModule.SetResourceInstanceCurrent(
+		addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "testing_resource",
+			Name: "data",
+		}.Instance(addrs.NoKey),
+		&states.ResourceInstanceObjectSrc{
+			Status:    states.ObjectReady,
+			AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+		},
+		mustDefaultRootProvider("testing"),
+	)
+
+	remapped := mustDefaultRootProvider("testing")
+	remapped.Alias = "backup"
+
+	mig := Migration{
+		PreviousState: state,
+		Config:        cfg,
+		ProviderConfigRemap: addrs.MakeMap(
+			addrs.MakeMapElem(mustDefaultRootProvider("testing"), remapped),
+		),
+	}
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	src, diags := mig.PlanJSON(resources, nil)
+	if diags.HasErrors() {
+		t.Fatalf("unexpected diagnostics: %s", diags.Err())
+	}
+	var got migrationPlanJSON
+	if err := json.Unmarshal(src, &got); err != nil {
+		t.Fatalf("failed to parse plan document: %s", err)
+	}
+
+	if len(got.ResourceInstanceObjects) != 1 {
+		t.Fatalf("expected 1 object in the plan document, got %d", len(got.ResourceInstanceObjects))
+	}
+	// The document must show the provider configuration that the migrated
+	// state would record, not the one from the previous state.
+	if got, want := got.ResourceInstanceObjects[0].ProviderConfig, remapped.String(); got != want {
+		t.Errorf("wrong provider config: got %q, want %q", got, want)
+	}
+}
+
+func TestPlanJSON_filter(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.NewState()
+	rootModule := state.RootModule()
+	for _, key := range []addrs.InstanceKey{addrs.IntKey(0), addrs.IntKey(1)} {
+		rootModule.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "testing_resource",
+				Name: "data",
+			}.Instance(key),
+			&states.ResourceInstanceObjectSrc{
+				Status:    states.ObjectReady,
+				AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+			},
+			mustDefaultRootProvider("testing"),
+		)
+	}
+	mig := Migration{
+		PreviousState: state,
+		Config:        cfg,
+		// Only the first instance is migrated, so only it may appear in the
+		// plan document.
+		Filter: func(instance addrs.AbsResourceInstance) bool {
+			return instance.Resource.Key == addrs.IntKey(0)
+		},
+	}
+	resources := map[string]string{
+		"testing_resource.data": "self",
+	}
+
+	src, diags := mig.PlanJSON(resources, nil)
+	if diags.HasErrors() {
+		t.Fatalf("unexpected diagnostics: %s", diags.Err())
+	}
+	var got migrationPlanJSON
+	if err := json.Unmarshal(src, &got); err != nil {
+		t.Fatalf("failed to parse plan document: %s", err)
+	}
+
+	if len(got.ResourceInstanceObjects) != 1 {
+		t.Fatalf("expected 1 object in the plan document, got %d", len(got.ResourceInstanceObjects))
+	}
+	if got, want := got.ResourceInstanceObjects[0].PreviousAddress, "testing_resource.data[0]"; got != want {
+		t.Errorf("wrong previous address: got %q, want %q", got, want)
+	}
+}
@@ -4,10 +4,18 @@
 package stackmigrate
 
 import (
+	"encoding/json"
 	"fmt"
+	"path"
+	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+
 	"github.com/hashicorp/terraform/internal/addrs"
 	"github.com/hashicorp/terraform/internal/collections"
 	"github.com/hashicorp/terraform/internal/configs"
@@ -15,6 +23,7 @@ import (
 	"github.com/hashicorp/terraform/internal/stacks/stackaddrs"
 	"github.com/hashicorp/terraform/internal/stacks/stackconfig"
 	"github.com/hashicorp/terraform/internal/stacks/stackstate"
+	"github.com/hashicorp/terraform/internal/states"
 	"github.com/hashicorp/terraform/internal/tfdiags"
 )
 
@@ -24,8 +33,11 @@ type stackResource struct {
 	// The unexpanded resource address
 	AbsResource stackaddrs.AbsResource
 
-	// The address of the terraform module that the resource belongs to.
-	ContainingModule addrs.Module
+	// The address of the terraform module that the resource belongs to,
+	// both as a config address and as the specific instance from the
+	// previous state.
+	ContainingModule         addrs.Module
+	ContainingModuleInstance addrs.ModuleInstance
 
 	// The stack and component configuration for the resource.
 	StackConfig     *stackconfig.Stack
@@ -55,9 +67,58 @@ func (m *migration) migrateResources(resources map[string]string, modules map[st
 			components.Put(instance, collections.NewSet[*stackResource]())
 		}
 		components.Get(instance).Add(resource)
+		m.result.SourceModules.Put(instance, resource.ContainingModuleInstance)
+	}
+
+	// instancesProcessed records progress for the given number of resource
+	// instances, whether or not they were actually emitted, so that progress
+	// reports still reach the total when resources are skipped.
+	instancesProcessed := func(count int) {
+		m.progress.MigratedResourceInstances += count
+		m.reportProgress()
 	}
 
+	// orphaned collects the addresses of resources that no mapping captured,
+	// so that all of them can be reported together in a single warning after
+	// the loop rather than one diagnostic per resource.
+	var orphaned []string
+
+	// droppedDeposed counts, per resource, the deposed objects that were
+	// skipped because DropDeposed is set, so that all of the drops can be
+	// summarized in a single warning after the loop.
+	droppedDeposed := make(map[string]int)
+
+	// droppedDependencies counts, per resource, the object-level dependencies
+	// that referred to resources migrated into other components. Those are
+	// represented by the component dependency edges instead, and we summarize
+	// the drops in a single note after the loop.
+	droppedDependencies := make(map[string]int)
+
 	for _, resource := range m.stateResources() {
+		if m.stopped || m.checkCancelled() {
+			// An earlier error or a cancellation already halted the
+			// migration.
+			break
+		}
+
+		// Resource instances excluded by the caller's filter are left
+		// entirely untouched, including any reporting about them.
+		included := m.includedInstances(resource)
+		if len(included) == 0 {
+			continue
+		}
+
+		// Data resources are deliberately not migrated: stack state only
+		// persists managed resources, and the target component will read its
+		// data sources again during the next plan anyway. We skip them
+		// loudly rather than silently so that users know what happened to
+		// them.
+		if resource.Addr.Resource.Mode == addrs.DataResourceMode {
+			m.emitDiag(tfdiags.Sourceless(tfdiags.Warning, "Data source not migrated", fmt.Sprintf("Data resource %s was not migrated. Data sources are read again during the next plan of the target component, so their previous results are not persisted in stack state.", resource.Addr)))
+			instancesProcessed(len(included))
+			continue
+		}
+
 		// check if the state resource has been requested for migration,
 		// either by being in the resources map, or its module being in the modules map.
 		// The returned target builds a new address for the resource within the
@@ -66,6 +127,15 @@ func (m *migration) migrateResources(resources map[string]string, modules map[st
 		if diags.HasErrors() {
 			// if there are errors, we can't migrate this resource.
 			m.emitDiags(diags)
+			instancesProcessed(len(included))
+			continue
+		}
+		if target == nil {
+			// No mapping captured this resource, so it would be silently
+			// dropped from the stack state. We remember it here and report
+			// all orphaned resources together once the loop is done.
+			orphaned = append(orphaned, resource.Addr.String())
+			instancesProcessed(len(included))
 			continue
 		}
 
@@ -76,49 +146,221 @@ func (m *migration) migrateResources(resources map[string]string, modules map[st
 		diags = m.loadConfig(target)
 		if diags.HasErrors() {
 			m.emitDiags(diags)
+			instancesProcessed(len(included))
 			continue
 		}
+		// The provider may have renamed this resource type since the previous
+		// state was recorded, in which case the caller can ask for the
+		// instances to be recorded under the new name. This must happen
+		// before we consult the target module configuration, which declares
+		// the new name.
+		resourceType := resource.Addr.Resource.Type
+		renamed := false
+		if newType, ok := m.ResourceTypeRename[resourceType]; ok {
+			resourceType = newType
+			target.AbsResource.Item.Resource.Type = newType
+			renamed = true
+		}
+
 		component := target.AbsResource.Component
 		componentAddr := target.AbsResource.Item
 
 		trackComponent(target)
 
+		// A components-only migration records which component instance each
+		// resource would land in, so the component changes and their
+		// dependency graph can be emitted below, but leaves the resource
+		// objects themselves for a later run.
+		if m.ComponentsOnly {
+			instancesProcessed(len(included))
+			continue
+		}
+
 		// retrieve the provider that was uses to create the resource instance.
 		providerAddr, provider, diags := m.getOwningProvider(target)
 		if diags.HasErrors() {
 			m.emitDiags(diags)
+			instancesProcessed(len(included))
 			continue
 		}
 
-		schema := provider.GetProviderSchema().SchemaForResourceType(resource.Addr.Resource.Mode, resource.Addr.Resource.Type)
+		// The prior state records which provider configuration each resource
+		// was created with, including any alias. If the configuration analysis
+		// above didn't resolve an alias but the state used an aliased
+		// configuration, we preserve the alias from the state so the resource
+		// maps to the matching provider configuration inside the target
+		// component rather than collapsing to the default configuration.
+		if alias := resource.ProviderConfig.Alias; alias != "" && providerAddr.Alias == "" {
+			providerAddr.Alias = alias
+
+			// The preserved alias must correspond to a provider configuration
+			// slot that the target component actually accepts, or provider
+			// resolution would fail at apply time instead. Collapsing the
+			// resource onto the default configuration would only hide that
+			// failure, so we refuse to migrate the resource while the user can
+			// still fix the component's providers block or the mapping.
+			localName, ok := target.StackConfig.RequiredProviders.LocalNameForProvider(providerAddr.Provider)
+			if ok {
+				_, ok = target.ComponentConfig.ProviderConfigs[addrs.LocalProviderConfig{
+					LocalName: localName,
+					Alias:     alias,
+				}]
+			}
+			if !ok {
+				m.emitDiag(tfdiags.Sourceless(tfdiags.Error, "Provider configuration not found in component", fmt.Sprintf("Resource %s was created with provider configuration %s, but component %q does not accept a provider configuration for that alias. Add a matching entry to the component's providers argument before migrating.", resource.Addr, resource.ProviderConfig, component.Item.Component.Name)))
+				instancesProcessed(len(included))
+				continue
+			}
+		}
+
+		// The caller may want the migrated objects to refer to this provider
+		// configuration under a different address in the destination stack.
+		if remapped, ok := m.ProviderConfigRemap.GetOk(providerAddr); ok {
+			providerAddr = remapped
+		}
+
+		// The resource type may have been renamed or removed in the current
+		// version of the provider, in which case we cannot migrate any of
+		// its instances.
+		schema := provider.GetProviderSchema().SchemaForResourceType(resource.Addr.Resource.Mode, resourceType)
 		if schema.Body == nil {
 			m.emitDiags(diags.Append(&hcl.Diagnostic{
 				Severity: hcl.DiagError,
 				Summary:  "Resource type not found",
-				Detail:   fmt.Sprintf("Resource type %s not found in provider schema.", resource.Addr.Resource.Type),
+				Detail:   fmt.Sprintf("Resource %s has type %s, which is not found in the schema for provider %s.", resource.Addr, resourceType, providerAddr.Provider.ForDisplay()),
 				Subject:  target.ModuleConfig.SourceAddrRange.Ptr(),
 			}))
+			instancesProcessed(len(included))
 			continue
 		}
 
-		for instanceKey, instance := range resource.Instances {
+		if renamed {
+			// The rename assumes the new type's schema is compatible with the
+			// data recorded under the old name. The attribute data is carried
+			// over unchanged either way, but we warn when it doesn't decode
+			// cleanly so the user can verify the result before planning.
+			for _, instance := range included {
+				if instance.Current == nil || len(instance.Current.AttrsJSON) == 0 {
+					continue
+				}
+				if _, err := ctyjson.Unmarshal(instance.Current.AttrsJSON, schema.Body.ImpliedType()); err != nil {
+					m.emitDiag(tfdiags.Sourceless(tfdiags.Warning, "Renamed resource type may be incompatible", fmt.Sprintf("The state for %s does not decode cleanly against the schema of its renamed type %q: %s. Verify the migrated data before planning.", resource.Addr, resourceType, err)))
+					break
+				}
+			}
+		}
+
+		// A stored schema version newer than the provider's current one means
+		// the state was written by a newer provider release, and this provider
+		// has no upgrade path for it. The data is still carried over
+		// unchanged, but we warn so the user can select a newer provider
+		// version before planning against the migrated state.
+		for _, instance := range included {
+			if instance.Current != nil && instance.Current.SchemaVersion > uint64(schema.Version) {
+				m.emitDiag(tfdiags.Sourceless(tfdiags.Warning, "Resource schema version is newer than provider", fmt.Sprintf("The state for %s was created with schema version %d, but the selected provider for %s only supports version %d. The state was migrated unchanged; select a provider version at least as new as the one that created the state before planning.", resource.Addr, instance.Current.SchemaVersion, resourceType, schema.Version)))
+				break
+			}
+		}
+
+		for instanceKey, instance := range included {
 			instanceAddr := stackaddrs.AbsResourceInstance{
 				Component: component,
 				Item:      componentAddr.Instance(instanceKey),
 			}
+			previousAddr := resource.Addr.Instance(instanceKey)
+
+			current, moreDiags := m.upgradeIdentity(previousAddr, provider, instance.Current, schema)
+			if moreDiags.HasErrors() {
+				m.emitDiags(moreDiags)
+				instancesProcessed(1)
+				continue
+			}
+			current, moreDiags = m.coerceAttrs(previousAddr, current, schema)
+			if moreDiags.HasErrors() {
+				m.emitDiags(moreDiags)
+				instancesProcessed(1)
+				continue
+			}
+			current, moreDiags = m.stripAttrs(previousAddr, current, resourceType, schema)
+			if moreDiags.HasErrors() {
+				m.emitDiags(moreDiags)
+				instancesProcessed(1)
+				continue
+			}
+			current, moreDiags = m.nullWriteOnlyAttrs(previousAddr, current, schema)
+			if moreDiags.HasErrors() {
+				m.emitDiags(moreDiags)
+				instancesProcessed(1)
+				continue
+			}
+			var dropped int
+			current, dropped = m.rewriteDependencies(current, target, resources, modules)
+			droppedDependencies[resource.Addr.String()] += dropped
+
+			if m.FlagTaintedForReplacement && current != nil && current.Status == states.ObjectTainted {
+				m.result.TaintedResources.Add(instanceAddr)
+			}
 
-			m.emit(&stackstate.AppliedChangeResourceInstanceObject{
+			change := &stackstate.AppliedChangeResourceInstanceObject{
 				ResourceInstanceObjectAddr: stackaddrs.AbsResourceInstanceObject{
 					Component: instanceAddr.Component,
 					Item:      instanceAddr.Item.DeposedObject(addrs.NotDeposed),
 				},
-				NewStateSrc:        instance.Current,
+				NewStateSrc:        current,
 				ProviderConfigAddr: providerAddr,
 				Schema:             schema,
-			})
+			}
+			if !m.alreadyMigrated(change) {
+				m.emit(change)
+				m.migratedObjects = append(m.migratedObjects, change.ResourceInstanceObjectAddr)
+				m.result.ResourceInstanceObjects++
+				m.result.Providers.Add(providerAddr.Provider)
+			}
+
+			if m.DropDeposed {
+				// The caller considers the deposed objects stale junk, so we
+				// deliberately leave them behind and account for them in the
+				// summary warning after the loop.
+				if n := len(instance.Deposed); n > 0 {
+					droppedDeposed[resource.Addr.String()] += n
+				}
+				instancesProcessed(1)
+				continue
+			}
 
 			for deposedKey, deposed := range instance.Deposed {
-				m.emit(&stackstate.AppliedChangeResourceInstanceObject{
+				deposed, moreDiags := m.upgradeIdentity(previousAddr, provider, deposed, schema)
+				if moreDiags.HasErrors() {
+					m.emitDiags(moreDiags)
+					continue
+				}
+				deposed, moreDiags = m.coerceAttrs(previousAddr, deposed, schema)
+				if moreDiags.HasErrors() {
+					m.emitDiags(moreDiags)
+					continue
+				}
+				deposed, moreDiags = m.stripAttrs(previousAddr, deposed, resourceType, schema)
+				if moreDiags.HasErrors() {
+					m.emitDiags(moreDiags)
+					continue
+				}
+				deposed, moreDiags = m.nullWriteOnlyAttrs(previousAddr, deposed, schema)
+				if moreDiags.HasErrors() {
+					m.emitDiags(moreDiags)
+					continue
+				}
+				var dropped int
+				deposed, dropped = m.rewriteDependencies(deposed, target, resources, modules)
+				droppedDependencies[resource.Addr.String()] += dropped
+
+				// Deposed keys are preserved from the previous state by
+				// default so that objects can be correlated across the
+				// migration.
+				if m.RegenerateDeposedKeys {
+					deposedKey = states.NewDeposedKey()
+				}
+
+				change := &stackstate.AppliedChangeResourceInstanceObject{
 					ResourceInstanceObjectAddr: stackaddrs.AbsResourceInstanceObject{
 						Component: instanceAddr.Component,
 						Item:      instanceAddr.Item.DeposedObject(deposedKey),
@@ -126,13 +368,441 @@ func (m *migration) migrateResources(resources map[string]string, modules map[st
 					NewStateSrc:        deposed,
 					ProviderConfigAddr: providerAddr,
 					Schema:             schema,
-				})
+				}
+				if !m.alreadyMigrated(change) {
+					m.emit(change)
+					m.migratedObjects = append(m.migratedObjects, change.ResourceInstanceObjectAddr)
+					m.result.ResourceInstanceObjects++
+					m.result.DeposedObjects++
+				}
 			}
+
+			instancesProcessed(1)
+		}
+
+		// An instance that still has both a current and a deposed object
+		// usually means a create_before_destroy replacement was interrupted.
+		// That situation carries over into the stack state just fine, but we
+		// flag it so the user isn't surprised when the next apply finishes
+		// the replacement. When deposed objects are dropped entirely the
+		// summary warning after the loop covers them instead.
+		if !m.DropDeposed {
+			for _, instance := range included {
+				if instance.Current != nil && len(instance.Deposed) > 0 {
+					m.emitDiag(tfdiags.Sourceless(tfdiags.Warning, "Resource has a pending replacement", fmt.Sprintf("Resource %s has both a current and a deposed object, which usually means a create_before_destroy replacement was interrupted. Both objects were migrated, and the next plan and apply of the stack may complete the replacement by destroying the deposed object.", resource.Addr)))
+					break
+				}
+			}
+		}
+	}
+
+	if len(droppedDeposed) > 0 {
+		lines := make([]string, 0, len(droppedDeposed))
+		for addr, count := range droppedDeposed {
+			lines = append(lines, fmt.Sprintf("%s (%d)", addr, count))
+		}
+		sort.Strings(lines)
+		m.emitDiag(tfdiags.Sourceless(tfdiags.Warning, "Deposed objects dropped", fmt.Sprintf("Deposed objects recorded against the following resources were not migrated, as requested:\n  - %s\n\nEach entry shows how many deposed objects were dropped. The stack state contains only current objects.", strings.Join(lines, "\n  - "))))
+	}
+
+	droppedDepLines := make([]string, 0, len(droppedDependencies))
+	for addr, count := range droppedDependencies {
+		if count > 0 {
+			droppedDepLines = append(droppedDepLines, fmt.Sprintf("%s (%d)", addr, count))
 		}
 	}
+	if len(droppedDepLines) > 0 {
+		sort.Strings(droppedDepLines)
+		m.emitDiag(tfdiags.Sourceless(tfdiags.Warning, "Cross-component resource dependencies dropped", fmt.Sprintf("Dependencies recorded against the following resources refer to resources that were migrated into other components:\n  - %s\n\nEach entry shows how many dependencies were dropped from the resource's objects. The ordering between components is preserved by the component dependency metadata instead.", strings.Join(droppedDepLines, "\n  - "))))
+	}
+
+	if len(orphaned) > 0 {
+		sort.Strings(orphaned)
+		m.emitDiag(tfdiags.Sourceless(tfdiags.Warning, "Resources not migrated", fmt.Sprintf("The following resources do not map to any component and were not migrated:\n  - %s\n\nTerraform will no longer manage these resources. Add them to the resource or module mappings if they should be part of the stack state.", strings.Join(orphaned, "\n  - "))))
+	}
+
 	return components
 }
 
+// upgradeIdentity brings the stored resource identity of the given object up
+// to the provider's current identity schema version, calling the provider's
+// upgrade path when the stored version is older, so that we never copy stale
+// identity data into the stack state. Objects without a stored identity, and
+// resource types without an identity schema, pass through unchanged.
+func (m *migration) upgradeIdentity(addr addrs.AbsResourceInstance, provider providers.Interface, src *states.ResourceInstanceObjectSrc, schema providers.Schema) (*states.ResourceInstanceObjectSrc, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+	if src == nil || len(src.IdentityJSON) == 0 || schema.Identity == nil {
+		return src, diags
+	}
+
+	if src.IdentitySchemaVersion > uint64(schema.IdentityVersion) {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Resource instance managed by newer provider version",
+			fmt.Sprintf("The identity for %s was created by a newer provider version than is currently selected. Upgrade the provider to work with this state.", addr),
+		))
+		return nil, diags
+	}
+	if src.IdentitySchemaVersion == uint64(schema.IdentityVersion) {
+		// The version matches, but the stored data may still disagree with
+		// the identity schema, such as when a provider changed its identity
+		// attributes without bumping the version. We validate here so that a
+		// structurally broken identity is reported during migration rather
+		// than failing obscurely on a later plan.
+		moreDiags := m.validateIdentity(addr, src, schema)
+		if moreDiags.HasErrors() {
+			return nil, diags.Append(moreDiags)
+		}
+		return src, diags
+	}
+
+	resp := provider.UpgradeResourceIdentity(providers.UpgradeResourceIdentityRequest{
+		TypeName:        addr.Resource.Resource.Type,
+		Version:         int64(src.IdentitySchemaVersion),
+		RawIdentityJSON: src.IdentityJSON,
+	})
+	diags = diags.Append(resp.Diagnostics)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	newIdentity := resp.UpgradedIdentity
+	if !newIdentity.IsWhollyKnown() {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid resource identity upgrade",
+			fmt.Sprintf("The provider upgraded the identity for %s from a previous version, but produced an invalid result: The returned identity contains unknown values.", addr),
+		))
+		return nil, diags
+	}
+	if errs := newIdentity.Type().TestConformance(schema.Identity.ImpliedType()); len(errs) > 0 {
+		for _, err := range errs {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid resource identity upgrade",
+				fmt.Sprintf("The provider upgraded the identity for %s from a previous version, but produced an invalid result: %s.", addr, tfdiags.FormatError(err)),
+			))
+		}
+		return nil, diags
+	}
+
+	new, err := src.CompleteIdentityUpgrade(newIdentity, schema)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to encode result of resource identity upgrade",
+			fmt.Sprintf("Failed to encode state for %s after resource identity schema upgrade: %s.", addr, tfdiags.FormatError(err)),
+		))
+		return nil, diags
+	}
+	return new, diags
+}
+
+// validateIdentity checks that the stored identity data of the given object
+// is structurally consistent with the current identity schema, reporting each
+// attribute that is present in the data but not in the schema, or required by
+// the schema but absent from the data, by name. Data that passes the
+// attribute check is also decoded against the schema's implied type to catch
+// values of the wrong type.
+func (m *migration) validateIdentity(addr addrs.AbsResourceInstance, src *states.ResourceInstanceObjectSrc, schema providers.Schema) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(src.IdentityJSON, &raw); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid resource identity",
+			fmt.Sprintf("The stored identity for %s cannot be decoded: %s.", addr, err),
+		))
+		return diags
+	}
+
+	var extra, missing []string
+	for name := range raw {
+		if _, ok := schema.Identity.Attributes[name]; !ok {
+			extra = append(extra, name)
+		}
+	}
+	for name := range schema.Identity.Attributes {
+		if _, ok := raw[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(extra)
+	sort.Strings(missing)
+	for _, name := range extra {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid resource identity",
+			fmt.Sprintf("The stored identity for %s contains attribute %q, which is not part of the current identity schema for its resource type. The provider version selected now may be too different from the one that created the state.", addr, name),
+		))
+	}
+	for _, name := range missing {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid resource identity",
+			fmt.Sprintf("The stored identity for %s is missing attribute %q, which the current identity schema for its resource type requires. The provider version selected now may be too different from the one that created the state.", addr, name),
+		))
+	}
+	if diags.HasErrors() {
+		return diags
+	}
+
+	if _, err := ctyjson.Unmarshal(src.IdentityJSON, schema.Identity.ImpliedType()); err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Invalid resource identity",
+			fmt.Sprintf("The stored identity for %s does not conform to the current identity schema for its resource type: %s.", addr, err),
+		))
+	}
+	return diags
+}
+
+// coerceAttrs re-encodes the stored attribute data of the given object
+// against the current resource type schema when CoerceToCurrentSchema is set,
+// so that attributes added to the schema since the state was written appear
+// as nulls rather than being absent from the stack state. Stored data that
+// doesn't fit the current schema is reported as an error.
+func (m *migration) coerceAttrs(addr addrs.AbsResourceInstance, src *states.ResourceInstanceObjectSrc, schema providers.Schema) (*states.ResourceInstanceObjectSrc, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+	if !m.CoerceToCurrentSchema || src == nil || len(src.AttrsJSON) == 0 {
+		return src, diags
+	}
+
+	ty := schema.Body.ImpliedType()
+	val, err := ctyjson.Unmarshal(src.AttrsJSON, ty)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Incompatible resource state",
+			fmt.Sprintf("The stored state for %s cannot be decoded using the current schema for its resource type: %s. The provider version selected now may be too different from the one that created the state.", addr, err),
+		))
+		return nil, diags
+	}
+	buf, err := ctyjson.Marshal(val, ty)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to re-encode resource state",
+			fmt.Sprintf("Failed to encode state for %s against the current schema: %s.", addr, err),
+		))
+		return nil, diags
+	}
+
+	new := *src
+	new.AttrsJSON = buf
+	return &new, diags
+}
+
+// stripAttrs nulls out the attribute paths that StripAttributes lists for the
+// given resource type in the stored attribute data of the given object, so
+// that values which should never have been persisted are not carried into the
+// stack state. The data is decoded and re-encoded against the resource type
+// schema, so the result always remains schema-conformant; a path that doesn't
+// match the schema is reported as an error instead of being silently ignored.
+func (m *migration) stripAttrs(addr addrs.AbsResourceInstance, src *states.ResourceInstanceObjectSrc, resourceType string, schema providers.Schema) (*states.ResourceInstanceObjectSrc, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+	paths := m.StripAttributes[resourceType]
+	if len(paths) == 0 || src == nil || len(src.AttrsJSON) == 0 {
+		return src, diags
+	}
+
+	ty := schema.Body.ImpliedType()
+	val, err := ctyjson.Unmarshal(src.AttrsJSON, ty)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Incompatible resource state",
+			fmt.Sprintf("The stored state for %s cannot be decoded using the current schema for its resource type: %s. The provider version selected now may be too different from the one that created the state.", addr, err),
+		))
+		return nil, diags
+	}
+
+	for _, path := range paths {
+		stripped, err := nullOutAttrPath(val, strings.Split(path, "."))
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Cannot strip attribute from resource state",
+				fmt.Sprintf("Cannot strip attribute path %q from the state for %s: %s.", path, addr, err),
+			))
+			return nil, diags
+		}
+		val = stripped
+	}
+
+	buf, err := ctyjson.Marshal(val, ty)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to re-encode resource state",
+			fmt.Sprintf("Failed to encode state for %s after stripping attributes: %s.", addr, err),
+		))
+		return nil, diags
+	}
+
+	new := *src
+	new.AttrsJSON = buf
+	return &new, diags
+}
+
+// nullWriteOnlyAttrs nulls out any value stored for a write-only attribute in
+// the stored attribute data of the given object when NullWriteOnlyAttributes
+// is set. Write-only attributes are ephemeral, so a persisted value is stale
+// by definition and must not be carried into the stack state; each object
+// that had values scrubbed is reported with a warning naming the affected
+// paths. Objects whose resource type declares no write-only attributes pass
+// through untouched without being re-encoded.
+func (m *migration) nullWriteOnlyAttrs(addr addrs.AbsResourceInstance, src *states.ResourceInstanceObjectSrc, schema providers.Schema) (*states.ResourceInstanceObjectSrc, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+	if !m.NullWriteOnlyAttributes || src == nil || len(src.AttrsJSON) == 0 || !schema.Body.ContainsWriteOnly() {
+		return src, diags
+	}
+
+	ty := schema.Body.ImpliedType()
+	val, err := ctyjson.Unmarshal(src.AttrsJSON, ty)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Incompatible resource state",
+			fmt.Sprintf("The stored state for %s cannot be decoded using the current schema for its resource type: %s. The provider version selected now may be too different from the one that created the state.", addr, err),
+		))
+		return nil, diags
+	}
+
+	var scrubbed []string
+	woPaths := cty.NewPathSet(schema.Body.WriteOnlyPaths(val, nil)...)
+	val, _ = cty.Transform(val, func(p cty.Path, v cty.Value) (cty.Value, error) {
+		if woPaths.Has(p) && !v.IsNull() {
+			scrubbed = append(scrubbed, tfdiags.FormatCtyPath(p))
+			return cty.NullVal(v.Type()), nil
+		}
+		return v, nil
+	})
+	if len(scrubbed) == 0 {
+		return src, diags
+	}
+	sort.Strings(scrubbed)
+
+	buf, err := ctyjson.Marshal(val, ty)
+	if err != nil {
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Failed to re-encode resource state",
+			fmt.Sprintf("Failed to encode state for %s after nulling write-only attributes: %s.", addr, err),
+		))
+		return nil, diags
+	}
+
+	m.emitDiag(tfdiags.Sourceless(
+		tfdiags.Warning,
+		"Stale write-only attribute values discarded",
+		fmt.Sprintf("The previous state stored values for the write-only attributes %s of %s. Write-only attribute values are ephemeral and should never have been persisted, so they were replaced with null in the migrated state.", strings.Join(scrubbed, ", "), addr),
+	))
+
+	new := *src
+	new.AttrsJSON = buf
+	return &new, diags
+}
+
+// nullOutAttrPath returns a copy of the given object value with the attribute
+// at the given path replaced by a null of that attribute's type. Null or
+// unknown containers along the path are returned unchanged, since there is
+// nothing stored beneath them to strip.
+func nullOutAttrPath(val cty.Value, path []string) (cty.Value, error) {
+	if val.IsNull() || !val.IsKnown() {
+		return val, nil
+	}
+	ty := val.Type()
+	name := path[0]
+	if !ty.IsObjectType() || !ty.HasAttribute(name) {
+		return val, fmt.Errorf("value of type %s has no attribute %q", ty.FriendlyName(), name)
+	}
+	attrs := val.AsValueMap()
+	if len(path) == 1 {
+		attrs[name] = cty.NullVal(ty.AttributeType(name))
+	} else {
+		stripped, err := nullOutAttrPath(attrs[name], path[1:])
+		if err != nil {
+			return val, err
+		}
+		attrs[name] = stripped
+	}
+	return cty.ObjectVal(attrs), nil
+}
+
+// rewriteDependencies carries the inter-resource dependencies recorded on the
+// given object across into the target component's namespace. A dependency on
+// a resource that migrated into the same component is rewritten to the
+// resource's address within the component, while dependencies on resources
+// that migrated elsewhere, or that no mapping captured, are dropped: the
+// ordering they describe is preserved by the component dependency edges
+// instead. The second result reports how many dependencies were dropped.
+func (m *migration) rewriteDependencies(src *states.ResourceInstanceObjectSrc, target *stackResource, resources map[string]string, modules map[string]string) (*states.ResourceInstanceObjectSrc, int) {
+	if src == nil || len(src.Dependencies) == 0 {
+		return src, 0
+	}
+
+	targetComponent := AbsComponent{
+		Stack: target.AbsResource.Component.Stack,
+		Item:  target.AbsResource.Component.Item.Component,
+	}
+
+	kept := make([]addrs.ConfigResource, 0, len(src.Dependencies))
+	dropped := 0
+	for _, dep := range src.Dependencies {
+		// Dependencies are recorded as config addresses, so we search with an
+		// unkeyed module instance path. The instance keys only influence which
+		// component instance receives a resource, never which component, and
+		// object-level dependencies care only about the latter.
+		module := addrs.RootModuleInstance
+		for _, step := range dep.Module {
+			module = module.Child(step, addrs.NoKey)
+		}
+		depTarget, diags := m.search(addrs.AbsResource{Module: module, Resource: dep.Resource}, resources, modules)
+		if diags.HasErrors() || depTarget == nil {
+			// Mapping problems are reported when the dependency's own
+			// resource is migrated, so here we just drop the entry.
+			dropped++
+			continue
+		}
+		depComponent := AbsComponent{
+			Stack: depTarget.AbsResource.Component.Stack,
+			Item:  depTarget.AbsResource.Component.Item.Component,
+		}
+		if depComponent.String() != targetComponent.String() {
+			dropped++
+			continue
+		}
+		kept = append(kept, addrs.ConfigResource{
+			Module:   depTarget.AbsResource.Item.Module.Module(),
+			Resource: dep.Resource,
+		})
+	}
+
+	new := *src
+	new.Dependencies = kept
+	return &new, dropped
+}
+
+// alreadyMigrated returns true if ExistingState already records an identical
+// object at the same address with the same provider configuration, in which
+// case a re-run of the migration doesn't need to emit it again.
+func (m *migration) alreadyMigrated(change *stackstate.AppliedChangeResourceInstanceObject) bool {
+	if m.ExistingState == nil {
+		return false
+	}
+	existing := m.ExistingState.ResourceInstanceObjectSrc(change.ResourceInstanceObjectAddr)
+	if existing == nil {
+		return false
+	}
+	if !reflect.DeepEqual(existing, change.NewStateSrc) {
+		return false
+	}
+	existingProvider := m.ExistingState.ResourceInstanceProviderConfig(change.ResourceInstanceObjectAddr)
+	return existingProvider.String() == change.ProviderConfigAddr.String()
+}
+
 // search searches for the state resource in the resource mappings and when found, converts and returns the relevant
 // stackResource.
 //
@@ -140,27 +810,30 @@ func (m *migration) migrateResources(resources map[string]string, modules map[st
 // For example, a resource with the address module.my_module.module.child.aws_instance.foo will be migrated to
 // component.my_component.module.child.aws_instance.foo if the corresponding map key is found.
 // E.g module.child.aws_instance.foo will be replaced with component.child.aws_instance.foo
+//
+// A nil result with no error diagnostics means that no mapping captured the
+// resource at all; the caller decides how to report such orphaned resources.
 func (m *migration) search(resource addrs.AbsResource, resources map[string]string, modules map[string]string) (*stackResource, tfdiags.Diagnostics) {
 	var diags tfdiags.Diagnostics
 	ret := &stackResource{
-		ContainingModule: resource.Module.Module(),
-	}
-
-	parseComponentInstance := func(target string) (Instance, tfdiags.Diagnostics) {
-		fullTarget := "component." + strings.TrimPrefix(target, "component.")
-		if len(strings.Split(fullTarget, ".")) > 2 {
-			diags = diags.Append(tfdiags.Sourceless(tfdiags.Error, "Invalid component instance", fmt.Sprintf("Only root component instances are allowed, got %q", target)))
-			return Instance{}, diags
-		}
-		inst, _, diags := stackaddrs.ParseAbsComponentInstanceStrOnly(fullTarget)
-		return inst, diags
+		ContainingModule:         resource.Module.Module(),
+		ContainingModuleInstance: resource.Module,
 	}
 
 	if resource.Module.IsRoot() {
 		target, ok := resources[resource.Resource.String()]
 		if !ok {
-			diags = diags.Append(tfdiags.Sourceless(tfdiags.Error, "Resource not found", fmt.Sprintf("Resource %q not found in mapping.", resource.Resource.String())))
-			return ret, diags
+			// An exact key always wins, but the mapping may also contain
+			// wildcard patterns that capture this resource.
+			var moreDiags tfdiags.Diagnostics
+			target, ok, moreDiags = matchResourcePattern(resource.String(), resources)
+			diags = diags.Append(moreDiags)
+			if moreDiags.HasErrors() {
+				return ret, diags
+			}
+		}
+		if !ok {
+			return nil, diags
 		}
 
 		inst, diags := parseComponentInstance(target)
@@ -178,13 +851,49 @@ func (m *migration) search(resource addrs.AbsResource, resources map[string]stri
 	// When found, we replace the module with the component instance, i.e
 	// a resource of module.child.aws_instance.foo will be replaced with
 	// component.child.aws_instance.foo
-	if targetComponent, ok := modules[resource.Module[0].Name]; ok {
+	targetComponent, ok := resources[resource.String()]
+	if !ok {
+		targetComponent, ok = modules[resource.Module[0].Name]
+	}
+	if !ok {
+		// Neither an exact resource key nor a module mapping captured this
+		// resource, so finally try wildcard patterns against its full
+		// address.
+		var moreDiags tfdiags.Diagnostics
+		targetComponent, ok, moreDiags = matchResourcePattern(resource.String(), resources)
+		diags = diags.Append(moreDiags)
+		if moreDiags.HasErrors() {
+			return ret, diags
+		}
+	}
+	if !ok && m.ComponentNamer != nil {
+		// No explicit mapping captured this module, so the caller-provided
+		// naming function decides which component receives it.
+		name := m.ComponentNamer(resource.Module)
+		if !hclsyntax.ValidIdentifier(name) {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid component name",
+				fmt.Sprintf("The component naming function produced %q for %s, which is not a valid component name.", name, resource.Module),
+			))
+			return ret, diags
+		}
+		targetComponent = name
+		ok = true
+	}
+	if ok {
 		inst, diags := parseComponentInstance(targetComponent)
 		if diags.HasErrors() {
 			return ret, diags
 		}
-		// retain the instance key
-		inst.Item.Key = resource.Module[0].InstanceKey
+		// retain the module instance key as the component instance key,
+		// unless the caller asked for all module instances to collapse into
+		// a single component instance. An explicit instance key in the
+		// mapping value itself always wins, since the caller has then routed
+		// this resource to a specific keyed instance deliberately.
+		if m.PerInstanceComponents && inst.Item.Key == addrs.NoKey {
+			inst.Item.Key = resource.Module[0].InstanceKey
+		}
 		ret.AbsResource = stackaddrs.AbsResource{
 			Component: inst,
 			Item: addrs.AbsResource{
@@ -194,8 +903,62 @@ func (m *migration) search(resource addrs.AbsResource, resources map[string]stri
 		}
 		return ret, diags
 	} else {
-		diags = diags.Append(tfdiags.Sourceless(tfdiags.Error, "Module not found", fmt.Sprintf("Module %q not found in mapping.", resource.Module[0].Name)))
-		return ret, diags
+		return nil, diags
+	}
+}
+
+// parseComponentInstance parses a mapping target as a component instance
+// address. Targets name a component in the root stack by default, but may
+// also address a component inside an embedded stack with a "stack." prefix
+// path, e.g. "stack.networking.component.vpc".
+//
+// Both [migration.search] and [Migration.ValidateMapping] parse targets
+// through this function, so that the up-front validation accepts exactly the
+// targets that a migration would.
+func parseComponentInstance(target string) (Instance, tfdiags.Diagnostics) {
+	fullTarget := target
+	if !strings.HasPrefix(fullTarget, "component.") && !strings.HasPrefix(fullTarget, "stack.") {
+		fullTarget = "component." + fullTarget
+	}
+	inst, _, diags := stackaddrs.ParseAbsComponentInstanceStrOnly(fullTarget)
+	return inst, diags
+}
+
+// matchResourcePattern finds a wildcard entry in the resources mapping that
+// matches the given resource address, using the same pattern syntax as
+// [path.Match], e.g. "aws_instance.*" or "module.network.*".
+//
+// Exact keys are handled by the caller and are never treated as patterns
+// here. An address matched by more than one pattern is ambiguous, since the
+// patterns could name different target components, so that is reported as an
+// error naming all of the matching patterns.
+func matchResourcePattern(addr string, resources map[string]string) (string, bool, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	var matched []string
+	for key := range resources {
+		if !strings.Contains(key, "*") {
+			continue
+		}
+		ok, err := path.Match(key, addr)
+		if err != nil {
+			diags = diags.Append(tfdiags.Sourceless(tfdiags.Error, "Invalid resource pattern", fmt.Sprintf("Resource mapping pattern %q is invalid: %s.", key, err)))
+			return "", false, diags
+		}
+		if ok {
+			matched = append(matched, key)
+		}
+	}
+
+	switch len(matched) {
+	case 0:
+		return "", false, diags
+	case 1:
+		return resources[matched[0]], true, diags
+	default:
+		sort.Strings(matched)
+		diags = diags.Append(tfdiags.Sourceless(tfdiags.Error, "Ambiguous resource mapping", fmt.Sprintf("Resource %q is matched by multiple wildcard patterns: %s.", addr, strings.Join(matched, ", "))))
+		return "", false, diags
 	}
 }
 
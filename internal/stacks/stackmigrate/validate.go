@@ -0,0 +1,100 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package stackmigrate
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform/internal/stacks/stackaddrs"
+	"github.com/hashicorp/terraform/internal/tfdiags"
+)
+
+// ValidateMapping checks the given resource and module mappings against the
+// previous state and the stack configuration, without performing any
+// migration.
+//
+// Every resource instance and module in the prior state that no mapping
+// captures is reported as a warning, since it would be silently skipped by
+// Migrate. Coverage is decided by the same matching that Migrate applies, so
+// exact keys, wildcard patterns, full child-module resource addresses and
+// module mappings all count, and invalid or ambiguous patterns are reported
+// as errors here just as the migration would report them. Every mapping
+// target that does not correspond to a component in the stack configuration
+// is also reported as an error. Callers can use this to verify a mapping
+// file before starting a migration.
+func (m *Migration) ValidateMapping(resources, modules map[string]string) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	migration := &migration{Migration: m}
+
+	seenModules := make(map[string]bool)
+	for _, module := range m.PreviousState.Modules {
+		for _, resource := range module.Resources {
+			target, moreDiags := migration.search(resource.Addr, resources, modules)
+			if moreDiags.HasErrors() {
+				// Invalid and ambiguous wildcard patterns, and malformed
+				// mapping targets, reported the same way Migrate would.
+				diags = diags.Append(moreDiags)
+				continue
+			}
+			if target != nil {
+				continue
+			}
+			if module.Addr.IsRoot() {
+				for key := range resource.Instances {
+					diags = diags.Append(tfdiags.Sourceless(
+						tfdiags.Warning,
+						"Resource not mapped",
+						fmt.Sprintf("Resource instance %q has no entry in the resources mapping and will not be migrated.", resource.Addr.Instance(key).String()),
+					))
+				}
+				continue
+			}
+
+			// Resources in child modules are mapped via the first step of
+			// their module path, so that's the part of the address we report
+			// here, once per module.
+			name := module.Addr[0].Name
+			if seenModules[name] {
+				continue
+			}
+			seenModules[name] = true
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Warning,
+				"Module not mapped",
+				fmt.Sprintf("Module %q has no entry in the modules mapping and will not be migrated.", name),
+			))
+		}
+	}
+
+	validateTarget := func(source, target string) {
+		// The same parsing that search() applies during migration, so that
+		// targets inside embedded stacks validate the same way they migrate.
+		inst, moreDiags := parseComponentInstance(target)
+		if moreDiags.HasErrors() {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Invalid component instance",
+				fmt.Sprintf("Mapping for %q has an invalid target %q.", source, target),
+			))
+			return
+		}
+		if m.Config.Component(stackaddrs.ConfigComponentForAbsInstance(inst)) == nil {
+			diags = diags.Append(tfdiags.Sourceless(
+				tfdiags.Error,
+				"Component not found",
+				fmt.Sprintf("Mapping for %q targets component %q which is not declared in the stack configuration.", source, target),
+			))
+		}
+	}
+
+	for source, target := range resources {
+		validateTarget(source, target)
+	}
+	for source, target := range modules {
+		validateTarget(source, target)
+	}
+
+	return diags
+}
@@ -0,0 +1,181 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package stackmigrate
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/states"
+	"github.com/hashicorp/terraform/internal/tfdiags"
+)
+
+func TestValidateMapping(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("with-single-input", "valid"))
+
+	state := states.BuildState(func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "testing_resource",
+				Name: "data",
+			}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance),
+			&states.ResourceInstanceObjectSrc{
+				Status:    states.ObjectReady,
+				AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+			},
+			mustDefaultRootProvider("testing"),
+		)
+		ss.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "testing_resource",
+				Name: "child",
+			}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance.Child("child", addrs.NoKey)),
+			&states.ResourceInstanceObjectSrc{
+				Status:    states.ObjectReady,
+				AttrsJSON: []byte(`{"id":"bar","value":"hello"}`),
+			},
+			mustDefaultRootProvider("testing"),
+		)
+	})
+
+	mig := Migration{
+		PreviousState: state,
+		Config:        cfg,
+	}
+
+	tcs := map[string]struct {
+		resources map[string]string
+		modules   map[string]string
+		want      []string
+	}{
+		"all mapped": {
+			resources: map[string]string{"testing_resource.data": "self"},
+			modules:   map[string]string{"child": "self"},
+			want:      nil,
+		},
+		"unmapped resource and module": {
+			resources: map[string]string{},
+			modules:   map[string]string{},
+			want: []string{
+				`[WARNING] Resource not mapped: Resource instance "testing_resource.data" has no entry in the resources mapping and will not be migrated.`,
+				`[WARNING] Module not mapped: Module "child" has no entry in the modules mapping and will not be migrated.`,
+			},
+		},
+		"target is not a component": {
+			resources: map[string]string{"testing_resource.data": "nonexistent"},
+			modules:   map[string]string{"child": "self"},
+			want: []string{
+				`[ERROR] Component not found: Mapping for "testing_resource.data" targets component "nonexistent" which is not declared in the stack configuration.`,
+			},
+		},
+		"wildcard pattern counts as mapped": {
+			resources: map[string]string{"testing_resource.*": "self"},
+			modules:   map[string]string{"child": "self"},
+			want:      nil,
+		},
+		"full child resource address counts as mapped": {
+			resources: map[string]string{
+				"testing_resource.data":               "self",
+				"module.child.testing_resource.child": "self",
+			},
+			want: nil,
+		},
+		"invalid pattern": {
+			resources: map[string]string{"testing_resource.*[": "self"},
+			modules:   map[string]string{"child": "self"},
+			want: []string{
+				`[ERROR] Invalid resource pattern: Resource mapping pattern "testing_resource.*[" is invalid: syntax error in pattern.`,
+			},
+		},
+		"ambiguous patterns": {
+			resources: map[string]string{
+				"testing_resource.*": "self",
+				"*.data":             "self",
+			},
+			modules: map[string]string{"child": "self"},
+			want: []string{
+				`[ERROR] Ambiguous resource mapping: Resource "testing_resource.data" is matched by multiple wildcard patterns: *.data, testing_resource.*.`,
+			},
+		},
+	}
+
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			diags := mig.ValidateMapping(tc.resources, tc.modules)
+
+			got := make(map[string]bool, len(diags))
+			for _, diag := range diags {
+				desc := diag.Description()
+				got[severityPrefix(diag.Severity())+desc.Summary+": "+desc.Detail] = true
+			}
+
+			if len(got) != len(tc.want) {
+				t.Errorf("wrong number of diagnostics: got %d, want %d\n%v", len(got), len(tc.want), got)
+			}
+			for _, want := range tc.want {
+				if !got[want] {
+					t.Errorf("missing diagnostic: %s", want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateMapping_embeddedStackTargets(t *testing.T) {
+	cfg := loadMainBundleConfigForTest(t, filepath.Join("for-stacks-migrate", "with-depends-on"))
+
+	state := states.BuildState(func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(
+			addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "testing_resource",
+				Name: "third",
+			}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance),
+			&states.ResourceInstanceObjectSrc{
+				Status:    states.ObjectReady,
+				AttrsJSON: []byte(`{"id":"foo","value":"hello"}`),
+			},
+			mustDefaultRootProvider("testing"),
+		)
+	})
+
+	mig := Migration{
+		PreviousState: state,
+		Config:        cfg,
+	}
+
+	// A target inside an embedded stack is accepted by Migrate, so the
+	// up-front validation must accept it too.
+	diags := mig.ValidateMapping(map[string]string{
+		"testing_resource.third": "stack.embedded.component.self",
+	}, nil)
+	if len(diags) != 0 {
+		t.Errorf("unexpected diagnostics: %s", diags.Err())
+	}
+
+	// A component that the embedded stack doesn't declare is still an error.
+	diags = mig.ValidateMapping(map[string]string{
+		"testing_resource.third": "stack.embedded.component.missing",
+	}, nil)
+	if len(diags) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %d: %v", len(diags), diags)
+	}
+	if got, want := diags[0].Description().Summary, "Component not found"; got != want {
+		t.Errorf("wrong diagnostic summary: got %q, want %q", got, want)
+	}
+}
+
+func severityPrefix(severity tfdiags.Severity) string {
+	switch severity {
+	case tfdiags.Error:
+		return "[ERROR] "
+	case tfdiags.Warning:
+		return "[WARNING] "
+	default:
+		return "[UNKNOWN] "
+	}
+}
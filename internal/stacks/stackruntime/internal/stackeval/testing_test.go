@@ -276,7 +276,7 @@ func (aot *applyOutputTester) Close(t *testing.T) (*stackstate.State, tfdiags.Di
 	// and deserialize logic to approximate the effect of this having having been
 	// saved and then reloaded during a subsequent planning phase.
 	msgs := aot.RawUpdatedState(t)
-	state, err := stackstate.LoadFromProto(msgs)
+	state, err := stackstate.LoadFromProto(msgs, nil)
 	if err != nil {
 		t.Fatalf("failed to reload saved state: %s", err)
 	}
@@ -0,0 +1,24 @@
+required_providers {
+  testing = {
+    source  = "hashicorp/testing"
+    version = "0.1.0"
+  }
+}
+
+provider "testing" "default" {}
+
+provider "testing" "west" {}
+
+component "self" {
+  source = "./"
+
+  providers = {
+    testing      = provider.testing.default
+    testing.west = provider.testing.west
+  }
+
+  inputs = {
+    id    = "self"
+    input = "self"
+  }
+}
@@ -0,0 +1,30 @@
+terraform {
+  required_providers {
+    testing = {
+      source                = "hashicorp/testing"
+      version               = "0.1.0"
+      configuration_aliases = [testing.west]
+    }
+  }
+}
+
+variable "id" {
+  type     = string
+  default  = null
+  nullable = true # We'll generate an ID if none provided.
+}
+
+variable "input" {
+  type = string
+}
+
+resource "testing_resource" "data" {
+  id    = var.id
+  value = var.input
+}
+
+resource "testing_resource" "west" {
+  provider = testing.west
+
+  value = var.input
+}
@@ -0,0 +1,41 @@
+terraform {
+  required_providers {
+    testing = {
+      source  = "hashicorp/testing"
+      version = "0.1.0"
+    }
+  }
+}
+
+variable "id" {
+  type     = string
+  default  = null
+  nullable = true # We'll generate an ID if none provided.
+}
+
+variable "input" {
+  type = string
+}
+
+resource "testing_resource" "data" {
+  id    = var.id
+  value = var.input
+}
+
+module "a" {
+  source = "./child"
+  input  = var.input
+  providers = {
+    testing = testing
+  }
+
+  depends_on = [module.b]
+}
+
+module "b" {
+  source = "./child"
+  input  = var.input
+  providers = {
+    testing = testing
+  }
+}
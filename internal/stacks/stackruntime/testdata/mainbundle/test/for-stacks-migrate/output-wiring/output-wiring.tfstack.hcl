@@ -0,0 +1,29 @@
+required_providers {
+  testing = {
+    source  = "hashicorp/testing"
+    version = "0.1.0"
+  }
+}
+
+provider "testing" "default" {}
+
+component "producer" {
+  source = "./"
+
+  providers = {
+    testing = provider.testing.default
+  }
+
+  inputs = {
+    id    = "producer"
+    input = "producer"
+  }
+}
+
+stack "consumer" {
+  source = "./consumer"
+
+  inputs = {
+    input = component.producer.id
+  }
+}
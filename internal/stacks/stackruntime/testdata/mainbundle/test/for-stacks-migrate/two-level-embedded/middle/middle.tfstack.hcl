@@ -0,0 +1,18 @@
+required_providers {
+  testing = {
+    source  = "hashicorp/testing"
+    version = "0.1.0"
+  }
+}
+
+variable "input" {
+  type = string
+}
+
+stack "leaf" {
+  source = "./leaf"
+
+  inputs = {
+    input = var.input
+  }
+}
@@ -0,0 +1,34 @@
+required_providers {
+  testing = {
+    source  = "hashicorp/testing"
+    version = "0.1.0"
+  }
+}
+
+provider "testing" "default" {}
+
+variable "input" {
+  type = string
+}
+
+component "top" {
+  source = "./"
+
+  providers = {
+    testing = provider.testing.default
+  }
+
+  inputs = {
+    input = var.input
+  }
+
+  depends_on = [stack.middle]
+}
+
+stack "middle" {
+  source = "./middle"
+
+  inputs = {
+    input = var.input
+  }
+}
@@ -6,6 +6,7 @@ package stackstate
 import (
 	"fmt"
 	"log"
+	"sort"
 	"sync"
 
 	"github.com/zclconf/go-cty/cty"
@@ -18,20 +19,61 @@ import (
 	"github.com/hashicorp/terraform/internal/stacks/stackaddrs"
 	"github.com/hashicorp/terraform/internal/stacks/stackstate/statekeys"
 	"github.com/hashicorp/terraform/internal/stacks/tfstackdata1"
+	"github.com/hashicorp/terraform/internal/states"
 )
 
 // A helper for loading prior state snapshots in a streaming manner.
 type Loader struct {
-	ret *State
-	mu  sync.Mutex
+	ret  *State
+	opts *LoadOptions
+	mu   sync.Mutex
+}
+
+// LoadOptions are optional customizations for how a raw state map is decoded
+// into a [State].
+//
+// A nil *LoadOptions is valid and means to use the default behavior for
+// everything.
+type LoadOptions struct {
+	// OnUnrecognizedKey, if non-nil, is called for each state key whose type
+	// is not recognized by this version of Terraform, with the handling
+	// strategy that the key's naming convention implies. Whatever the
+	// callback returns is used as the effective handling for that key, so a
+	// caller can e.g. downgrade [statekeys.FailIfUnrecognized] to
+	// [statekeys.PreserveIfUnrecognized] to inspect a state produced by a
+	// newer version of Terraform without aborting.
+	//
+	// Overriding the handling of keys that would normally fail produces a
+	// state that is incomplete from the perspective of whatever newer
+	// version created it, so this is intended only for debugging and
+	// forward-compatibility tooling, not for normal plan/apply use.
+	OnUnrecognizedKey func(key statekeys.Key, handling statekeys.UnrecognizedKeyHandling) statekeys.UnrecognizedKeyHandling
+
+	// OnUnknownObjectStatus, if non-nil, is called when a resource instance
+	// object carries a status that this version of Terraform doesn't
+	// recognize, which typically means the state was produced by a newer
+	// version that added an intermediate status. The callback receives the
+	// name of the raw status and returns the status to record instead, so
+	// forward-compatibility tooling can downgrade unknown statuses to a safe
+	// value such as [states.ObjectReady], typically alongside a warning of
+	// its own. When nil, an unknown status aborts the load with an error.
+	OnUnknownObjectStatus func(rawStatus string) (states.ObjectStatus, error)
 }
 
 // Constructs a new [Loader], with an initial empty state.
 func NewLoader() *Loader {
+	return NewLoaderWithOptions(nil)
+}
+
+// NewLoaderWithOptions is like [NewLoader] but allows customizing the
+// loading behavior with a [LoadOptions], which may be nil for the default
+// behavior.
+func NewLoaderWithOptions(opts *LoadOptions) *Loader {
 	ret := NewState()
 	ret.inputRaw = make(map[string]*anypb.Any)
 	return &Loader{
-		ret: ret,
+		ret:  ret,
+		opts: opts,
 	}
 }
 
@@ -61,7 +103,7 @@ func (l *Loader) AddRaw(rawKey string, rawMsg *anypb.Any) error {
 	}
 
 	if !statekeys.RecognizedType(key) {
-		err = handleUnrecognizedKey(key, l.ret)
+		err = handleUnrecognizedKey(key, l.ret, l.opts)
 		if err != nil {
 			return err
 		}
@@ -83,7 +125,7 @@ func (l *Loader) AddRaw(rawKey string, rawMsg *anypb.Any) error {
 		return fmt.Errorf("invalid raw value for raw state key %q: %w", rawKey, err)
 	}
 
-	err = handleProtoMsg(key, msg, l.ret)
+	err = handleProtoMsg(key, msg, l.ret, l.opts)
 	if err != nil {
 		return err
 	}
@@ -132,13 +174,13 @@ func (l *Loader) AddDirectProto(keyStr string, msg protoreflect.ProtoMessage) er
 		return fmt.Errorf("invalid tracking key %q: %w", keyStr, err)
 	}
 	if !statekeys.RecognizedType(key) {
-		err := handleUnrecognizedKey(key, l.ret)
+		err := handleUnrecognizedKey(key, l.ret, l.opts)
 		if err != nil {
 			return err
 		}
 		return nil
 	}
-	err = handleProtoMsg(key, msg, l.ret)
+	err = handleProtoMsg(key, msg, l.ret, l.opts)
 	if err != nil {
 		return err
 	}
@@ -159,8 +201,8 @@ func (l *Loader) State() *State {
 //
 // This is a helper wrapper around [Loader.AddRaw] for when the state was already
 // loaded into a single map.
-func LoadFromProto(msgs map[string]*anypb.Any) (*State, error) {
-	loader := NewLoader()
+func LoadFromProto(msgs map[string]*anypb.Any, opts *LoadOptions) (*State, error) {
+	loader := NewLoaderWithOptions(opts)
 	for rawKey, rawMsg := range msgs {
 		err := loader.AddRaw(rawKey, rawMsg)
 		if err != nil {
@@ -170,6 +212,65 @@ func LoadFromProto(msgs map[string]*anypb.Any) (*State, error) {
 	return loader.State(), nil
 }
 
+// LoadKeyError records a single raw state message that failed to decode
+// during a best-effort load with [LoadFromProtoRecovering].
+type LoadKeyError struct {
+	// Key is the raw tracking key of the message that failed to decode.
+	Key string
+
+	// Err describes why the message could not be decoded.
+	Err error
+}
+
+// LoadFromProtoRecovering is a variation of [LoadFromProto] that collects
+// per-key decoding errors and continues instead of aborting on the first
+// failure, returning both the best-effort [State] built from the messages
+// that did decode and a list describing those that did not, sorted by key.
+//
+// This is intended for forensic inspection of damaged state blobs. Whenever
+// the returned list is non-empty the returned state is incomplete from the
+// perspective of whatever wrote it, so it must not be used for normal
+// plan/apply work.
+func LoadFromProtoRecovering(msgs map[string]*anypb.Any, opts *LoadOptions) (*State, []LoadKeyError) {
+	loader := NewLoaderWithOptions(opts)
+	var errs []LoadKeyError
+	for rawKey, rawMsg := range msgs {
+		err := loader.AddRaw(rawKey, rawMsg)
+		if err != nil {
+			errs = append(errs, LoadKeyError{Key: rawKey, Err: err})
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Key < errs[j].Key
+	})
+	return loader.State(), errs
+}
+
+// AppliedChangesToProto collapses a sequence of applied changes into the raw
+// message map that [LoadFromProto] accepts, as would result from applying
+// each change in order against an empty prior state.
+//
+// A raw change with a nil value represents a deletion and removes any
+// earlier message recorded for the same key, matching how a stacks backend
+// is expected to treat such changes.
+func AppliedChangesToProto(changes []AppliedChange) (map[string]*anypb.Any, error) {
+	msgs := make(map[string]*anypb.Any)
+	for _, change := range changes {
+		proto, err := change.AppliedChangeProto()
+		if err != nil {
+			return nil, err
+		}
+		for _, raw := range proto.Raw {
+			if raw.Value == nil {
+				delete(msgs, raw.Key)
+				continue
+			}
+			msgs[raw.Key] = raw.Value
+		}
+	}
+	return msgs, nil
+}
+
 // LoadFromDirectProto is a variation of the primary entry-point [LoadFromProto]
 // which accepts direct messages of the relevant types from the tfstackdata1
 // package, rather than the [anypb.Raw] representation thereof.
@@ -187,11 +288,15 @@ func LoadFromDirectProto(msgs map[string]protoreflect.ProtoMessage) (*State, err
 	return loader.State(), nil
 }
 
-func handleUnrecognizedKey(key statekeys.Key, state *State) error {
+func handleUnrecognizedKey(key statekeys.Key, state *State, opts *LoadOptions) error {
 	// There are three different strategies for dealing with
 	// unrecognized keys, which we recognize based on naming
 	// conventions of the key types.
-	switch handling := key.KeyType().UnrecognizedKeyHandling(); handling {
+	handling := key.KeyType().UnrecognizedKeyHandling()
+	if opts != nil && opts.OnUnrecognizedKey != nil {
+		handling = opts.OnUnrecognizedKey(key, handling)
+	}
+	switch handling {
 
 	case statekeys.FailIfUnrecognized:
 		// This is for keys whose messages materially change the
@@ -234,14 +339,14 @@ func handleUnrecognizedKey(key statekeys.Key, state *State) error {
 	}
 }
 
-func handleProtoMsg(key statekeys.Key, msg protoreflect.ProtoMessage, state *State) error {
+func handleProtoMsg(key statekeys.Key, msg protoreflect.ProtoMessage, state *State, opts *LoadOptions) error {
 	switch key := key.(type) {
 
 	case statekeys.ComponentInstance:
 		return handleComponentInstanceMsg(key, msg, state)
 
 	case statekeys.ResourceInstanceObject:
-		return handleResourceInstanceObjectMsg(key, msg, state)
+		return handleResourceInstanceObjectMsg(key, msg, state, opts)
 
 	case statekeys.Output:
 		return handleOutputMsg(key, msg, state)
@@ -342,7 +447,7 @@ func handleComponentInstanceMsg(key statekeys.ComponentInstance, msg protoreflec
 	return nil
 }
 
-func handleResourceInstanceObjectMsg(key statekeys.ResourceInstanceObject, msg protoreflect.ProtoMessage, state *State) error {
+func handleResourceInstanceObjectMsg(key statekeys.ResourceInstanceObject, msg protoreflect.ProtoMessage, state *State, opts *LoadOptions) error {
 	fullAddr := stackaddrs.AbsResourceInstanceObject{
 		Component: key.ResourceInstance.Component,
 		Item: addrs.AbsResourceInstanceObject{
@@ -356,10 +461,32 @@ func handleResourceInstanceObjectMsg(key statekeys.ResourceInstanceObject, msg p
 		return fmt.Errorf("unsupported message type %T for state of %s", msg, fullAddr.String())
 	}
 
+	// An unrecognized status normally fails the decode below, but a caller
+	// interested in forward compatibility may supply a fallback mapping for
+	// statuses introduced by newer versions of Terraform.
+	var overrideStatus *states.ObjectStatus
+	if opts != nil && opts.OnUnknownObjectStatus != nil {
+		switch riMsg.Status {
+		case tfstackdata1.StateResourceInstanceObjectV1_READY, tfstackdata1.StateResourceInstanceObjectV1_DAMAGED:
+			// Recognized statuses take the usual path.
+		default:
+			status, err := opts.OnUnknownObjectStatus(riMsg.Status.String())
+			if err != nil {
+				return fmt.Errorf("invalid stored state object for %s: %w", fullAddr, err)
+			}
+			overrideStatus = &status
+			riMsg = proto.Clone(riMsg).(*tfstackdata1.StateResourceInstanceObjectV1)
+			riMsg.Status = tfstackdata1.StateResourceInstanceObjectV1_READY
+		}
+	}
+
 	objSrc, err := tfstackdata1.DecodeProtoResourceInstanceObject(riMsg)
 	if err != nil {
 		return fmt.Errorf("invalid stored state object for %s: %w", fullAddr, err)
 	}
+	if overrideStatus != nil {
+		objSrc.Status = *overrideStatus
+	}
 
 	providerConfigAddr, diags := addrs.ParseAbsProviderConfigStr(riMsg.ProviderConfigAddr)
 	if diags.HasErrors() {
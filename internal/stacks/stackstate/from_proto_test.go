@@ -9,11 +9,14 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/hashicorp/terraform/internal/addrs"
+	"github.com/hashicorp/terraform/internal/configs/configschema"
+	"github.com/hashicorp/terraform/internal/providers"
 	"github.com/hashicorp/terraform/internal/stacks/stackaddrs"
 	"github.com/hashicorp/terraform/internal/stacks/stackstate/statekeys"
 	"github.com/hashicorp/terraform/internal/stacks/tfstackdata1"
 	"github.com/hashicorp/terraform/internal/states"
 	"github.com/zclconf/go-cty/cty"
+	"google.golang.org/protobuf/types/known/anypb"
 )
 
 func TestLoader_basic(t *testing.T) {
@@ -93,6 +96,376 @@ func TestLoader_basic(t *testing.T) {
 	}
 }
 
+func TestLoader_appliedChangesRoundTrip(t *testing.T) {
+	componentInstAddr := stackaddrs.AbsComponentInstance{
+		Stack: stackaddrs.RootStackInstance,
+		Item: stackaddrs.ComponentInstance{
+			Component: stackaddrs.Component{
+				Name: "a",
+			},
+		},
+	}
+	resourceInstAddr := stackaddrs.AbsResourceInstance{
+		Component: componentInstAddr,
+		Item: addrs.AbsResourceInstance{
+			Module: addrs.RootModuleInstance,
+			Resource: addrs.ResourceInstance{
+				Resource: addrs.Resource{
+					Mode: addrs.ManagedResourceMode,
+					Type: "test",
+					Name: "foo",
+				},
+			},
+		},
+	}
+	providerInstAddr := addrs.AbsProviderConfig{
+		Module:   addrs.RootModule,
+		Provider: addrs.NewBuiltInProvider("test"),
+	}
+	src := &states.ResourceInstanceObjectSrc{
+		Status:             states.ObjectReady,
+		AttrsJSON:          []byte(`{"id":"bar"}`),
+		AttrSensitivePaths: []cty.Path{},
+	}
+
+	changes := []AppliedChange{
+		&AppliedChangeComponentInstance{
+			ComponentAddr: stackaddrs.AbsComponent{
+				Stack: stackaddrs.RootStackInstance,
+				Item:  stackaddrs.Component{Name: "a"},
+			},
+			ComponentInstanceAddr: componentInstAddr,
+			OutputValues:          make(map[addrs.OutputValue]cty.Value),
+			InputVariables:        make(map[addrs.InputVariable]cty.Value),
+		},
+		&AppliedChangeResourceInstanceObject{
+			ResourceInstanceObjectAddr: stackaddrs.AbsResourceInstanceObject{
+				Component: componentInstAddr,
+				Item:      resourceInstAddr.Item.CurrentObject(),
+			},
+			ProviderConfigAddr: providerInstAddr,
+			Schema: providers.Schema{
+				Body: &configschema.Block{
+					Attributes: map[string]*configschema.Attribute{
+						"id": {
+							Type:     cty.String,
+							Required: true,
+						},
+					},
+				},
+			},
+			NewStateSrc: src,
+		},
+	}
+
+	msgs, err := AppliedChangesToProto(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := LoadFromProto(msgs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !state.HasComponentInstance(componentInstAddr) {
+		t.Errorf("component instance %s not found in state", componentInstAddr)
+	}
+
+	got := state.ResourceInstanceObjectSrc(
+		stackaddrs.AbsResourceInstanceObject{
+			Component: componentInstAddr,
+			Item:      resourceInstAddr.Item.CurrentObject(),
+		},
+	)
+	if diff := cmp.Diff(src, got, cmpopts.IgnoreUnexported(states.ResourceInstanceObjectSrc{})); diff != "" {
+		t.Errorf("resource instance object did not survive the round trip\ndiff: %s", diff)
+	}
+
+	// A deletion change removes the message for its key again.
+	changes = append(changes, &AppliedChangeResourceInstanceObject{
+		ResourceInstanceObjectAddr: stackaddrs.AbsResourceInstanceObject{
+			Component: componentInstAddr,
+			Item:      resourceInstAddr.Item.CurrentObject(),
+		},
+		ProviderConfigAddr: providerInstAddr,
+		NewStateSrc:        nil,
+	})
+	msgs, err = AppliedChangesToProto(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err = LoadFromProto(msgs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := state.ResourceInstanceObjectSrc(stackaddrs.AbsResourceInstanceObject{
+		Component: componentInstAddr,
+		Item:      resourceInstAddr.Item.CurrentObject(),
+	}); got != nil {
+		t.Errorf("resource instance object should have been deleted, got %#v", got)
+	}
+}
+
+func TestStateToProtoRoundTrip(t *testing.T) {
+	componentInstAddr := stackaddrs.AbsComponentInstance{
+		Stack: stackaddrs.RootStackInstance,
+		Item: stackaddrs.ComponentInstance{
+			Component: stackaddrs.Component{
+				Name: "a",
+			},
+		},
+	}
+	resourceInstAddr := stackaddrs.AbsResourceInstance{
+		Component: componentInstAddr,
+		Item: addrs.AbsResourceInstance{
+			Module: addrs.RootModuleInstance,
+			Resource: addrs.ResourceInstance{
+				Resource: addrs.Resource{
+					Mode: addrs.ManagedResourceMode,
+					Type: "test",
+					Name: "foo",
+				},
+			},
+		},
+	}
+	providerInstAddr := addrs.AbsProviderConfig{
+		Module:   addrs.RootModule,
+		Provider: addrs.NewBuiltInProvider("test"),
+	}
+	dependencyAddr := stackaddrs.AbsComponent{
+		Stack: stackaddrs.RootStackInstance,
+		Item:  stackaddrs.Component{Name: "b"},
+	}
+	dependentAddr := stackaddrs.AbsComponent{
+		Stack: stackaddrs.RootStackInstance,
+		Item:  stackaddrs.Component{Name: "c"},
+	}
+
+	currentSrc := &states.ResourceInstanceObjectSrc{
+		Status:             states.ObjectReady,
+		AttrsJSON:          []byte(`{"id":"bar"}`),
+		AttrSensitivePaths: []cty.Path{},
+		Dependencies: []addrs.ConfigResource{
+			{
+				Module: addrs.RootModule,
+				Resource: addrs.Resource{
+					Mode: addrs.ManagedResourceMode,
+					Type: "test",
+					Name: "dep",
+				},
+			},
+		},
+	}
+	deposedSrc := &states.ResourceInstanceObjectSrc{
+		Status:             states.ObjectReady,
+		AttrsJSON:          []byte(`{"id":"old"}`),
+		AttrSensitivePaths: []cty.Path{},
+	}
+
+	state := NewState()
+	state.addInputVariable(stackaddrs.InputVariable{Name: "in"}, cty.StringVal("in value"))
+	state.addInputVariable(stackaddrs.InputVariable{Name: "eph"}, cty.NilVal)
+	state.addOutputValue(stackaddrs.OutputValue{Name: "out"}, cty.StringVal("out value"))
+	cs := state.ensureComponentInstanceState(componentInstAddr)
+	cs.dependencies.Add(dependencyAddr)
+	cs.dependents.Add(dependentAddr)
+	cs.outputValues[addrs.OutputValue{Name: "id"}] = cty.StringVal("bar")
+	cs.inputVariables[addrs.InputVariable{Name: "name"}] = cty.StringVal("foo")
+	state.addResourceInstanceObject(stackaddrs.AbsResourceInstanceObject{
+		Component: componentInstAddr,
+		Item:      resourceInstAddr.Item.CurrentObject(),
+	}, currentSrc, providerInstAddr)
+	state.addResourceInstanceObject(stackaddrs.AbsResourceInstanceObject{
+		Component: componentInstAddr,
+		Item:      resourceInstAddr.Item.DeposedObject(addrs.DeposedKey("aaaaaaaa")),
+	}, deposedSrc, providerInstAddr)
+
+	msgs, err := state.ToProto()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadFromProto(msgs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.HasComponentInstance(componentInstAddr) {
+		t.Fatalf("component instance %s not found in state", componentInstAddr)
+	}
+	if deps := got.DependenciesForComponent(componentInstAddr); deps.Len() != 1 || !deps.Has(dependencyAddr) {
+		t.Errorf("wrong dependencies for %s: %#v", componentInstAddr, deps)
+	}
+	if deps := got.DependentsForComponent(componentInstAddr); deps.Len() != 1 || !deps.Has(dependentAddr) {
+		t.Errorf("wrong dependents for %s: %#v", componentInstAddr, deps)
+	}
+	if outputs := got.ResultsForComponent(componentInstAddr); len(outputs) != 1 || !outputs[addrs.OutputValue{Name: "id"}].RawEquals(cty.StringVal("bar")) {
+		t.Errorf("wrong output values for %s: %#v", componentInstAddr, outputs)
+	}
+	if inputs := got.InputsForComponent(componentInstAddr); len(inputs) != 1 || !inputs[addrs.InputVariable{Name: "name"}].RawEquals(cty.StringVal("foo")) {
+		t.Errorf("wrong input values for %s: %#v", componentInstAddr, inputs)
+	}
+
+	gotCurrent := got.ResourceInstanceObjectSrc(stackaddrs.AbsResourceInstanceObject{
+		Component: componentInstAddr,
+		Item:      resourceInstAddr.Item.CurrentObject(),
+	})
+	if diff := cmp.Diff(currentSrc, gotCurrent, cmpopts.IgnoreUnexported(states.ResourceInstanceObjectSrc{})); diff != "" {
+		t.Errorf("current object did not survive the round trip\ndiff: %s", diff)
+	}
+	gotDeposed := got.ResourceInstanceObjectSrc(stackaddrs.AbsResourceInstanceObject{
+		Component: componentInstAddr,
+		Item:      resourceInstAddr.Item.DeposedObject(addrs.DeposedKey("aaaaaaaa")),
+	})
+	if diff := cmp.Diff(deposedSrc, gotDeposed, cmpopts.IgnoreUnexported(states.ResourceInstanceObjectSrc{})); diff != "" {
+		t.Errorf("deposed object did not survive the round trip\ndiff: %s", diff)
+	}
+
+	if v := got.RootInputVariable(stackaddrs.InputVariable{Name: "in"}); !v.RawEquals(cty.StringVal("in value")) {
+		t.Errorf("wrong value for input variable \"in\": %#v", v)
+	}
+	// An input with no recorded value is the legacy encoding for an
+	// ephemeral value, which loads as a null.
+	if v := got.RootInputVariable(stackaddrs.InputVariable{Name: "eph"}); !v.RawEquals(cty.NullVal(cty.DynamicPseudoType)) {
+		t.Errorf("wrong value for input variable \"eph\": %#v", v)
+	}
+	if v := got.RootOutputValue(stackaddrs.OutputValue{Name: "out"}); !v.RawEquals(cty.StringVal("out value")) {
+		t.Errorf("wrong value for output value \"out\": %#v", v)
+	}
+}
+
+func TestLoader_sensitivePaths(t *testing.T) {
+	aComponentInstAddr := stackaddrs.AbsComponentInstance{
+		Stack: stackaddrs.RootStackInstance,
+		Item: stackaddrs.ComponentInstance{
+			Component: stackaddrs.Component{
+				Name: "a",
+			},
+		},
+	}
+	aResourceInstAddr := stackaddrs.AbsResourceInstance{
+		Component: aComponentInstAddr,
+		Item: addrs.AbsResourceInstance{
+			Module: addrs.RootModuleInstance,
+			Resource: addrs.ResourceInstance{
+				Resource: addrs.Resource{
+					Mode: addrs.ManagedResourceMode,
+					Type: "test",
+					Name: "foo",
+				},
+			},
+		},
+	}
+	providerInstAddr := addrs.AbsProviderConfig{
+		Module:   addrs.RootModule,
+		Provider: addrs.NewBuiltInProvider("test"),
+	}
+
+	want := &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"credentials":{"password":"hunter2"},"id":"foo"}`),
+		AttrSensitivePaths: []cty.Path{
+			cty.GetAttrPath("credentials").GetAttr("password"),
+		},
+		Status: states.ObjectReady,
+	}
+
+	// Round-trip the object through the proto encoding and the loader, to
+	// prove that the sensitive attribute paths survive.
+	loader := NewLoader()
+	loader.AddDirectProto(
+		statekeys.String(statekeys.ResourceInstanceObject{
+			ResourceInstance: aResourceInstAddr,
+		}),
+		tfstackdata1.ResourceInstanceObjectStateToTFStackData1(want, providerInstAddr),
+	)
+	state := loader.State()
+
+	got := state.ResourceInstanceObjectSrc(
+		stackaddrs.AbsResourceInstanceObject{
+			Component: aComponentInstAddr,
+			Item:      aResourceInstAddr.Item.CurrentObject(),
+		},
+	)
+
+	if got == nil {
+		t.Fatal("resource instance object not found in state")
+	}
+	if diff := cmp.Diff(want.AttrsJSON, got.AttrsJSON); diff != "" {
+		t.Errorf("unexpected attributes\ndiff: %s", diff)
+	}
+	if len(got.AttrSensitivePaths) != len(want.AttrSensitivePaths) {
+		t.Fatalf("wrong number of sensitive paths: got %d, want %d", len(got.AttrSensitivePaths), len(want.AttrSensitivePaths))
+	}
+	for i, wantPath := range want.AttrSensitivePaths {
+		if !wantPath.Equals(got.AttrSensitivePaths[i]) {
+			t.Errorf("wrong sensitive path at index %d", i)
+		}
+	}
+}
+
+func TestLoader_identity(t *testing.T) {
+	aComponentInstAddr := stackaddrs.AbsComponentInstance{
+		Stack: stackaddrs.RootStackInstance,
+		Item: stackaddrs.ComponentInstance{
+			Component: stackaddrs.Component{
+				Name: "a",
+			},
+		},
+	}
+	aResourceInstAddr := stackaddrs.AbsResourceInstance{
+		Component: aComponentInstAddr,
+		Item: addrs.AbsResourceInstance{
+			Module: addrs.RootModuleInstance,
+			Resource: addrs.ResourceInstance{
+				Resource: addrs.Resource{
+					Mode: addrs.ManagedResourceMode,
+					Type: "test",
+					Name: "foo",
+				},
+			},
+		},
+	}
+	providerInstAddr := addrs.AbsProviderConfig{
+		Module:   addrs.RootModule,
+		Provider: addrs.NewBuiltInProvider("test"),
+	}
+
+	want := &states.ResourceInstanceObjectSrc{
+		AttrsJSON:             []byte(`{"id":"foo"}`),
+		IdentityJSON:          []byte(`{"id":"foo"}`),
+		IdentitySchemaVersion: 2,
+		Status:                states.ObjectReady,
+	}
+
+	// Round-trip the object through the proto encoding and the loader, to
+	// prove that the stored resource identity survives.
+	loader := NewLoader()
+	loader.AddDirectProto(
+		statekeys.String(statekeys.ResourceInstanceObject{
+			ResourceInstance: aResourceInstAddr,
+		}),
+		tfstackdata1.ResourceInstanceObjectStateToTFStackData1(want, providerInstAddr),
+	)
+	state := loader.State()
+
+	got := state.ResourceInstanceObjectSrc(
+		stackaddrs.AbsResourceInstanceObject{
+			Component: aComponentInstAddr,
+			Item:      aResourceInstAddr.Item.CurrentObject(),
+		},
+	)
+
+	if got == nil {
+		t.Fatal("resource instance object not found in state")
+	}
+	if diff := cmp.Diff(want.IdentityJSON, got.IdentityJSON); diff != "" {
+		t.Errorf("unexpected identity\ndiff: %s", diff)
+	}
+	if got.IdentitySchemaVersion != want.IdentitySchemaVersion {
+		t.Errorf("wrong identity schema version: got %d, want %d", got.IdentitySchemaVersion, want.IdentitySchemaVersion)
+	}
+}
+
 func TestLoader_consumed(t *testing.T) {
 	loader := NewLoader()
 	loader.State()
@@ -101,3 +474,224 @@ func TestLoader_consumed(t *testing.T) {
 		t.Error("expected error on mutating consumed loader")
 	}
 }
+
+func TestLoader_unknownObjectStatus(t *testing.T) {
+	aComponentInstAddr := stackaddrs.AbsComponentInstance{
+		Stack: stackaddrs.RootStackInstance,
+		Item: stackaddrs.ComponentInstance{
+			Component: stackaddrs.Component{
+				Name: "a",
+			},
+		},
+	}
+	aResourceInstAddr := stackaddrs.AbsResourceInstance{
+		Component: aComponentInstAddr,
+		Item: addrs.AbsResourceInstance{
+			Module: addrs.RootModuleInstance,
+			Resource: addrs.ResourceInstance{
+				Resource: addrs.Resource{
+					Mode: addrs.ManagedResourceMode,
+					Type: "test",
+					Name: "foo",
+				},
+			},
+		},
+	}
+	providerInstAddr := addrs.AbsProviderConfig{
+		Module:   addrs.RootModule,
+		Provider: addrs.NewBuiltInProvider("test"),
+	}
+	keyStr := statekeys.String(statekeys.ResourceInstanceObject{
+		ResourceInstance: aResourceInstAddr,
+	})
+
+	// Simulate a state written by a newer version of Terraform that has
+	// introduced an additional object status.
+	msg := tfstackdata1.ResourceInstanceObjectStateToTFStackData1(&states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}, providerInstAddr)
+	msg.Status = tfstackdata1.StateResourceInstanceObjectV1_Status(99)
+
+	// Without a fallback the unknown status aborts the load.
+	if err := NewLoader().AddDirectProto(keyStr, msg); err == nil {
+		t.Fatal("unexpected success; want error for unknown object status")
+	}
+
+	// With a fallback the object is downgraded to the status that the
+	// callback chooses.
+	var gotRaw string
+	loader := NewLoaderWithOptions(&LoadOptions{
+		OnUnknownObjectStatus: func(rawStatus string) (states.ObjectStatus, error) {
+			gotRaw = rawStatus
+			return states.ObjectReady, nil
+		},
+	})
+	if err := loader.AddDirectProto(keyStr, msg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if gotRaw != "99" {
+		t.Errorf("wrong raw status passed to callback: got %q, want %q", gotRaw, "99")
+	}
+
+	got := loader.State().ResourceInstanceObjectSrc(stackaddrs.AbsResourceInstanceObject{
+		Component: aComponentInstAddr,
+		Item:      aResourceInstAddr.Item.CurrentObject(),
+	})
+	if got == nil {
+		t.Fatal("resource instance object not found in state")
+	}
+	if got.Status != states.ObjectReady {
+		t.Errorf("wrong status: got %v, want %v", got.Status, states.ObjectReady)
+	}
+}
+
+func TestLoadFromProtoRecovering(t *testing.T) {
+	aComponentInstAddr := stackaddrs.AbsComponentInstance{
+		Stack: stackaddrs.RootStackInstance,
+		Item: stackaddrs.ComponentInstance{
+			Component: stackaddrs.Component{
+				Name: "a",
+			},
+		},
+	}
+	resourceInstAddr := func(name string) stackaddrs.AbsResourceInstance {
+		return stackaddrs.AbsResourceInstance{
+			Component: aComponentInstAddr,
+			Item: addrs.AbsResourceInstance{
+				Module: addrs.RootModuleInstance,
+				Resource: addrs.ResourceInstance{
+					Resource: addrs.Resource{
+						Mode: addrs.ManagedResourceMode,
+						Type: "test",
+						Name: name,
+					},
+				},
+			},
+		}
+	}
+	providerInstAddr := addrs.AbsProviderConfig{
+		Module:   addrs.RootModule,
+		Provider: addrs.NewBuiltInProvider("test"),
+	}
+
+	componentKey := statekeys.String(statekeys.ComponentInstance{
+		ComponentInstanceAddr: aComponentInstAddr,
+	})
+	componentMsg, err := anypb.New(&tfstackdata1.StateComponentInstanceV1{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	goodKey := statekeys.String(statekeys.ResourceInstanceObject{
+		ResourceInstance: resourceInstAddr("foo"),
+	})
+	goodMsg, err := anypb.New(tfstackdata1.ResourceInstanceObjectStateToTFStackData1(&states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(`{"id":"foo"}`),
+		Status:    states.ObjectReady,
+	}, providerInstAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The bad message claims to be a resource instance object but its
+	// payload is not a valid protobuf encoding at all.
+	badKey := statekeys.String(statekeys.ResourceInstanceObject{
+		ResourceInstance: resourceInstAddr("bar"),
+	})
+	badMsg := &anypb.Any{
+		TypeUrl: goodMsg.TypeUrl,
+		Value:   []byte{0x01, 0x02, 0x03},
+	}
+
+	msgs := map[string]*anypb.Any{
+		componentKey: componentMsg,
+		goodKey:      goodMsg,
+		badKey:       badMsg,
+	}
+
+	// The fail-fast entry point may or may not hit the corrupt message
+	// first depending on map iteration order, so only the recovering
+	// variant gives a useful answer here.
+	state, keyErrs := LoadFromProtoRecovering(msgs, nil)
+
+	if got, want := len(keyErrs), 1; got != want {
+		t.Fatalf("wrong number of key errors: got %d, want %d", got, want)
+	}
+	if got, want := keyErrs[0].Key, badKey; got != want {
+		t.Errorf("wrong key in error entry\ngot:  %s\nwant: %s", got, want)
+	}
+	if keyErrs[0].Err == nil {
+		t.Error("key error entry has nil Err")
+	}
+
+	if !state.HasComponentInstance(aComponentInstAddr) {
+		t.Errorf("component instance %s not found in state", aComponentInstAddr)
+	}
+	if got := state.ResourceInstanceObjectSrc(stackaddrs.AbsResourceInstanceObject{
+		Component: aComponentInstAddr,
+		Item:      resourceInstAddr("foo").Item.CurrentObject(),
+	}); got == nil {
+		t.Error("good resource instance object not found in state")
+	}
+	if got := state.ResourceInstanceObjectSrc(stackaddrs.AbsResourceInstanceObject{
+		Component: aComponentInstAddr,
+		Item:      resourceInstAddr("bar").Item.CurrentObject(),
+	}); got != nil {
+		t.Error("corrupt resource instance object unexpectedly present in state")
+	}
+}
+
+func TestLoader_unrecognizedKeyOverride(t *testing.T) {
+	// "AAAA" is not a real key type, and its all-uppercase naming convention
+	// means an unrecognized key of this type would normally be treated as
+	// FailIfUnrecognized.
+	msgs := map[string]*anypb.Any{
+		"AAAA": nil,
+	}
+
+	if _, err := LoadFromProto(msgs, nil); err == nil {
+		t.Fatalf("unexpected success; want error for unrecognized mandatory key")
+	}
+
+	var gotHandling statekeys.UnrecognizedKeyHandling
+	state, err := LoadFromProto(msgs, &LoadOptions{
+		OnUnrecognizedKey: func(key statekeys.Key, handling statekeys.UnrecognizedKeyHandling) statekeys.UnrecognizedKeyHandling {
+			gotHandling = handling
+			return statekeys.PreserveIfUnrecognized
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if gotHandling != statekeys.FailIfUnrecognized {
+		t.Errorf("wrong handling passed to callback\ngot:  %s\nwant: %s", gotHandling, statekeys.FailIfUnrecognized)
+	}
+	if state.RawKeysToDiscard().Len() != 0 {
+		t.Errorf("unexpected keys to discard; preserved keys should not be recorded for discard")
+	}
+}
+
+func TestLoader_discardedUnrecognizedKeys(t *testing.T) {
+	// Lowercase first and last letters mark these fake key types as
+	// DiscardIfUnrecognized.
+	msgs := map[string]*anypb.Any{
+		"bbbb": nil,
+		"aaaa": nil,
+	}
+
+	state, err := LoadFromProto(msgs, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got := state.DiscardedUnrecognizedKeys()
+	var gotStrs []string
+	for _, key := range got {
+		gotStrs = append(gotStrs, statekeys.String(key))
+	}
+	want := []string{"aaaa", "bbbb"}
+	if diff := cmp.Diff(want, gotStrs); diff != "" {
+		t.Errorf("wrong discarded keys\n%s", diff)
+	}
+}
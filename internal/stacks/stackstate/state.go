@@ -4,6 +4,9 @@
 package stackstate
 
 import (
+	"slices"
+	"strings"
+
 	"github.com/zclconf/go-cty/cty"
 	"google.golang.org/protobuf/types/known/anypb"
 
@@ -260,6 +263,18 @@ func (s *State) RequiredProviderInstances(component stackaddrs.AbsComponentInsta
 	return providerInstances
 }
 
+// ResourceInstanceProviderConfig returns the address of the provider
+// configuration that most recently managed the resource instance object at
+// the given address, or the zero value of [addrs.AbsProviderConfig] if no
+// such object is tracked in the state.
+func (s *State) ResourceInstanceProviderConfig(addr stackaddrs.AbsResourceInstanceObject) addrs.AbsProviderConfig {
+	rios := s.resourceInstanceObjectState(addr)
+	if rios == nil {
+		return addrs.AbsProviderConfig{}
+	}
+	return rios.providerConfigAddr
+}
+
 func (s *State) resourceInstanceObjectState(addr stackaddrs.AbsResourceInstanceObject) *resourceInstanceObjectState {
 	cs, ok := s.componentInstances.GetOk(addr.Component)
 	if !ok {
@@ -304,6 +319,21 @@ func (s *State) RawKeysToDiscard() collections.Set[statekeys.Key] {
 	return s.discardUnsupportedKeys
 }
 
+// DiscardedUnrecognizedKeys returns the state keys that were discarded during
+// decoding because they are of types that this version of Terraform doesn't
+// recognize, so that callers can report exactly which ancillary data was
+// dropped when loading a state created by a newer version.
+//
+// The result is sorted by the string representation of each key, so it's
+// stable between calls and suitable for logging.
+func (s *State) DiscardedUnrecognizedKeys() []statekeys.Key {
+	ret := slices.Collect(s.discardUnsupportedKeys.All())
+	slices.SortFunc(ret, func(a, b statekeys.Key) int {
+		return strings.Compare(statekeys.String(a), statekeys.String(b))
+	})
+	return ret
+}
+
 // InputRaw returns the raw representation of state that this object was built
 // from, or nil if this object wasn't constructed by decoding a protocol buffers
 // representation.
@@ -0,0 +1,127 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: BUSL-1.1
+
+package stackstate
+
+import (
+	"fmt"
+
+	"github.com/zclconf/go-cty/cty"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/hashicorp/terraform/internal/rpcapi/terraform1/stacks"
+	"github.com/hashicorp/terraform/internal/stacks/stackaddrs"
+	"github.com/hashicorp/terraform/internal/stacks/stackstate/statekeys"
+	"github.com/hashicorp/terraform/internal/stacks/tfstackdata1"
+)
+
+// ToProto encodes the state into the raw message map form that
+// [LoadFromProto] consumes, such that loading the result produces a state
+// equivalent to the receiver.
+//
+// The encoding always works from the in-memory model rather than reusing any
+// raw messages the state was originally loaded from, which makes this
+// suitable both for round-trip testing of the serialization contract and for
+// producing a raw map from a state that was assembled programmatically.
+//
+// Messages for unrecognized key types that were preserved during loading are
+// not reproduced here, since those exist only in the original raw input.
+func (s *State) ToProto() (map[string]*anypb.Any, error) {
+	msgs := make(map[string]*anypb.Any)
+	put := func(key string, msg proto.Message) error {
+		var raw anypb.Any
+		if err := anypb.MarshalFrom(&raw, msg, proto.MarshalOptions{}); err != nil {
+			return fmt.Errorf("encoding raw state for %q: %w", key, err)
+		}
+		msgs[key] = &raw
+		return nil
+	}
+	dynamicValue := func(val cty.Value) (*tfstackdata1.DynamicValue, error) {
+		protoValue, err := stacks.ToDynamicValue(val, cty.DynamicPseudoType)
+		if err != nil {
+			return nil, err
+		}
+		return tfstackdata1.Terraform1ToStackDataDynamicValue(protoValue), nil
+	}
+
+	for addr, val := range s.inputs {
+		key := statekeys.String(statekeys.Variable{VariableAddr: addr})
+		if val == cty.NilVal {
+			// An ephemeral input whose value wasn't persisted, which the
+			// wire format represents as an empty message.
+			if err := put(key, &emptypb.Empty{}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		dv, err := dynamicValue(val)
+		if err != nil {
+			return nil, fmt.Errorf("encoding %s: %w", addr, err)
+		}
+		if err := put(key, dv); err != nil {
+			return nil, err
+		}
+	}
+
+	for addr, val := range s.outputs {
+		key := statekeys.String(statekeys.Output{OutputAddr: addr})
+		dv, err := dynamicValue(val)
+		if err != nil {
+			return nil, fmt.Errorf("encoding %s: %w", addr, err)
+		}
+		if err := put(key, dv); err != nil {
+			return nil, err
+		}
+	}
+
+	for addr, cs := range s.componentInstances.All() {
+		key := statekeys.String(statekeys.ComponentInstance{
+			ComponentInstanceAddr: addr,
+		})
+		msg := &tfstackdata1.StateComponentInstanceV1{
+			OutputValues:   make(map[string]*tfstackdata1.DynamicValue, len(cs.outputValues)),
+			InputVariables: make(map[string]*tfstackdata1.DynamicValue, len(cs.inputVariables)),
+		}
+		for outputAddr, val := range cs.outputValues {
+			dv, err := dynamicValue(val)
+			if err != nil {
+				return nil, fmt.Errorf("encoding output value %q for %s: %w", outputAddr.Name, addr, err)
+			}
+			msg.OutputValues[outputAddr.Name] = dv
+		}
+		for inputAddr, val := range cs.inputVariables {
+			dv, err := dynamicValue(val)
+			if err != nil {
+				return nil, fmt.Errorf("encoding input value %q for %s: %w", inputAddr.Name, addr, err)
+			}
+			msg.InputVariables[inputAddr.Name] = dv
+		}
+		for dependency := range cs.dependencies.All() {
+			msg.DependencyAddrs = append(msg.DependencyAddrs, dependency.String())
+		}
+		for dependent := range cs.dependents.All() {
+			msg.DependentAddrs = append(msg.DependentAddrs, dependent.String())
+		}
+		if err := put(key, msg); err != nil {
+			return nil, err
+		}
+
+		for _, elem := range cs.resourceInstanceObjects.Elems {
+			objKey := statekeys.String(statekeys.ResourceInstanceObject{
+				ResourceInstance: stackaddrs.AbsResourceInstance{
+					Component: addr,
+					Item:      elem.Key.ResourceInstance,
+				},
+				DeposedKey: elem.Key.DeposedKey,
+			})
+			objMsg := tfstackdata1.ResourceInstanceObjectStateToTFStackData1(elem.Value.src, elem.Value.providerConfigAddr)
+			if err := put(objKey, objMsg); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return msgs, nil
+}
@@ -31,12 +31,14 @@ func ResourceInstanceObjectStateToTFStackData1(objSrc *states.ResourceInstanceOb
 	// actually do anything with the value part of this.
 	protoValue := stacks.NewDynamicValue(plans.DynamicValue(nil), objSrc.AttrSensitivePaths)
 	rawMsg := &StateResourceInstanceObjectV1{
-		SchemaVersion:        objSrc.SchemaVersion,
-		ValueJson:            objSrc.AttrsJSON,
-		SensitivePaths:       Terraform1ToPlanProtoAttributePaths(protoValue.Sensitive),
-		CreateBeforeDestroy:  objSrc.CreateBeforeDestroy,
-		ProviderConfigAddr:   providerConfigAddr.String(),
-		ProviderSpecificData: objSrc.Private,
+		SchemaVersion:         objSrc.SchemaVersion,
+		ValueJson:             objSrc.AttrsJSON,
+		SensitivePaths:        Terraform1ToPlanProtoAttributePaths(protoValue.Sensitive),
+		CreateBeforeDestroy:   objSrc.CreateBeforeDestroy,
+		ProviderConfigAddr:    providerConfigAddr.String(),
+		ProviderSpecificData:  objSrc.Private,
+		IdentityJson:          objSrc.IdentityJSON,
+		IdentitySchemaVersion: objSrc.IdentitySchemaVersion,
 	}
 	switch objSrc.Status {
 	case states.ObjectReady:
@@ -47,14 +49,53 @@ func ResourceInstanceObjectStateToTFStackData1(objSrc *states.ResourceInstanceOb
 		rawMsg.Status = StateResourceInstanceObjectV1_UNKNOWN
 	}
 
-	rawMsg.Dependencies = make([]string, len(objSrc.Dependencies))
-	for i, addr := range objSrc.Dependencies {
-		rawMsg.Dependencies[i] = addr.String()
-	}
+	rawMsg.Dependencies = EncodeDependencies(objSrc.Dependencies)
 
 	return rawMsg
 }
 
+// EncodeDependencies encodes the given config resource addresses into the
+// wire string form used in the Dependencies field of
+// [StateResourceInstanceObjectV1].
+//
+// This is the inverse of [DecodeDependencies], and the two functions must
+// remain symmetrical so that the encode and decode paths cannot drift:
+// DecodeDependencies(EncodeDependencies(x)) always succeeds and returns x.
+func EncodeDependencies(deps []addrs.ConfigResource) []string {
+	if len(deps) == 0 {
+		return nil
+	}
+	ret := make([]string, len(deps))
+	for i, addr := range deps {
+		ret[i] = addr.String()
+	}
+	return ret
+}
+
+// DecodeDependencies decodes dependency addresses from the wire string form
+// used in the Dependencies field of [StateResourceInstanceObjectV1],
+// rejecting any string that isn't in config-resource form.
+func DecodeDependencies(raws []string) ([]addrs.ConfigResource, error) {
+	if len(raws) == 0 {
+		return nil, nil
+	}
+	ret := make([]addrs.ConfigResource, len(raws))
+	for i, raw := range raws {
+		instAddr, diags := addrs.ParseAbsResourceInstanceStr(raw)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("invalid dependency %q", raw)
+		}
+		// We used the resource instance address parser here but we
+		// actually want the "config resource" subset of that syntax only.
+		configAddr := instAddr.ConfigResource()
+		if configAddr.String() != instAddr.String() {
+			return nil, fmt.Errorf("invalid dependency %q", raw)
+		}
+		ret[i] = configAddr
+	}
+	return ret, nil
+}
+
 func Terraform1ToStackDataDynamicValue(value *stacks.DynamicValue) *DynamicValue {
 	return &DynamicValue{
 		Value: &planproto.DynamicValue{
@@ -158,10 +199,12 @@ func Terraform1ToPlanProtoAttributePathStep(step *stacks.AttributePath_Step) *pl
 
 func DecodeProtoResourceInstanceObject(protoObj *StateResourceInstanceObjectV1) (*states.ResourceInstanceObjectSrc, error) {
 	objSrc := &states.ResourceInstanceObjectSrc{
-		SchemaVersion:       protoObj.SchemaVersion,
-		AttrsJSON:           protoObj.ValueJson,
-		CreateBeforeDestroy: protoObj.CreateBeforeDestroy,
-		Private:             protoObj.ProviderSpecificData,
+		SchemaVersion:         protoObj.SchemaVersion,
+		AttrsJSON:             protoObj.ValueJson,
+		CreateBeforeDestroy:   protoObj.CreateBeforeDestroy,
+		Private:               protoObj.ProviderSpecificData,
+		IdentityJSON:          protoObj.IdentityJson,
+		IdentitySchemaVersion: protoObj.IdentitySchemaVersion,
 	}
 
 	switch protoObj.Status {
@@ -183,22 +226,11 @@ func DecodeProtoResourceInstanceObject(protoObj *StateResourceInstanceObjectV1)
 	}
 	objSrc.AttrSensitivePaths = paths
 
-	if len(protoObj.Dependencies) != 0 {
-		objSrc.Dependencies = make([]addrs.ConfigResource, len(protoObj.Dependencies))
-		for i, raw := range protoObj.Dependencies {
-			instAddr, diags := addrs.ParseAbsResourceInstanceStr(raw)
-			if diags.HasErrors() {
-				return nil, fmt.Errorf("invalid dependency %q", raw)
-			}
-			// We used the resource instance address parser here but we
-			// actually want the "config resource" subset of that syntax only.
-			configAddr := instAddr.ConfigResource()
-			if configAddr.String() != instAddr.String() {
-				return nil, fmt.Errorf("invalid dependency %q", raw)
-			}
-			objSrc.Dependencies[i] = configAddr
-		}
+	deps, err := DecodeDependencies(protoObj.Dependencies)
+	if err != nil {
+		return nil, err
 	}
+	objSrc.Dependencies = deps
 
 	return objSrc, nil
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/zclconf/go-cty/cty/msgpack"
 	"google.golang.org/protobuf/testing/protocmp"
 
+	"github.com/hashicorp/terraform/internal/addrs"
 	"github.com/hashicorp/terraform/internal/lang/marks"
 	"github.com/hashicorp/terraform/internal/plans/planproto"
 	"github.com/hashicorp/terraform/internal/rpcapi/terraform1/stacks"
@@ -85,6 +86,49 @@ func TestDynamicValueToTFStackData1(t *testing.T) {
 	}
 }
 
+func TestDependenciesRoundTrip(t *testing.T) {
+	want := []addrs.ConfigResource{
+		addrs.ConfigResource{
+			Module: addrs.RootModule,
+			Resource: addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "test",
+				Name: "a",
+			},
+		},
+		addrs.ConfigResource{
+			Module: addrs.Module{"child"},
+			Resource: addrs.Resource{
+				Mode: addrs.ManagedResourceMode,
+				Type: "test",
+				Name: "b",
+			},
+		},
+		addrs.ConfigResource{
+			Module: addrs.Module{"child", "grandchild"},
+			Resource: addrs.Resource{
+				Mode: addrs.DataResourceMode,
+				Type: "test",
+				Name: "c",
+			},
+		},
+	}
+
+	got, err := DecodeDependencies(EncodeDependencies(want))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("dependencies did not survive the round trip\n%s", diff)
+	}
+
+	// Instance addresses are not valid in the wire form, because the
+	// decoder accepts only the config-resource subset of the syntax.
+	if _, err := DecodeDependencies([]string{`test.a["foo"]`}); err == nil {
+		t.Error("expected error for indexed instance address, got none")
+	}
+}
+
 func TestDynamicValueFromTFStackData1(t *testing.T) {
 	startVal := cty.ObjectVal(map[string]cty.Value{
 		"a": cty.StringVal("a").Mark(marks.Sensitive),
@@ -21,7 +21,7 @@ func newContextPlugins(
 	provisionerFactories map[string]provisioners.Factory,
 	preloadedProviderSchemas map[addrs.Provider]providers.ProviderSchema,
 ) *loadschemas.Plugins {
-	return loadschemas.NewPlugins(providerFactories, provisionerFactories, preloadedProviderSchemas)
+	return loadschemas.NewPlugins(providerFactories, provisionerFactories, preloadedProviderSchemas, false)
 }
 
 // Schemas is a deprecated old name for schemarepo.Schemas